@@ -5,6 +5,9 @@ For example:
 	http://localhost:8000/lissajous
 	http://localhost:8000/lissajous?cycles=2&res=0.001&size=400&nframes=100&delay=10
 	http://localhost:8000/counter
+
+The parameters are bounded, so a request cannot make the server allocate
+gigabytes, and each client IP is rate limited by the httplimit middleware.
 */
 package main
 
@@ -19,6 +22,8 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+
+	"github.com/rajkumar-km/go-play/go-excercises/httplimit"
 )
 
 func main() {
@@ -31,9 +36,18 @@ func main() {
 	// A common handler for all the other URLs
 	http.HandleFunc("/", handler)
 
+	// Limit the clients, as every lissajous request takes a lot of CPU and memory
+	limiter := httplimit.New(httplimit.Config{
+		IPRate:        2,
+		IPBurst:       5,
+		MaxConcurrent: 8,
+		MaxBodyBytes:  1 << 20,
+		MaxQueryBytes: 1 << 10,
+	})
+
 	// Register the server to listen and serve from localhost:8000
 	fmt.Println("Server listing on localhost:8000")
-	http.ListenAndServe("localhost:8000", nil)
+	log.Fatal(http.ListenAndServe("localhost:8000", limiter.Handler(http.DefaultServeMux)))
 }
 
 // Use sync.Mutex to allow concurrent access to request counter
@@ -54,7 +68,7 @@ func lissajous(w http.ResponseWriter, r *http.Request) {
 
 	cycles, res, size, nframes, delay, err := parseLissajousParams(r)
 	if err != nil {
-		fmt.Fprintf(w, "%s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -78,6 +92,17 @@ func lissajous(w http.ResponseWriter, r *http.Request) {
 	gif.EncodeAll(w, &anim) // NOTE: ignoring encoding errors
 }
 
+// Bounds of the lissajous parameters
+const (
+	maxCycles = 100
+	minRes    = 0.0001
+	maxSize   = 1000
+	maxFrames = 500
+	maxDelay  = 1000
+	maxPixels = 64 << 20   // pixels of all the frames, one byte each
+	maxPoints = 20_000_000 // points plotted in all the frames
+)
+
 // parseLissajousParams parses the HTTP request parameters for lissajous image
 func parseLissajousParams(r *http.Request) (float64, float64, int, int, int, error) {
 	var (
@@ -129,6 +154,26 @@ func parseLissajousParams(r *http.Request) (float64, float64, int, int, int, err
 		delay = int(v)
 	}
 
+	switch {
+	case cycles <= 0 || cycles > maxCycles:
+		return 0, 0, 0, 0, 0, fmt.Errorf("cycles must be in (0, %d]", maxCycles)
+	case !(res >= minRes): // also rejects NaN
+		return 0, 0, 0, 0, 0, fmt.Errorf("res must be at least %g", minRes)
+	case size < 1 || size > maxSize:
+		return 0, 0, 0, 0, 0, fmt.Errorf("size must be in [1, %d]", maxSize)
+	case nframes < 1 || nframes > maxFrames:
+		return 0, 0, 0, 0, 0, fmt.Errorf("nframes must be in [1, %d]", maxFrames)
+	case delay < 0 || delay > maxDelay:
+		return 0, 0, 0, 0, 0, fmt.Errorf("delay must be in [0, %d]", maxDelay)
+	}
+	side := 2*size + 1
+	if side*side*nframes > maxPixels {
+		return 0, 0, 0, 0, 0, fmt.Errorf("size %d with %d frames is too large", size, nframes)
+	}
+	if cycles*2*math.Pi/res*float64(nframes) > maxPoints {
+		return 0, 0, 0, 0, 0, fmt.Errorf("cycles %g with res %g and %d frames is too many points", cycles, res, nframes)
+	}
+
 	return cycles, res, size, nframes, delay, nil
 }
 
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+// adminTmpl contains the pages of the admin UI. html/template escapes the
+// item fields by their context, so the names and descriptions entered by
+// users cannot inject HTML or scripts.
+const adminTmpl = `{{define "layout"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} - Inventory admin</title>
+<style>
+  body { font-family: sans-serif; margin: 2em; }
+  table { border-collapse: collapse; }
+  th, td { border-bottom: 1px solid #ddd; padding: 4px 10px; text-align: left; }
+  td.num { text-align: right; }
+  .flash { padding: 8px; margin-bottom: 1em; }
+  .success { background: #dfd; }
+  .error { background: #fdd; }
+  form.inline { display: inline; }
+</style>
+</head>
+<body>
+<h1><a href="{{.Base}}/admin">Inventory</a></h1>
+{{with .Flash}}<p class="flash {{.Kind}}">{{.Message}}</p>{{end}}
+{{if .Item}}{{template "edit" .}}{{else}}{{template "list" .}}{{end}}
+</body>
+</html>{{end}}
+
+{{define "list"}}
+<table>
+  <tr>
+    {{range .Columns}}<th><a href="{{.URL}}">{{.Label}}</a>{{.Arrow}}</th>{{end}}
+    <th></th>
+  </tr>
+  {{range .Items}}
+  <tr>
+    <td><a href="{{$.Base}}/admin/edit?item={{.Name}}">{{.Name}}</a></td>
+    <td>{{.SKU}}</td>
+    <td class="num">{{.Price}}</td>
+    <td class="num">{{.Quantity}}</td>
+    <td class="num">{{.Available}}</td>
+    <td>
+      <form class="inline" method="post" action="{{$.Base}}/admin/delete" onsubmit="return confirm('Delete the item?')">
+        <input type="hidden" name="csrf_token" value="{{$.CSRF}}">
+        <input type="hidden" name="item" value="{{.Name}}">
+        <input type="hidden" name="version" value="{{.Version}}">
+        <button>Delete</button>
+      </form>
+    </td>
+  </tr>
+  {{else}}
+  <tr><td colspan="6">No items</td></tr>
+  {{end}}
+</table>
+
+<h2>New item</h2>
+<form method="post" action="{{.Base}}/admin/create">
+  <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+  {{template "fields" .}}
+  <button>Create</button>
+</form>
+{{end}}
+
+{{define "edit"}}
+<h2>Edit {{.Item.Name}}</h2>
+<form method="post" action="{{.Base}}/admin/edit?item={{.Item.Name}}">
+  <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+  <input type="hidden" name="version" value="{{.Item.Version}}">
+  {{template "fields" .}}
+  <button>Save</button> <a href="{{.Base}}/admin">Cancel</a>
+</form>
+{{end}}
+
+{{define "fields"}}
+<p>
+  {{if not .Item}}<label>Name <input name="item" required></label>{{end}}
+  <label>SKU <input name="sku" value="{{with .Item}}{{.SKU}}{{end}}"></label>
+  <label>Price <input name="price" required value="{{with .Item}}{{.Price.Decimal}}{{end}}"></label>
+  <select name="currency">
+    {{range .Currencies}}<option{{if eq . $.Currency}} selected{{end}}>{{.}}</option>{{end}}
+  </select>
+  <label>Quantity <input name="quantity" type="number" min="0" value="{{with .Item}}{{.Quantity}}{{else}}0{{end}}"></label>
+</p>
+<p>
+  <label>Description <input name="description" size="40" value="{{with .Item}}{{.Description}}{{end}}"></label>
+  <label>Tags <input name="tags" placeholder="comma separated" value="{{with .Item}}{{join .Tags ", "}}{{end}}"></label>
+</p>
+{{end}}`
+
+// adminPages parses the template at startup, so the mistakes panic early
+var adminPages = template.Must(
+	template.New("admin").Funcs(template.FuncMap{"join": strings.Join}).Parse(adminTmpl),
+)
+
+// Cookies of the admin UI, only sent back to /admin
+const (
+	csrfCookie  = "csrf_token"
+	flashCookie = "flash"
+)
+
+// flash is a message shown once on the page after a redirect
+type flash struct {
+	Kind    string // "success" or "error"
+	Message string
+}
+
+// column is a sortable column of the item table
+type column struct {
+	Label string
+	URL   string
+	Arrow string // shows the current order
+}
+
+// adminPage is the data of the admin templates
+type adminPage struct {
+	Base       string // path prefix of the tenant
+	Title      string
+	Flash      *flash
+	CSRF       string
+	Columns    []column
+	Items      []itemJSON
+	Item       *inventory.Item // the item being edited
+	Currency   string          // selected in the form
+	Currencies []string
+}
+
+// adminSorts are the columns of the table that can be sorted
+var adminSorts = []struct{ key, label string }{
+	{"name", "Name"}, {"sku", "SKU"}, {"price", "Price"}, {"quantity", "Quantity"}, {"available", "Available"},
+}
+
+// admin serves the HTML admin UI for editing the items in a browser
+//
+//	GET  /admin?sort=price&order=desc - the sortable item table and a form to create items
+//	GET  /admin/edit?item=name        - a form to edit the item
+//	POST /admin/create                - create an item
+//	POST /admin/edit?item=name        - save the item
+//	POST /admin/delete                - delete an item
+//
+// The changes redirect back to a page showing a flash message. The forms
+// carry a CSRF token matching a cookie, which other sites cannot read.
+func (d *database) admin(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		if !validCSRF(r) {
+			http.Error(w, "invalid CSRF token, reload the page", http.StatusForbidden)
+			return
+		}
+	}
+
+	switch {
+	case r.URL.Path == "/admin" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		d.adminList(w, r)
+	case r.URL.Path == "/admin/edit" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+		d.adminEdit(w, r)
+	case r.URL.Path == "/admin/create" && r.Method == http.MethodPost:
+		d.adminCreate(w, r)
+	case r.URL.Path == "/admin/edit" && r.Method == http.MethodPost:
+		d.adminSave(w, r)
+	case r.URL.Path == "/admin/delete" && r.Method == http.MethodPost:
+		d.adminDelete(w, r)
+	case r.URL.Path == "/admin/" || r.URL.Path == "/admin/create" || r.URL.Path == "/admin/delete":
+		http.Redirect(w, r, basePath(r)+"/admin", http.StatusSeeOther)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// adminList renders the item table sorted by the column of the request
+func (d *database) adminList(w http.ResponseWriter, r *http.Request) {
+	items, err := d.store.List()
+	if err != nil {
+		d.adminError(w, r, err)
+		return
+	}
+
+	by, desc := r.URL.Query().Get("sort"), r.URL.Query().Get("order") == "desc"
+	less := map[string]func(a, b inventory.Item) bool{
+		"name": func(a, b inventory.Item) bool { return a.Name < b.Name },
+		"sku":  func(a, b inventory.Item) bool { return a.SKU < b.SKU },
+		// The prices of different currencies are not comparable, so they are grouped
+		"price": func(a, b inventory.Item) bool {
+			if a.Price.Currency != b.Price.Currency {
+				return a.Price.Currency < b.Price.Currency
+			}
+			return a.Price.Amount < b.Price.Amount
+		},
+		"quantity":  func(a, b inventory.Item) bool { return a.Quantity < b.Quantity },
+		"available": func(a, b inventory.Item) bool { return a.Available() < b.Available() },
+	}[by]
+	if less == nil {
+		by, less = "name", func(a, b inventory.Item) bool { return a.Name < b.Name }
+	}
+	// The list is sorted by name, so the ties stay in the name order
+	sort.SliceStable(items, func(i, j int) bool {
+		if desc {
+			return less(items[j], items[i])
+		}
+		return less(items[i], items[j])
+	})
+
+	page, err := d.newAdminPage(w, r, "Items")
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	for _, it := range items {
+		page.Items = append(page.Items, newItemJSON(it))
+	}
+	for _, s := range adminSorts {
+		c := column{Label: s.label, URL: page.Base + "/admin?sort=" + s.key}
+		if s.key == by {
+			c.Arrow = " ▲"
+			if desc {
+				c.Arrow = " ▼"
+			} else {
+				c.URL += "&order=desc"
+			}
+		}
+		page.Columns = append(page.Columns, c)
+	}
+	d.render(w, page)
+}
+
+// adminEdit renders the form of an item
+func (d *database) adminEdit(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("item")
+	it, err := d.store.Get(name)
+	if err != nil {
+		d.adminError(w, r, err)
+		return
+	}
+	page, err := d.newAdminPage(w, r, "Edit "+name)
+	if err != nil {
+		internalError(w, err)
+		return
+	}
+	page.Item = &it
+	page.Currency = it.Price.Currency
+	d.render(w, page)
+}
+
+// adminCreate adds the item of the form
+func (d *database) adminCreate(w http.ResponseWriter, r *http.Request) {
+	it := inventory.Item{Name: strings.TrimSpace(r.PostFormValue("item"))}
+	if !validName(it.Name) {
+		redirectFlash(w, r, "/admin", "error", "name not provided or invalid")
+		return
+	}
+	if err := formFields(r, &it); err != nil {
+		redirectFlash(w, r, "/admin", "error", err.Error())
+		return
+	}
+	if _, err := d.storeFor(r).Create(it); err != nil {
+		d.adminError(w, r, err)
+		return
+	}
+	redirectFlash(w, r, "/admin", "success", "created "+it.Name)
+}
+
+// adminSave changes the item to the values of the form. The version of the
+// form keeps the changes of other users from being overwritten.
+func (d *database) adminSave(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("item")
+	back := "/admin/edit?item=" + url.QueryEscape(name)
+	version, err := strconv.ParseInt(r.PostFormValue("version"), 10, 64)
+	if err != nil {
+		redirectFlash(w, r, back, "error", "invalid version")
+		return
+	}
+
+	_, err = d.storeFor(r).Update(name, version, func(it *inventory.Item) error {
+		return formFields(r, it)
+	})
+	if errors.Is(err, inventory.ErrVersionMismatch) {
+		redirectFlash(w, r, back, "error", "the item was changed by someone else, check it and save again")
+		return
+	}
+	if err != nil {
+		d.adminError(w, r, err)
+		return
+	}
+	redirectFlash(w, r, "/admin", "success", "saved "+name)
+}
+
+// adminDelete deletes the item of the form, if it was not changed since
+func (d *database) adminDelete(w http.ResponseWriter, r *http.Request) {
+	name := r.PostFormValue("item")
+	version, err := strconv.ParseInt(r.PostFormValue("version"), 10, 64)
+	if err != nil {
+		redirectFlash(w, r, "/admin", "error", "invalid version")
+		return
+	}
+	if err := d.storeFor(r).Delete(name, version); err != nil {
+		d.adminError(w, r, err)
+		return
+	}
+	redirectFlash(w, r, "/admin", "success", "deleted "+name)
+}
+
+// formFields copies the form fields to the item
+func formFields(r *http.Request, it *inventory.Item) error {
+	price, err := inventory.ParseMoney(r.PostFormValue("price"), r.PostFormValue("currency"))
+	if err != nil {
+		return err
+	}
+	qty, err := strconv.Atoi(r.PostFormValue("quantity"))
+	if err != nil {
+		return errors.New("invalid quantity: " + r.PostFormValue("quantity"))
+	}
+
+	it.SKU = strings.TrimSpace(r.PostFormValue("sku"))
+	it.Description = strings.TrimSpace(r.PostFormValue("description"))
+	it.Tags = nil
+	for _, t := range strings.Split(r.PostFormValue("tags"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			it.Tags = append(it.Tags, t)
+		}
+	}
+	it.Price, it.Quantity = price, qty
+	return nil
+}
+
+// adminError shows the error of the store on the item table
+func (d *database) adminError(w http.ResponseWriter, r *http.Request, err error) {
+	status, msg := storeStatus(err, r.FormValue("item"))
+	if status == http.StatusInternalServerError {
+		http.Error(w, msg, status)
+		return
+	}
+	redirectFlash(w, r, "/admin", "error", msg)
+}
+
+// newAdminPage fills the common data of the pages, and takes the flash
+// message out of its cookie so it is shown once. It fails only when no
+// CSRF token can be made.
+func (d *database) newAdminPage(w http.ResponseWriter, r *http.Request, title string) (*adminPage, error) {
+	token, err := csrfToken(w, r)
+	if err != nil {
+		return nil, err
+	}
+	page := &adminPage{
+		Base:       basePath(r),
+		Title:      title,
+		CSRF:       token,
+		Currency:   inventory.DefaultCurrency,
+		Currencies: inventory.Currencies(),
+	}
+	if c, err := r.Cookie(flashCookie); err == nil {
+		http.SetCookie(w, &http.Cookie{Name: flashCookie, Path: basePath(r) + "/admin", MaxAge: -1})
+		if b, err := base64.RawURLEncoding.DecodeString(c.Value); err == nil {
+			if kind, msg, ok := strings.Cut(string(b), ":"); ok && (kind == "success" || kind == "error") {
+				page.Flash = &flash{Kind: kind, Message: msg}
+			}
+		}
+	}
+	return page, nil
+}
+
+// internalError logs the error of the page and responds with 500
+func internalError(w http.ResponseWriter, err error) {
+	log.Printf("admin: %v", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+// render writes the page, or 500 when the template fails
+func (d *database) render(w http.ResponseWriter, page *adminPage) {
+	var b strings.Builder
+	if err := adminPages.ExecuteTemplate(&b, "layout", page); err != nil {
+		internalError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(b.String()))
+}
+
+// redirectFlash redirects to the admin page that shows the message once
+func redirectFlash(w http.ResponseWriter, r *http.Request, to, kind, msg string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookie,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(kind + ":" + msg)),
+		Path:     basePath(r) + "/admin",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, basePath(r)+to, http.StatusSeeOther)
+}
+
+// csrfToken returns the CSRF token of the browser, setting a new one in a
+// cookie on the first visit. The forms must send back the same token.
+func csrfToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 32 {
+		return c.Value, nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("making a CSRF token: %v", err)
+	}
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     basePath(r) + "/admin",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token, nil
+}
+
+// validCSRF reports whether the form has the token of the cookie
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostFormValue("csrf_token"))) == 1
+}
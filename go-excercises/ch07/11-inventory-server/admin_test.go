@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+// browser is a client keeping the cookies and following the redirects
+type browser struct {
+	t      *testing.T
+	client *http.Client
+	base   string
+}
+
+// get returns the page
+func (b *browser) get(path string) string {
+	b.t.Helper()
+	resp, err := b.client.Get(b.base + path)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+// post submits the form and returns the status and the page after the redirect
+func (b *browser) post(path string, form url.Values) (int, string) {
+	b.t.Helper()
+	resp, err := b.client.PostForm(b.base+path, form)
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([0-9a-f]+)"`)
+
+func TestAdmin(t *testing.T) {
+	db := &database{store: inventory.NewMemStore(), audit: inventory.NewAuditLog()}
+	db.store.Create(inventory.Item{Name: "socks", Price: usd(1), Quantity: 50})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin", db.admin)
+	mux.HandleFunc("/admin/", db.admin)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	b := &browser{t: t, client: &http.Client{Jar: jar}, base: ts.URL}
+	m := csrfField.FindStringSubmatch(b.get("/admin"))
+	if m == nil {
+		t.Fatal("no CSRF token in the page")
+	}
+	token := m[1]
+
+	form := url.Values{"item": {"<b>hat"}, "price": {"19.99"}, "currency": {"EUR"}, "quantity": {"3"}, "tags": {"men, winter"}}
+	if status, _ := b.post("/admin/create", form); status != http.StatusForbidden {
+		t.Errorf("create without CSRF token = %d, want 403", status)
+	}
+
+	form.Set("csrf_token", token)
+	status, page := b.post("/admin/create", form)
+	if status != http.StatusOK || !strings.Contains(page, `class="flash success">created &lt;b&gt;hat`) {
+		t.Fatalf("create = %d, want the escaped flash message\n%s", status, page)
+	}
+	if strings.Contains(page, "<b>hat") {
+		t.Error("the item name is not escaped")
+	}
+	if strings.Contains(b.get("/admin"), `class="flash`) {
+		t.Error("the flash message is shown twice")
+	}
+	it, err := db.store.Get("<b>hat")
+	if err != nil || it.Price != (inventory.Money{Amount: 1999, Currency: "EUR"}) || len(it.Tags) != 2 {
+		t.Errorf("created item = %+v, %v", it, err)
+	}
+
+	// the items are sorted by the column, in the descending order once selected
+	page = b.get("/admin?sort=quantity&order=desc")
+	if strings.Index(page, ">socks<") > strings.Index(page, "&lt;b&gt;hat") {
+		t.Error("socks is not listed first by descending quantity")
+	}
+
+	// a form of an older version does not overwrite the changes
+	edit := "/admin/edit?item=" + url.QueryEscape("<b>hat")
+	if !strings.Contains(b.get(edit), `value="19.99"`) {
+		t.Error("the edit form does not show the price")
+	}
+	save := url.Values{"csrf_token": {token}, "version": {"1"}, "price": {"17.50"}, "currency": {"EUR"}, "quantity": {"3"}}
+	if _, page := b.post(edit, save); !strings.Contains(page, "flash success") {
+		t.Errorf("save = %s", page)
+	}
+	if _, page := b.post(edit, save); !strings.Contains(page, "changed by someone else") {
+		t.Errorf("save of a stale version = %s", page)
+	}
+	if _, page := b.post("/admin/create", url.Values{"csrf_token": {token}, "item": {"cap"}, "price": {"-1"}, "quantity": {"1"}}); !strings.Contains(page, "flash error") {
+		t.Errorf("create with an invalid price = %s", page)
+	}
+
+	del := url.Values{"csrf_token": {token}, "item": {"socks"}, "version": {"1"}}
+	if _, page := b.post("/admin/delete", del); !strings.Contains(page, "deleted socks") {
+		t.Errorf("delete = %s", page)
+	}
+	if _, err := db.store.Get("socks"); err != inventory.ErrNotFound {
+		t.Errorf("socks after delete: %v", err)
+	}
+}
@@ -0,0 +1,504 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+// maxBodySize limits the size of JSON request bodies
+const maxBodySize = 1 << 20
+
+// itemJSON is the JSON representation of an inventory item
+type itemJSON struct {
+	inventory.Item
+	Available int `json:"available"`
+}
+
+// newItemJSON adds the computed fields to the item
+func newItemJSON(it inventory.Item) itemJSON {
+	return itemJSON{Item: it, Available: it.Available()}
+}
+
+// itemInput is the request body for creating or changing an item.
+// Pointers tell the missing fields apart from the zero values.
+type itemInput struct {
+	Name        *string          `json:"name,omitempty"`
+	SKU         *string          `json:"sku,omitempty"`
+	Description *string          `json:"description,omitempty"`
+	Tags        *[]string        `json:"tags,omitempty"`
+	Price       *inventory.Money `json:"price,omitempty"`
+	Quantity    *int             `json:"quantity,omitempty"`
+}
+
+// apply copies the given fields to the item
+func (in *itemInput) apply(it *inventory.Item) {
+	if in.SKU != nil {
+		it.SKU = *in.SKU
+	}
+	if in.Description != nil {
+		it.Description = *in.Description
+	}
+	if in.Tags != nil {
+		it.Tags = *in.Tags
+	}
+	if in.Price != nil {
+		it.Price = *in.Price
+	}
+	if in.Quantity != nil {
+		it.Quantity = *in.Quantity
+	}
+}
+
+// stockInput is the request body to adjust the stock
+type stockInput struct {
+	Delta int `json:"delta"`
+}
+
+// reserveInput is the request body to reserve units
+type reserveInput struct {
+	Quantity int `json:"quantity"`
+}
+
+// rollbackInput is the request body to roll back an item
+type rollbackInput struct {
+	Version int64 `json:"version"`
+}
+
+// reservationJSON is the response for a new reservation
+type reservationJSON struct {
+	ID       string   `json:"id"`
+	Quantity int      `json:"quantity"`
+	Item     itemJSON `json:"item"`
+}
+
+// errorJSON is the structured error body of the JSON API
+type errorJSON struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeJSON encodes v as the response body with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) // NOTE: ignoring errors, the client is gone
+}
+
+// writeItem writes the item with its version as the ETag
+func writeItem(w http.ResponseWriter, status int, it inventory.Item) {
+	w.Header().Set("ETag", etag(it.Version))
+	writeJSON(w, status, newItemJSON(it))
+}
+
+// jsonError writes a structured JSON error
+func jsonError(w http.ResponseWriter, status int, msg string) {
+	var e errorJSON
+	e.Error.Status = status
+	e.Error.Message = msg
+	writeJSON(w, status, e)
+}
+
+// jsonStoreError writes the error returned by the store as JSON
+func jsonStoreError(w http.ResponseWriter, err error, item string) {
+	status, msg := storeStatus(err, item)
+	jsonError(w, status, msg)
+}
+
+// methodNotAllowed rejects the request and advertises the allowed methods
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	jsonError(w, http.StatusMethodNotAllowed, "method not allowed: "+r.Method)
+}
+
+// decodeJSON reads the JSON request body into v
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON body: %v", err)
+	}
+	return nil
+}
+
+// etag formats the item version as a strong entity tag
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// ifMatch returns the version required by the If-Match header.
+// It returns AnyVersion when the header is missing or "*".
+func ifMatch(r *http.Request) (int64, error) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return inventory.AnyVersion, nil
+	}
+	s, err := strconv.Unquote(strings.TrimPrefix(h, "W/"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid If-Match: %s", h)
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid If-Match: %s", h)
+	}
+	return v, nil
+}
+
+// validName reports whether the item name can be used in the resource path
+func validName(name string) bool {
+	return name != "" && !strings.Contains(name, "/")
+}
+
+// itemURL returns the resource path of an item, within the tenant of the request
+func itemURL(r *http.Request, name string) string {
+	return basePath(r) + "/items/" + url.PathEscape(name)
+}
+
+// items serves the collection resource /items
+//
+//	GET  /items - list the items sorted by name, or as given by parseQuery
+//	POST /items - create an item from {"name": "..", "price": .., ...}
+func (d *database) items(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		d.listJSON(w, r)
+	case http.MethodPost:
+		d.createJSON(w, r)
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead, http.MethodPost)
+	}
+}
+
+// item serves a single item and its sub-resources
+//
+//	GET    /items/{name}                           - get the item
+//	PUT    /items/{name}                           - replace the item, or create it if missing
+//	PATCH  /items/{name}                           - change only the given fields
+//	DELETE /items/{name}                           - delete the item
+//	POST   /items/{name}/stock                     - adjust the stock by {"delta": n}
+//	POST   /items/{name}/reservations              - reserve {"quantity": n} units
+//	DELETE /items/{name}/reservations/{id}         - release the reservation
+//	POST   /items/{name}/reservations/{id}/commit  - fulfil the reservation
+//	GET    /items/{name}/history                   - changes of the item from the audit log
+//	POST   /items/{name}/rollback                  - restore the item to {"version": n}
+func (d *database) item(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/items/"), "/")
+	name := parts[0]
+	if !validName(name) {
+		jsonError(w, http.StatusNotFound, "invalid item name: "+name)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			d.getJSON(w, r, name)
+		case http.MethodPut:
+			d.putJSON(w, r, name)
+		case http.MethodPatch:
+			d.patchJSON(w, r, name)
+		case http.MethodDelete:
+			d.deleteJSON(w, r, name)
+		default:
+			methodNotAllowed(w, r, http.MethodGet, http.MethodHead, http.MethodPut,
+				http.MethodPatch, http.MethodDelete)
+		}
+	case len(parts) == 2 && parts[1] == "stock":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r, http.MethodPost)
+			return
+		}
+		d.adjustStock(w, r, name)
+	case len(parts) == 2 && parts[1] == "history":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+			return
+		}
+		d.history(w, r, name)
+	case len(parts) == 2 && parts[1] == "rollback":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r, http.MethodPost)
+			return
+		}
+		d.rollback(w, r, name)
+	case len(parts) == 2 && parts[1] == "reservations":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r, http.MethodPost)
+			return
+		}
+		d.reserve(w, r, name)
+	case len(parts) == 3 && parts[1] == "reservations":
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, r, http.MethodDelete)
+			return
+		}
+		d.changeReservation(w, r, name, parts[2], (*inventory.Item).Release)
+	case len(parts) == 4 && parts[1] == "reservations" && parts[3] == "commit":
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, r, http.MethodPost)
+			return
+		}
+		d.changeReservation(w, r, name, parts[2], (*inventory.Item).Commit)
+	default:
+		jsonError(w, http.StatusNotFound, "no such resource: "+r.URL.Path)
+	}
+}
+
+// listJSON writes a page of the items as a JSON array
+func (d *database) listJSON(w http.ResponseWriter, r *http.Request) {
+	page, err := d.search(r)
+	if err != nil {
+		jsonStoreError(w, err, "")
+		return
+	}
+
+	list := make([]itemJSON, 0, len(page.Items))
+	for _, it := range page.Items {
+		list = append(list, newItemJSON(it))
+	}
+	setNextLink(w, r, page)
+	writeJSON(w, http.StatusOK, list)
+}
+
+// createJSON adds a new item and points to it with the Location header
+func (d *database) createJSON(w http.ResponseWriter, r *http.Request) {
+	var in itemInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if in.Name == nil || !validName(*in.Name) {
+		jsonError(w, http.StatusBadRequest, "name not provided or invalid")
+		return
+	}
+	if in.Price == nil {
+		jsonError(w, http.StatusBadRequest, "price not provided")
+		return
+	}
+
+	it := inventory.Item{Name: *in.Name}
+	in.apply(&it)
+	it, err := d.storeFor(r).Create(it)
+	if err != nil {
+		jsonStoreError(w, err, *in.Name)
+		return
+	}
+	w.Header().Set("Location", itemURL(r, it.Name))
+	writeItem(w, http.StatusCreated, it)
+}
+
+// getJSON writes a single item, or 304 when the client has the same version
+func (d *database) getJSON(w http.ResponseWriter, r *http.Request, name string) {
+	it, err := d.store.Get(name)
+	if err != nil {
+		jsonStoreError(w, err, name)
+		return
+	}
+	if r.Header.Get("If-None-Match") == etag(it.Version) {
+		w.Header().Set("ETag", etag(it.Version))
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeItem(w, http.StatusOK, it)
+}
+
+// putJSON replaces all the fields set by clients, so the missing ones are
+// reset. The open reservations are kept. The name in the body is optional,
+// but it must match the path when given.
+func (d *database) putJSON(w http.ResponseWriter, r *http.Request, name string) {
+	version, err := ifMatch(r)
+	if err != nil {
+		jsonError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	var in itemInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if in.Name != nil && *in.Name != name {
+		jsonError(w, http.StatusBadRequest, "name does not match the path: "+*in.Name)
+		return
+	}
+	if in.Price == nil {
+		jsonError(w, http.StatusBadRequest, "price not provided")
+		return
+	}
+
+	status := http.StatusOK
+	it, err := d.storeFor(r).Update(name, version, func(it *inventory.Item) error {
+		it.SKU, it.Description, it.Tags, it.Quantity = "", "", nil, 0
+		in.apply(it)
+		return nil
+	})
+	if errors.Is(err, inventory.ErrNotFound) && version == inventory.AnyVersion {
+		status = http.StatusCreated
+		it = inventory.Item{Name: name}
+		in.apply(&it)
+		it, err = d.storeFor(r).Create(it)
+	}
+	if err != nil {
+		jsonStoreError(w, err, name)
+		return
+	}
+	if status == http.StatusCreated {
+		w.Header().Set("Location", itemURL(r, name))
+	}
+	writeItem(w, status, it)
+}
+
+// patchJSON changes the given fields of an existing item
+func (d *database) patchJSON(w http.ResponseWriter, r *http.Request, name string) {
+	version, err := ifMatch(r)
+	if err != nil {
+		jsonError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	var in itemInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if in.Name != nil && *in.Name != name {
+		jsonError(w, http.StatusBadRequest, "renaming an item is not supported")
+		return
+	}
+
+	it, err := d.storeFor(r).Update(name, version, func(it *inventory.Item) error {
+		in.apply(it)
+		return nil
+	})
+	if err != nil {
+		jsonStoreError(w, err, name)
+		return
+	}
+	writeItem(w, http.StatusOK, it)
+}
+
+// deleteJSON removes the item and responds with no content
+func (d *database) deleteJSON(w http.ResponseWriter, r *http.Request, name string) {
+	version, err := ifMatch(r)
+	if err != nil {
+		jsonError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	if err := d.storeFor(r).Delete(name, version); err != nil {
+		jsonStoreError(w, err, name)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adjustStock adds or removes units from the stock
+func (d *database) adjustStock(w http.ResponseWriter, r *http.Request, name string) {
+	version, err := ifMatch(r)
+	if err != nil {
+		jsonError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	var in stockInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	it, err := d.storeFor(r).Update(name, version, func(it *inventory.Item) error {
+		return it.AdjustStock(in.Delta)
+	})
+	if err != nil {
+		jsonStoreError(w, err, name)
+		return
+	}
+	writeItem(w, http.StatusOK, it)
+}
+
+// reserve holds units for an order. The check of the available units and
+// the reservation happen atomically in the store, so the stock is never
+// oversold by concurrent orders.
+func (d *database) reserve(w http.ResponseWriter, r *http.Request, name string) {
+	version, err := ifMatch(r)
+	if err != nil {
+		jsonError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	var in reserveInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var id string
+	it, err := d.storeFor(r).Update(name, version, func(it *inventory.Item) (err error) {
+		id, err = it.Reserve(in.Quantity)
+		return err
+	})
+	if err != nil {
+		jsonStoreError(w, err, name)
+		return
+	}
+	w.Header().Set("Location", itemURL(r, name)+"/reservations/"+id)
+	w.Header().Set("ETag", etag(it.Version))
+	writeJSON(w, http.StatusCreated, reservationJSON{ID: id, Quantity: in.Quantity, Item: newItemJSON(it)})
+}
+
+// changeReservation releases or commits the reservation
+func (d *database) changeReservation(w http.ResponseWriter, r *http.Request, name, id string,
+	change func(*inventory.Item, string) error) {
+	version, err := ifMatch(r)
+	if err != nil {
+		jsonError(w, http.StatusPreconditionFailed, err.Error())
+		return
+	}
+	it, err := d.storeFor(r).Update(name, version, func(it *inventory.Item) error {
+		return change(it, id)
+	})
+	if err != nil {
+		jsonStoreError(w, err, name)
+		return
+	}
+	writeItem(w, http.StatusOK, it)
+}
+
+// history writes the changes of the item, oldest first. The history remains
+// after the item is deleted.
+func (d *database) history(w http.ResponseWriter, r *http.Request, name string) {
+	if d.audit == nil {
+		jsonError(w, http.StatusNotFound, "audit log is disabled")
+		return
+	}
+	changes := d.audit.History(name)
+	if len(changes) == 0 {
+		jsonError(w, http.StatusNotFound, "no history for item: "+name)
+		return
+	}
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// rollback restores the item to an earlier version (see inventory.Rollback)
+func (d *database) rollback(w http.ResponseWriter, r *http.Request, name string) {
+	if d.audit == nil {
+		jsonError(w, http.StatusNotFound, "audit log is disabled")
+		return
+	}
+	var in rollbackInput
+	if err := decodeJSON(w, r, &in); err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	it, err := inventory.Rollback(d.storeFor(r), name, in.Version)
+	if err != nil {
+		jsonStoreError(w, err, name)
+		return
+	}
+	writeItem(w, http.StatusOK, it)
+}
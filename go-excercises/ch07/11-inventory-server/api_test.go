@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+// newTestServer serves both the query-string and JSON handlers over one store
+func newTestServer() *httptest.Server {
+	db := &database{store: inventory.NewMemStore(), audit: inventory.NewAuditLog()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", db.create)
+	mux.HandleFunc("/price", db.price)
+	mux.HandleFunc("/items", db.items)
+	mux.HandleFunc("/items/", db.item)
+	mux.HandleFunc("/import", db.importItems)
+	return httptest.NewServer(mux)
+}
+
+// do sends a request and returns the response with its body
+func do(t *testing.T, method, url, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp, strings.TrimSpace(string(b))
+}
+
+func TestItemsAPI(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	const hat = `"name":"hat","sku":"H-1","price":{"amount":"300.00","currency":"USD"}`
+	tests := []struct {
+		method, path, body string
+		status             int
+		want               string // expected within the body
+	}{
+		{"POST", "/items", `{"name":"hat","sku":"H-1","price":300,"quantity":5}`, 201, hat},
+		{"POST", "/items", `{"name":"hat","price":300}`, 409, `{"error":{"status":409,"message":"item already exists: hat"}}`},
+		{"POST", "/items", `{"name":"cap"}`, 400, `{"error":{"status":400,"message":"price not provided"}}`},
+		{"POST", "/items", `{"name":"cap","price":"-1 USD"}`, 400, `negative price`},
+		{"GET", "/items/hat", "", 200, `"quantity":5,"reserved":0`},
+		{"PATCH", "/items/hat", `{"price":"3.20 EUR"}`, 200, `"sku":"H-1","price":{"amount":"3.20","currency":"EUR"}`},
+		{"PUT", "/items/cap", `{"price":{"amount":"1.5","currency":"USD"}}`, 201, `"name":"cap","price":{"amount":"1.50","currency":"USD"}`},
+		{"GET", "/items", "", 200, `"name":"cap"`},
+		{"DELETE", "/items/cap", "", 204, ""},
+		{"GET", "/items/cap", "", 404, `{"error":{"status":404,"message":"item not found: cap"}}`},
+		{"POST", "/items/hat", "", 405, `{"error":{"status":405,"message":"method not allowed: POST"}}`},
+		{"POST", "/items/hat/stock", `{"delta":-6}`, 409, `insufficient stock`},
+		{"POST", "/items/hat/stock", `{"delta":3}`, 200, `"quantity":8`},
+		{"POST", "/items/hat/reservations", `{"quantity":9}`, 409, `insufficient stock`},
+		{"DELETE", "/items/hat/reservations/unknown", "", 404, `reservation not found`},
+		{"GET", "/items/hat/history", "", 200, `"op":"update","item":"hat"`},
+		{"POST", "/items/hat/rollback", `{"version":1}`, 200, `"price":{"amount":"300.00","currency":"USD"},"quantity":8`},
+		{"POST", "/items/hat/rollback", `{"version":9}`, 404, `version not found`},
+		// The query-string endpoints share the same items
+		{"GET", "/price?item=hat", "", 200, `"hat", 300.00 USD`},
+	}
+	for _, test := range tests {
+		resp, body := do(t, test.method, ts.URL+test.path, test.body)
+		if resp.StatusCode != test.status || !strings.Contains(body, test.want) {
+			t.Errorf("%s %s = %d %s, want %d %s", test.method, test.path,
+				resp.StatusCode, body, test.status, test.want)
+		}
+	}
+}
+
+func TestCreateLocation(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	resp, _ := do(t, "POST", ts.URL+"/items", `{"name":"shoe model","price":800}`)
+	if got, want := resp.Header.Get("Location"), "/items/shoe%20model"; got != want {
+		t.Errorf("Location = %q, want %q", got, want)
+	}
+}
+
+func TestReservationAPI(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	do(t, "POST", ts.URL+"/items", `{"name":"shoe","price":700,"quantity":3}`)
+	resp, body := do(t, "POST", ts.URL+"/items/shoe/reservations", `{"quantity":2}`)
+	loc := resp.Header.Get("Location")
+	if resp.StatusCode != 201 || !strings.Contains(body, `"available":1`) {
+		t.Fatalf("reserve = %d %s", resp.StatusCode, body)
+	}
+
+	tag := resp.Header.Get("ETag")
+
+	// The reservations are changed only at the version of If-Match
+	do(t, "PATCH", ts.URL+"/items/shoe", `{"price":650}`)
+	for _, path := range []string{loc + "/commit", loc} {
+		method := "POST"
+		if path == loc {
+			method = "DELETE"
+		}
+		if resp, body := do(t, method, ts.URL+path, "", "If-Match", tag); resp.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("%s %s with a stale version = %d %s, want 412", method, path, resp.StatusCode, body)
+		}
+	}
+
+	resp, body = do(t, "POST", ts.URL+loc+"/commit", "", "If-Match", `"3"`)
+	if resp.StatusCode != 200 || !strings.Contains(body, `"quantity":1,"reserved":0`) {
+		t.Errorf("commit = %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	resp, _ := do(t, "POST", ts.URL+"/items", `{"name":"shoe","price":700}`)
+	tag := resp.Header.Get("ETag")
+	if tag != `"1"` {
+		t.Errorf("ETag = %s, want \"1\"", tag)
+	}
+
+	resp, _ = do(t, "PATCH", ts.URL+"/items/shoe", `{"price":650}`, "If-Match", tag)
+	if resp.StatusCode != 200 || resp.Header.Get("ETag") != `"2"` {
+		t.Errorf("PATCH with the current version = %d %s", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	// Another client still holds the old version
+	resp, _ = do(t, "PATCH", ts.URL+"/items/shoe", `{"price":600}`, "If-Match", tag)
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("PATCH with a stale version = %d, want 412", resp.StatusCode)
+	}
+
+	resp, _ = do(t, "GET", ts.URL+"/items/shoe", "", "If-None-Match", `"2"`)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("GET with If-None-Match = %d, want 304", resp.StatusCode)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	for _, name := range []string{"c", "a", "b"} {
+		do(t, "POST", ts.URL+"/items", `{"name":"`+name+`","price":1}`)
+	}
+
+	var got []string
+	next := "/items?limit=2&order=desc"
+	for next != "" {
+		resp, body := do(t, "GET", ts.URL+next, "")
+		got = append(got, body)
+		next = ""
+		if link := resp.Header.Get("Link"); link != "" {
+			next = strings.TrimPrefix(strings.Split(link, ">")[0], "<")
+		}
+	}
+	if len(got) != 2 || !strings.Contains(got[0], `"name":"c"`) || !strings.Contains(got[1], `"name":"a"`) {
+		t.Errorf("pages = %v", got)
+	}
+
+	resp, _ := do(t, "GET", ts.URL+"/items?limit=0", "")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid limit = %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestImportStatus(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+
+	const bad = `{"name":"bad","price":"3 XXX"}`
+	tests := []struct {
+		query, body string
+		status      int
+	}{
+		{"", `[{"name":"shoe","price":7}]`, 200},
+		{"", `[{"name":"hat","price":3},` + bad + `]`, 422},
+		{"?partial=true", `[{"name":"cap","price":3},` + bad + `]`, 207},
+		{"?partial=true", `[` + bad + `]`, 422}, // nothing stored
+	}
+	for _, test := range tests {
+		resp, body := do(t, "POST", ts.URL+"/import"+test.query, test.body, "Content-Type", "application/json")
+		if resp.StatusCode != test.status {
+			t.Errorf("import%s %s = %d %s, want %d", test.query, test.body, resp.StatusCode, body, test.status)
+		}
+	}
+}
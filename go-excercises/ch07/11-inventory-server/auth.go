@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/rajkumar-km/go-play/go-excercises/httplimit"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role grants the access to the endpoints. A higher role includes the
+// permissions of the lower ones.
+type Role int
+
+const (
+	RoleReader Role = iota + 1 // list and read the items
+	RoleEditor                 // create, update and delete the items
+	RoleAdmin                  // everything
+)
+
+// roleNames maps the names used in the credential files to roles
+var roleNames = map[string]Role{"reader": RoleReader, "editor": RoleEditor, "admin": RoleAdmin}
+
+func (r Role) String() string {
+	for name, role := range roleNames {
+		if role == r {
+			return name
+		}
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+// parseRole converts a role name to Role
+func parseRole(s string) (Role, error) {
+	role, ok := roleNames[s]
+	if !ok {
+		return 0, fmt.Errorf("unknown role: %q", s)
+	}
+	return role, nil
+}
+
+// Principal is an authenticated user or API key
+type Principal struct {
+	Name string
+	Role Role
+}
+
+var (
+	// errNoCredentials means the request has no credentials of a scheme,
+	// so the next authenticator can try
+	errNoCredentials = errors.New("no credentials")
+
+	// errBadCredentials means the credentials were given but are wrong
+	errBadCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator identifies the client of a request
+type Authenticator interface {
+	// Authenticate returns the principal, or errNoCredentials when the
+	// request has no credentials for this authenticator
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// readCredentials reads the non-empty lines of a credential file,
+// skipping the comments starting with '#'
+func readCredentials(path string, fn func(fields []string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if err := fn(strings.Fields(text)); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// APIKeyAuth authenticates the static API keys sent as "X-API-Key: key"
+// or "Authorization: Bearer key". Only the SHA-256 of the keys is kept in
+// memory, which also makes the lookup independent of the key contents.
+type APIKeyAuth struct {
+	keys map[[sha256.Size]byte]*Principal
+}
+
+// newAPIKeyAuth creates an authenticator without keys
+func newAPIKeyAuth() *APIKeyAuth {
+	return &APIKeyAuth{keys: make(map[[sha256.Size]byte]*Principal)}
+}
+
+// LoadAPIKeys reads a file with a line per key:
+//
+//	# name   role    key
+//	ci-bot   editor  3f1c9a0e77d24b6b
+//	grafana  reader  9b2d4e1f0a6c8d37
+func LoadAPIKeys(path string) (*APIKeyAuth, error) {
+	a := newAPIKeyAuth()
+	err := readCredentials(path, func(fields []string) error {
+		if len(fields) != 3 {
+			return errors.New("want: name role key")
+		}
+		role, err := parseRole(fields[1])
+		if err != nil {
+			return err
+		}
+		a.keys[sha256.Sum256([]byte(fields[2]))] = &Principal{Name: fields[0], Role: role}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Authenticate looks up the API key of the request
+func (a *APIKeyAuth) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if h := r.Header.Get("Authorization"); key == "" && strings.HasPrefix(h, "Bearer ") {
+		key = strings.TrimPrefix(h, "Bearer ")
+	}
+	if key == "" {
+		return nil, errNoCredentials
+	}
+	p, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, errBadCredentials
+	}
+	return p, nil
+}
+
+// basicUser is a user of BasicAuth
+type basicUser struct {
+	role Role
+	hash []byte // bcrypt hash of the password
+}
+
+// BasicAuth authenticates the HTTP Basic credentials against the bcrypt
+// hashed passwords
+type BasicAuth struct {
+	users map[string]basicUser
+}
+
+// LoadBasicUsers reads a file with a line per user. The hash can be
+// generated with "./server -hash-password".
+//
+//	# name  role    bcrypt hash
+//	alice   admin   $2a$10$...
+//	bob     reader  $2a$10$...
+func LoadBasicUsers(path string) (*BasicAuth, error) {
+	a := &BasicAuth{users: make(map[string]basicUser)}
+	err := readCredentials(path, func(fields []string) error {
+		if len(fields) != 3 {
+			return errors.New("want: name role hash")
+		}
+		role, err := parseRole(fields[1])
+		if err != nil {
+			return err
+		}
+		if _, err := bcrypt.Cost([]byte(fields[2])); err != nil {
+			return fmt.Errorf("invalid bcrypt hash for %s: %v", fields[0], err)
+		}
+		a.users[fields[0]] = basicUser{role: role, hash: []byte(fields[2])}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// dummyHash is compared for the unknown users, so the response time does
+// not reveal which user names exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+
+// Authenticate checks the user name and password of the request
+func (a *BasicAuth) Authenticate(r *http.Request) (*Principal, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, errNoCredentials
+	}
+	u, ok := a.users[name]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, errBadCredentials
+	}
+	if bcrypt.CompareHashAndPassword(u.hash, []byte(password)) != nil {
+		return nil, errBadCredentials
+	}
+	return &Principal{Name: name, Role: u.role}, nil
+}
+
+// principalKey is the context key of the authenticated Principal
+type principalKey struct{}
+
+// principalFrom returns the authenticated principal of the request, or nil
+// when the authentication is disabled
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// authorizer protects the handlers with the authenticators. With no
+// authenticators, the authentication is disabled and every request passes.
+type authorizer struct {
+	authenticators []Authenticator
+	limiter        *httplimit.Limiter // the rate of each principal, none if nil
+}
+
+// authenticate tries the authenticators in order until one finds credentials
+func (a *authorizer) authenticate(r *http.Request) (*Principal, error) {
+	for _, auth := range a.authenticators {
+		p, err := auth.Authenticate(r)
+		if err == errNoCredentials {
+			continue
+		}
+		return p, err
+	}
+	return nil, errNoCredentials
+}
+
+// unauthorized rejects a request without valid credentials
+func (a *authorizer) unauthorized(w http.ResponseWriter, msg string) {
+	for _, auth := range a.authenticators {
+		if _, ok := auth.(*BasicAuth); ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="inventory"`)
+		}
+	}
+	jsonError(w, http.StatusUnauthorized, msg)
+}
+
+// require allows the handler only for the principals with at least the role,
+// within the rate of the limiter of each principal
+func (a *authorizer) require(role Role, h http.HandlerFunc) http.HandlerFunc {
+	if len(a.authenticators) == 0 {
+		return h
+	}
+	var limited http.Handler = h
+	if a.limiter != nil {
+		limited = a.limiter.Handler(h)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.authenticate(r)
+		switch {
+		case err == errNoCredentials:
+			a.unauthorized(w, "authentication required")
+			return
+		case err != nil:
+			a.unauthorized(w, err.Error())
+			return
+		case p.Role < role:
+			jsonError(w, http.StatusForbidden, fmt.Sprintf("%s role required", role))
+			return
+		}
+		limited.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+// principalLimit returns the client of the rate limit of the principals,
+// set by require in the context of the request. The names are scoped by
+// the tenant, which has its own keys.
+func principalLimit(r *http.Request) string {
+	p := principalFrom(r.Context())
+	if p == nil {
+		return ""
+	}
+	return basePath(r) + "/" + p.Name
+}
+
+// requireByMethod allows the readers for the safe methods (GET and HEAD),
+// and requires the editors for the methods that change the items
+func (a *authorizer) requireByMethod(h http.HandlerFunc) http.HandlerFunc {
+	read, write := a.require(RoleReader, h), a.require(RoleEditor, h)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			read(w, r)
+		} else {
+			write(w, r)
+		}
+	}
+}
+
+// hashPassword reads a password from stdin and prints the bcrypt hash
+// for the users file of BasicAuth
+func hashPassword() error {
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(strings.TrimRight(password, "\r\n")), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(hash))
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+	"github.com/rajkumar-km/go-play/go-excercises/httplimit"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// newAuthServer serves the legacy handlers with an API key and a Basic user
+func newAuthServer(t *testing.T) *httptest.Server {
+	dir := t.TempDir()
+	keysFile := filepath.Join(dir, "keys.txt")
+	os.WriteFile(keysFile, []byte("# name role key\nbot reader readkey\nci editor editkey\n"), 0600)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	usersFile := filepath.Join(dir, "users.txt")
+	os.WriteFile(usersFile, []byte("alice admin "+string(hash)+"\n"), 0600)
+
+	keys, err := LoadAPIKeys(keysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadBasicUsers(usersFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &authorizer{authenticators: []Authenticator{keys, users}}
+
+	db := &database{store: inventory.NewMemStore()}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/list", auth.require(RoleReader, db.list))
+	mux.HandleFunc("/create", auth.require(RoleEditor, db.create))
+	mux.HandleFunc("/items/", auth.requireByMethod(db.item))
+	return httptest.NewServer(mux)
+}
+
+func TestAuth(t *testing.T) {
+	ts := newAuthServer(t)
+	defer ts.Close()
+
+	tests := []struct {
+		method, path string
+		header       []string
+		status       int
+	}{
+		{"GET", "/list", nil, 401},
+		{"GET", "/list", []string{"X-API-Key", "wrong"}, 401},
+		{"GET", "/list", []string{"X-API-Key", "readkey"}, 200},
+		{"GET", "/create?item=a&price=1", []string{"X-API-Key", "readkey"}, 403},
+		{"GET", "/create?item=a&price=1", []string{"Authorization", "Bearer editkey"}, 201},
+		{"GET", "/items/a", []string{"X-API-Key", "readkey"}, 200},
+		{"DELETE", "/items/a", []string{"X-API-Key", "readkey"}, 403},
+		{"DELETE", "/items/a", []string{"Authorization", "Basic YWxpY2U6d3Jvbmc="}, 401}, // alice:wrong
+		{"DELETE", "/items/a", []string{"Authorization", "Basic YWxpY2U6c2VjcmV0"}, 204}, // alice:secret
+	}
+	for _, test := range tests {
+		resp, body := do(t, test.method, ts.URL+test.path, "", test.header...)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s %s %v = %d %s, want %d", test.method, test.path, test.header,
+				resp.StatusCode, body, test.status)
+		}
+		if resp.StatusCode >= 400 && !strings.HasPrefix(body, `{"error":`) {
+			t.Errorf("%s %s: error is not JSON: %s", test.method, test.path, body)
+		}
+	}
+}
+
+func TestAuthRateLimit(t *testing.T) {
+	dir := t.TempDir()
+	keysFile := filepath.Join(dir, "keys.txt")
+	os.WriteFile(keysFile, []byte("bot reader readkey\nci reader cikey\n"), 0600)
+	keys, err := LoadAPIKeys(keysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &authorizer{
+		authenticators: []Authenticator{keys},
+		limiter:        httplimit.New(httplimit.Config{KeyRate: 0.001, KeyBurst: 2, KeyFunc: principalLimit}),
+	}
+	db := &database{store: inventory.NewMemStore()}
+	ts := httptest.NewServer(auth.require(RoleReader, db.list))
+	defer ts.Close()
+
+	// The made-up keys are rejected before the limiter, and take no token
+	// of the principals
+	tests := []struct {
+		key    string
+		status int
+	}{
+		{"readkey", 200},
+		{"wrong1", 401},
+		{"wrong2", 401},
+		{"wrong1", 401},
+		{"readkey", 200},
+		{"readkey", 429},
+		{"cikey", 200},
+	}
+	for i, test := range tests {
+		resp, body := do(t, "GET", ts.URL, "", "X-API-Key", test.key)
+		if resp.StatusCode != test.status {
+			t.Errorf("request %d with %s = %d %s, want %d", i, test.key, resp.StatusCode, body, test.status)
+		}
+	}
+}
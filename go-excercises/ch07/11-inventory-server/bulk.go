@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+// maxImportSize limits the size of an uploaded catalog
+const maxImportSize = 64 << 20
+
+// requestFormat returns the import or export format from the "format"
+// parameter, or else from the Content-Type of the request body
+func requestFormat(r *http.Request) string {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f
+	}
+	mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mt {
+	case "text/csv":
+		return inventory.FormatCSV
+	case "application/json":
+		return inventory.FormatJSON
+	}
+	return ""
+}
+
+// fileFormat returns the import format from the file extension
+func fileFormat(path string) string {
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+}
+
+// importItems creates or replaces the items from a CSV or JSON body.
+// Nothing is imported when a row is invalid or fails to be stored, unless
+// partial=true is given. The status is 207 when some rows failed while
+// others were stored, and 422 when nothing was.
+//
+//	curl -X POST -H "Content-Type: text/csv" --data-binary @items.csv "http://localhost:8080/import"
+//	curl -X POST --data-binary @items.json "http://localhost:8080/import?format=json&partial=true"
+func (d *database) importItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	format := requestFormat(r)
+	if format != inventory.FormatCSV && format != inventory.FormatJSON {
+		jsonError(w, http.StatusUnsupportedMediaType, "format must be csv or json")
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportSize)
+	partial := r.URL.Query().Get("partial") == "true"
+	result, err := inventory.Import(d.storeFor(r), format, body, partial)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The counts are those of the items left stored, even after a failure
+	status := http.StatusOK
+	switch {
+	case len(result.Errors) > 0 && result.Created+result.Updated > 0:
+		status = http.StatusMultiStatus
+	case len(result.Errors) > 0:
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, result)
+}
+
+// exportItems streams all the items as CSV or JSON
+//
+//	curl -o items.csv "http://localhost:8080/export?format=csv"
+func (d *database) exportItems(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = inventory.FormatJSON
+	}
+
+	var contentType string
+	switch format {
+	case inventory.FormatCSV:
+		contentType = "text/csv"
+	case inventory.FormatJSON:
+		contentType = "application/json"
+	default:
+		jsonError(w, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+
+	items, err := d.store.List()
+	if err != nil {
+		jsonStoreError(w, err, "")
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=inventory.%s", format))
+	if err := inventory.Export(streamWriter(w, r), format, items); err != nil {
+		// The status is already sent, so just log the failed stream
+		log.Printf("export: %v", err)
+	}
+}
+
+// importFile loads the items from a CSV or JSON file at startup.
+// The row errors are logged and the import fails, storing nothing unless
+// the undo of the stored rows failed as well.
+func importFile(store inventory.Store, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	result, err := inventory.Import(store, fileFormat(path), f, false)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, e := range result.Errors {
+		log.Printf("%s: %v", path, e)
+	}
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("%s: %d rows failed, %d items left created and %d updated",
+			path, len(result.Errors), result.Created, result.Updated)
+	}
+	log.Printf("%s: %d items created, %d updated", path, result.Created, result.Updated)
+	return nil
+}
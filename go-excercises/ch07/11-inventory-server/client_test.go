@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory/client"
+)
+
+func TestClient(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	c := client.New(ts.URL)
+	ctx := context.Background()
+
+	hat, err := c.Create(ctx, inventory.Item{Name: "hat", Price: usd(300), Quantity: 5, Tags: []string{"men"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hat.Version != 1 || hat.Available != 5 || len(hat.Tags) != 1 {
+		t.Errorf("Create = %+v", hat)
+	}
+	if _, err := c.Create(ctx, inventory.Item{Name: "cap", Price: usd(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if price, err := c.Price(ctx, "hat"); err != nil || price != usd(300) {
+		t.Errorf("Price = %v, %v", price, err)
+	}
+
+	price := usd(250)
+	hat, err = c.Update(ctx, "hat", hat.Version, client.Changes{Price: &price})
+	if err != nil || hat.Price != price || hat.Quantity != 5 || hat.Version != 2 {
+		t.Errorf("Update = %+v, %v", hat, err)
+	}
+
+	items, err := c.List(ctx, client.ListOptions{SortBy: "price", PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].Name != "cap" || items[1].Name != "hat" {
+		t.Errorf("List = %+v, want cap and hat over two pages", items)
+	}
+
+	if err := c.Delete(ctx, "hat", hat.Version); err != nil {
+		t.Errorf("Delete = %v", err)
+	}
+	if _, err := c.Get(ctx, "hat"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	ts := newTestServer()
+	defer ts.Close()
+	c := client.New(ts.URL)
+	ctx := context.Background()
+
+	if _, err := c.Create(ctx, inventory.Item{Name: "hat", Price: usd(300)}); err != nil {
+		t.Fatal(err)
+	}
+	qty := -1
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"missing item", c.Delete(ctx, "cap", inventory.AnyVersion), client.ErrNotFound},
+		{"duplicate", second(c.Create(ctx, inventory.Item{Name: "hat", Price: usd(1)})), client.ErrConflict},
+		{"invalid", second(c.Update(ctx, "hat", inventory.AnyVersion, client.Changes{Quantity: &qty})), client.ErrBadRequest},
+		{"stale version", c.Delete(ctx, "hat", 7), client.ErrVersionChanged},
+	}
+	for _, test := range tests {
+		if !errors.Is(test.err, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, test.err, test.want)
+		}
+		var e *client.Error
+		if !errors.As(test.err, &e) || e.Message == "" {
+			t.Errorf("%s: %v has no server message", test.name, test.err)
+		}
+	}
+}
+
+// usd returns a price in dollars
+func usd(dollars int64) inventory.Money {
+	return inventory.Money{Amount: dollars * 100, Currency: "USD"}
+}
+
+// second returns the error of a two-value call
+func second(_ interface{}, err error) error {
+	return err
+}
+
+// flaky fails the first n requests with 503
+func flaky(n int32, h http.Handler) (http.Handler, *int32) {
+	var calls int32
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= n {
+			jsonError(w, http.StatusServiceUnavailable, "not ready")
+			return
+		}
+		h.ServeHTTP(w, r)
+	}), &calls
+}
+
+func TestClientRetries(t *testing.T) {
+	db := &database{store: inventory.NewMemStore(), audit: inventory.NewAuditLog()}
+	db.store.Create(inventory.Item{Name: "hat", Price: usd(300)})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items", db.items)
+	mux.HandleFunc("/items/", db.item)
+	h, calls := flaky(2, mux)
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	c := client.New(ts.URL)
+	c.Backoff = time.Millisecond
+	if _, err := c.Get(context.Background(), "hat"); err != nil || atomic.LoadInt32(calls) != 3 {
+		t.Errorf("Get = %v after %d calls, want success after 3", err, atomic.LoadInt32(calls))
+	}
+
+	// the creation is not repeated
+	atomic.StoreInt32(calls, 0)
+	_, err := c.Create(context.Background(), inventory.Item{Name: "cap", Price: usd(1)})
+	if !errors.Is(err, client.ErrUnavailable) || atomic.LoadInt32(calls) != 1 {
+		t.Errorf("Create = %v after %d calls, want ErrUnavailable after 1", err, atomic.LoadInt32(calls))
+	}
+
+	// the deletion applied before a failure succeeds on the retry
+	deleted := 0
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if deleted++; deleted == 1 {
+			db.item(httptest.NewRecorder(), r)
+			jsonError(w, http.StatusBadGateway, "response lost")
+			return
+		}
+		db.item(w, r)
+	}))
+	defer ts2.Close()
+	c2 := client.New(ts2.URL)
+	c2.Backoff = time.Millisecond
+	if err := c2.Delete(context.Background(), "hat", inventory.AnyVersion); err != nil || deleted != 2 {
+		t.Errorf("Delete = %v after %d calls, want success after 2", err, deleted)
+	}
+
+	// a response that cannot be decoded is not repeated
+	decoded := 0
+	ts3 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decoded++
+		w.Write([]byte("{broken"))
+	}))
+	defer ts3.Close()
+	c3 := client.New(ts3.URL)
+	c3.Backoff = time.Millisecond
+	if _, err := c3.Get(context.Background(), "hat"); err == nil || decoded != 1 {
+		t.Errorf("Get = %v after %d calls, want an error after 1", err, decoded)
+	}
+
+	// the permanent failures are not repeated, while the refused
+	// connections are until the context expires
+	tlsSrv := httptest.NewTLSServer(mux)
+	defer tlsSrv.Close()
+	closed := httptest.NewServer(mux)
+	closed.Close()
+	for _, test := range []struct {
+		name, url string
+		retried   bool
+	}{
+		{"bad scheme", "ftp://localhost", false},
+		{"untrusted certificate", tlsSrv.URL, false},
+		{"connection refused", closed.URL, true},
+	} {
+		c := client.New(test.url)
+		c.Backoff = time.Hour
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := c.Get(ctx, "hat")
+		cancel()
+		if retried := errors.Is(err, context.DeadlineExceeded); err == nil || retried != test.retried {
+			t.Errorf("%s: Get = %v, want retried %v", test.name, err, test.retried)
+		}
+	}
+
+	// the context stops the retries
+	atomic.StoreInt32(calls, -100)
+	c.Backoff = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, "hat"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Get = %v, want the context deadline", err)
+	}
+}
+
+func TestClientPrefixedList(t *testing.T) {
+	db := &database{store: inventory.NewMemStore(), audit: inventory.NewAuditLog()}
+	for _, name := range []string{"cap", "hat", "scarf"} {
+		db.store.Create(inventory.Item{Name: name, Price: usd(100)})
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items", db.items)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Served under a path prefix, like a tenant
+		r = r.WithContext(context.WithValue(r.Context(), basePathKey{}, "/t/acme"))
+		http.StripPrefix("/t/acme", mux).ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	c := client.New(ts.URL + "/t/acme")
+	items, err := c.List(context.Background(), client.ListOptions{PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 || items[2].Name != "scarf" {
+		t.Errorf("List = %+v, want 3 items over three pages", items)
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// inventoryService implements the InventoryServer generated by protoc over
+// the same tenants as the HTTP handlers. The tenant is selected by the
+// "x-tenant" metadata, and is the default one without it.
+type inventoryService struct {
+	proto.UnimplementedInventoryServer
+	tenants *tenants
+}
+
+// tenantCtxKey is the context key of the tenant of a call
+type tenantCtxKey struct{}
+
+// db returns the database of the tenant authenticated for the call
+func (s *inventoryService) db(ctx context.Context) *database {
+	return ctx.Value(tenantCtxKey{}).(*tenant).db
+}
+
+// grpcStatus maps the error returned by the store to a gRPC status, like
+// storeStatus does for HTTP
+func grpcStatus(err error, item string) error {
+	switch {
+	case errors.Is(err, inventory.ErrNotFound):
+		return status.Error(codes.NotFound, "item not found: "+item)
+	case errors.Is(err, inventory.ErrExists):
+		return status.Error(codes.AlreadyExists, "item already exists: "+item)
+	case errors.Is(err, inventory.ErrInvalid), errors.Is(err, inventory.ErrCurrencyMismatch),
+		errors.Is(err, inventory.ErrInvalidQuery):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, inventory.ErrInsufficientStock):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, inventory.ErrVersionMismatch):
+		return status.Error(codes.Aborted, "item was changed, fetch it again: "+item)
+	case errors.Is(err, inventory.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		log.Printf("store error: %v", err)
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+// toProtoMoney converts the price to the protobuf message
+func toProtoMoney(m inventory.Money) *proto.Money {
+	return &proto.Money{Amount: m.Amount, Currency: m.Currency}
+}
+
+// fromProtoMoney converts the price from the protobuf message, or returns
+// nil when it is not given
+func fromProtoMoney(m *proto.Money) *inventory.Money {
+	if m == nil {
+		return nil
+	}
+	return &inventory.Money{Amount: m.Amount, Currency: strings.ToUpper(m.Currency)}
+}
+
+// toProtoItem converts the item to the protobuf message
+func toProtoItem(it inventory.Item) *proto.Item {
+	return &proto.Item{
+		Name:        it.Name,
+		Sku:         it.SKU,
+		Description: it.Description,
+		Tags:        it.Tags,
+		Price:       toProtoMoney(it.Price),
+		Quantity:    int32(it.Quantity),
+		Reserved:    int32(it.Reserved),
+		Available:   int32(it.Available()),
+		Version:     it.Version,
+		CreatedAt:   timestamppb.New(it.CreatedAt),
+		UpdatedAt:   timestamppb.New(it.UpdatedAt),
+	}
+}
+
+// ListItems implements InventoryServer.ListItems
+func (s *inventoryService) ListItems(ctx context.Context, in *proto.ListItemsRequest) (*proto.ListItemsResponse, error) {
+	if in.PageSize < 0 || in.PageSize > maxPageSize {
+		return nil, status.Errorf(codes.InvalidArgument, "page_size must be 0 to %d", maxPageSize)
+	}
+	items, err := s.db(ctx).store.List()
+	if err != nil {
+		return nil, grpcStatus(err, "")
+	}
+	page, err := inventory.Search(items, inventory.Query{
+		Prefix:   in.Prefix,
+		Contains: in.Contains,
+		MinPrice: fromProtoMoney(in.MinPrice),
+		MaxPrice: fromProtoMoney(in.MaxPrice),
+		SortBy:   in.SortBy,
+		Desc:     in.Desc,
+		Limit:    int(in.PageSize),
+		Cursor:   in.PageToken,
+	})
+	if err != nil {
+		return nil, grpcStatus(err, "")
+	}
+
+	resp := &proto.ListItemsResponse{NextPageToken: page.NextCursor}
+	for _, it := range page.Items {
+		resp.Items = append(resp.Items, toProtoItem(it))
+	}
+	return resp, nil
+}
+
+// GetItem implements InventoryServer.GetItem
+func (s *inventoryService) GetItem(ctx context.Context, in *proto.GetItemRequest) (*proto.Item, error) {
+	it, err := s.db(ctx).store.Get(in.Name)
+	if err != nil {
+		return nil, grpcStatus(err, in.Name)
+	}
+	return toProtoItem(it), nil
+}
+
+// CreateItem implements InventoryServer.CreateItem
+func (s *inventoryService) CreateItem(ctx context.Context, in *proto.CreateItemRequest) (*proto.Item, error) {
+	p := in.Item
+	if p == nil || !validName(p.Name) {
+		return nil, status.Error(codes.InvalidArgument, "name not provided or invalid")
+	}
+	if p.Price == nil {
+		return nil, status.Error(codes.InvalidArgument, "price not provided")
+	}
+
+	it, err := s.db(ctx).storeAs(ctx).Create(inventory.Item{
+		Name:        p.Name,
+		SKU:         p.Sku,
+		Description: p.Description,
+		Tags:        p.Tags,
+		Price:       *fromProtoMoney(p.Price),
+		Quantity:    int(p.Quantity),
+	})
+	if err != nil {
+		return nil, grpcStatus(err, p.Name)
+	}
+	return toProtoItem(it), nil
+}
+
+// UpdateItem implements InventoryServer.UpdateItem
+func (s *inventoryService) UpdateItem(ctx context.Context, in *proto.UpdateItemRequest) (*proto.Item, error) {
+	p := in.Item
+	if p == nil || p.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name not provided")
+	}
+	paths := in.UpdateMask.GetPaths()
+	if len(paths) == 0 {
+		paths = []string{"sku", "description", "tags", "price", "quantity"}
+	}
+	for _, path := range paths {
+		switch path {
+		case "sku", "description", "tags", "quantity":
+		case "price":
+			if p.Price == nil {
+				return nil, status.Error(codes.InvalidArgument, "price not provided")
+			}
+		default:
+			return nil, status.Errorf(codes.InvalidArgument, "field cannot be updated: %s", path)
+		}
+	}
+
+	it, err := s.db(ctx).storeAs(ctx).Update(p.Name, in.Version, func(it *inventory.Item) error {
+		for _, path := range paths {
+			switch path {
+			case "sku":
+				it.SKU = p.Sku
+			case "description":
+				it.Description = p.Description
+			case "tags":
+				it.Tags = p.Tags
+			case "price":
+				it.Price = *fromProtoMoney(p.Price)
+			case "quantity":
+				it.Quantity = int(p.Quantity)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, grpcStatus(err, p.Name)
+	}
+	return toProtoItem(it), nil
+}
+
+// DeleteItem implements InventoryServer.DeleteItem
+func (s *inventoryService) DeleteItem(ctx context.Context, in *proto.DeleteItemRequest) (*proto.DeleteItemResponse, error) {
+	if err := s.db(ctx).storeAs(ctx).Delete(in.Name, in.Version); err != nil {
+		return nil, grpcStatus(err, in.Name)
+	}
+	return &proto.DeleteItemResponse{}, nil
+}
+
+// protoEventTypes maps the broker events to the protobuf enum
+var protoEventTypes = map[string]proto.ItemEvent_Type{
+	inventory.EventCreated: proto.ItemEvent_CREATED,
+	inventory.EventUpdated: proto.ItemEvent_UPDATED,
+	inventory.EventDeleted: proto.ItemEvent_DELETED,
+}
+
+// toProtoEvent converts the event to the protobuf message
+func toProtoEvent(e inventory.Event) *proto.ItemEvent {
+	pe := &proto.ItemEvent{Id: e.ID, Type: protoEventTypes[e.Type], Name: e.Item, Time: timestamppb.New(e.Time)}
+	if e.Data != nil {
+		pe.Item = toProtoItem(*e.Data)
+	}
+	return pe
+}
+
+// WatchItems implements InventoryServer.WatchItems. Like the SSE stream, a
+// client resuming with last_event_id first receives the events it missed,
+// or a RESET event when they are no longer buffered.
+func (s *inventoryService) WatchItems(in *proto.WatchItemsRequest, stream proto.Inventory_WatchItemsServer) error {
+	db := s.db(stream.Context())
+	sub, missed, complete := db.broker.Subscribe(in.LastEventId, in.Prefix)
+	defer db.broker.Unsubscribe(sub)
+
+	if !complete {
+		if err := stream.Send(&proto.ItemEvent{Type: proto.ItemEvent_RESET, Time: timestamppb.Now()}); err != nil {
+			return err
+		}
+	}
+	for _, e := range missed {
+		if err := stream.Send(toProtoEvent(e)); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return status.Error(codes.Unavailable, "too slow to receive the events, resume from the last event")
+			}
+			if err := stream.Send(toProtoEvent(e)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-db.closing:
+			return status.Error(codes.Unavailable, "server is shutting down or the tenant is deleted")
+		}
+	}
+}
+
+// readMethods are the RPCs allowed for the readers, the others need editors
+var readMethods = map[string]bool{
+	"/inventory.Inventory/ListItems":  true,
+	"/inventory.Inventory/GetItem":    true,
+	"/inventory.Inventory/WatchItems": true,
+}
+
+// grpcAuthenticate selects the tenant of the call, and checks the
+// credentials with the same authenticators as HTTP. They are sent as the
+// metadata "x-api-key" or "authorization" ("Bearer <key>" or "Basic <base64>").
+func (ts *tenants) grpcAuthenticate(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	name := defaultTenant
+	if v := md.Get(tenantHeader); len(v) > 0 {
+		name = v[0]
+	}
+	t := ts.get(name)
+	if t == nil {
+		return nil, status.Error(codes.NotFound, "tenant not found: "+name)
+	}
+	ctx = context.WithValue(ctx, tenantCtxKey{}, t)
+	if len(t.auth.authenticators) == 0 {
+		return ctx, nil
+	}
+
+	r := &http.Request{Header: http.Header{}}
+	for _, key := range []string{"X-API-Key", "Authorization"} {
+		if v := md.Get(key); len(v) > 0 {
+			r.Header.Set(key, v[0])
+		}
+	}
+	p, err := t.auth.authenticate(r)
+	switch {
+	case err == errNoCredentials:
+		return nil, status.Error(codes.Unauthenticated, "authentication required")
+	case err != nil:
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	role := RoleEditor
+	if readMethods[method] || strings.HasPrefix(method, "/grpc.reflection.") {
+		role = RoleReader
+	}
+	if p.Role < role {
+		return nil, status.Error(codes.PermissionDenied, fmt.Sprintf("%s role required", role))
+	}
+	return context.WithValue(ctx, principalKey{}, p), nil
+}
+
+// holdTenant keeps the tenant of the authenticated call open until the
+// returned release is called
+func holdTenant(ctx context.Context) (release func(), err error) {
+	t := ctx.Value(tenantCtxKey{}).(*tenant)
+	if !t.acquire() {
+		return nil, status.Error(codes.NotFound, "tenant not found: "+t.Name)
+	}
+	return t.release, nil
+}
+
+// authStream overrides the context of a stream with the principal
+type authStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authStream) Context() context.Context {
+	return s.ctx
+}
+
+// newGRPCServer creates a gRPC server of the tenants with the authentication
+func newGRPCServer(ts *tenants) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, h grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := ts.grpcAuthenticate(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			release, err := holdTenant(ctx)
+			if err != nil {
+				return nil, err
+			}
+			defer release()
+			return h(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, h grpc.StreamHandler) error {
+			ctx, err := ts.grpcAuthenticate(ss.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			release, err := holdTenant(ctx)
+			if err != nil {
+				return err
+			}
+			defer release()
+			return h(srv, &authStream{ss, ctx})
+		}),
+	)
+	proto.RegisterInventoryServer(s, &inventoryService{tenants: ts})
+
+	// Describe the RPCs to tools like grpcurl
+	reflection.Register(s)
+	return s
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// newTestGRPC serves the gRPC API in memory and returns a connected client
+func newTestGRPC(t *testing.T, auth *authorizer) (proto.InventoryClient, *tenants) {
+	db := &database{
+		store:   inventory.NewMemStore(),
+		audit:   inventory.NewAuditLog(),
+		broker:  inventory.NewBroker(16),
+		closing: make(chan struct{}),
+	}
+	db.broker.Watch(db.store)
+
+	ts, err := newTenants("", db, auth, newMetrics(), 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lis := bufconn.Listen(1 << 20)
+	s := newGRPCServer(ts)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewInventoryClient(conn), ts
+}
+
+func TestGRPC(t *testing.T) {
+	c, _ := newTestGRPC(t, &authorizer{})
+	ctx := context.Background()
+
+	hat := &proto.Item{Name: "hat", Sku: "H-1", Price: &proto.Money{Amount: 300_00, Currency: "usd"}, Quantity: 5}
+	created, err := c.CreateItem(ctx, &proto.CreateItemRequest{Item: hat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Version != 1 || created.Available != 5 || created.Price.Currency != "USD" {
+		t.Errorf("CreateItem = %v", created)
+	}
+	c.CreateItem(ctx, &proto.CreateItemRequest{Item: &proto.Item{Name: "cap", Price: &proto.Money{Amount: 100, Currency: "USD"}}})
+
+	// only the fields of the mask are changed
+	updated, err := c.UpdateItem(ctx, &proto.UpdateItemRequest{
+		Item:       &proto.Item{Name: "hat", Quantity: 8},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"quantity"}},
+		Version:    1,
+	})
+	if err != nil || updated.Quantity != 8 || updated.Sku != "H-1" || updated.Version != 2 {
+		t.Errorf("UpdateItem = %v, %v", updated, err)
+	}
+
+	page, err := c.ListItems(ctx, &proto.ListItemsRequest{PageSize: 1})
+	if err != nil || len(page.Items) != 1 || page.Items[0].Name != "cap" || page.NextPageToken == "" {
+		t.Fatalf("ListItems = %v, %v", page, err)
+	}
+	page, err = c.ListItems(ctx, &proto.ListItemsRequest{PageSize: 1, PageToken: page.NextPageToken})
+	if err != nil || len(page.Items) != 1 || page.Items[0].Name != "hat" || page.NextPageToken != "" {
+		t.Errorf("ListItems second page = %v, %v", page, err)
+	}
+
+	if _, err := c.DeleteItem(ctx, &proto.DeleteItemRequest{Name: "hat"}); err != nil {
+		t.Errorf("DeleteItem = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"get missing", second(c.GetItem(ctx, &proto.GetItemRequest{Name: "hat"})), codes.NotFound},
+		{"create duplicate", second(c.CreateItem(ctx, &proto.CreateItemRequest{Item: &proto.Item{Name: "cap", Price: &proto.Money{Currency: "USD"}}})), codes.AlreadyExists},
+		{"create without price", second(c.CreateItem(ctx, &proto.CreateItemRequest{Item: &proto.Item{Name: "scarf"}})), codes.InvalidArgument},
+		{"invalid currency", second(c.CreateItem(ctx, &proto.CreateItemRequest{Item: &proto.Item{Name: "scarf", Price: &proto.Money{Currency: "XYZ"}}})), codes.InvalidArgument},
+		{"stale version", second(c.DeleteItem(ctx, &proto.DeleteItemRequest{Name: "cap", Version: 9})), codes.Aborted},
+		{"unknown field", second(c.UpdateItem(ctx, &proto.UpdateItemRequest{Item: &proto.Item{Name: "cap"}, UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}}})), codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		if got := status.Code(test.err); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.err, test.want)
+		}
+	}
+}
+
+func TestGRPCWatch(t *testing.T) {
+	c, ts := newTestGRPC(t, &authorizer{})
+	db := ts.get(defaultTenant).db
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db.store.Create(inventory.Item{Name: "hat", Price: usd(3)})
+	stream, err := c.WatchItems(ctx, &proto.WatchItemsRequest{Prefix: "s", LastEventId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.store.Create(inventory.Item{Name: "shoe", Price: usd(5)})
+	db.store.Delete("shoe", inventory.AnyVersion)
+
+	for _, want := range []proto.ItemEvent_Type{proto.ItemEvent_CREATED, proto.ItemEvent_DELETED} {
+		e, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Type != want || e.Name != "shoe" || (want == proto.ItemEvent_DELETED) != (e.Item == nil) {
+			t.Errorf("event = %v, want %v of shoe", e, want)
+		}
+	}
+
+	close(db.closing)
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("Recv on shutdown = %v, want Unavailable", err)
+	}
+}
+
+func TestGRPCAuth(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys.txt")
+	os.WriteFile(keysFile, []byte("bot reader r-key\n"), 0600)
+	keys, err := LoadAPIKeys(keysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ts := newTestGRPC(t, &authorizer{authenticators: []Authenticator{keys}})
+	_, acmeKeys, err := ts.create("acme", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", "acme", "x-api-key", acmeKeys["acme-editor"])
+
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+	}
+	list := &proto.ListItemsRequest{}
+	create := &proto.CreateItemRequest{Item: &proto.Item{Name: "hat", Price: &proto.Money{Currency: "USD"}}}
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{"no key", second(c.ListItems(context.Background(), list)), codes.Unauthenticated},
+		{"wrong key", second(c.ListItems(withKey("nope"), list)), codes.Unauthenticated},
+		{"reader lists", second(c.ListItems(withKey("r-key"), list)), codes.OK},
+		{"reader creates", second(c.CreateItem(withKey("r-key"), create)), codes.PermissionDenied},
+		{"tenant creates", second(c.CreateItem(acme, create)), codes.OK},
+		{"not in default tenant", second(c.GetItem(withKey("r-key"), &proto.GetItemRequest{Name: "hat"})), codes.NotFound},
+		{"global reader on tenant", second(c.ListItems(metadata.AppendToOutgoingContext(withKey("r-key"), "x-tenant", "acme"), list)), codes.Unauthenticated},
+		{"unknown tenant", second(c.ListItems(metadata.AppendToOutgoingContext(withKey("r-key"), "x-tenant", "initech"), list)), codes.NotFound},
+	}
+	for _, test := range tests {
+		if got := status.Code(test.err); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.err, test.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+/*
+invctl manages the items of an inventory server from the command line
+
+	invctl list -prefix shoe -sort price -desc
+	invctl get shoe-model1
+	invctl price shoe-model1
+	invctl create -quantity 5 -tags men,winter hat-model1 "19.99 EUR"
+	invctl update -price 17.50 -version 2 hat-model1
+	invctl delete hat-model1
+
+The server and the credentials can also be set in the environment with
+INVENTORY_URL, INVENTORY_TENANT, INVENTORY_API_KEY, INVENTORY_USER and
+INVENTORY_PASSWORD.
+Use -o json to print the JSON of the items instead of a table.
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory/client"
+)
+
+const usage = `Usage: invctl [flags] command [command flags] [args]
+
+Commands:
+  list                         list the items
+  get NAME                     show an item
+  price NAME                   print the price of an item
+  create NAME PRICE            create an item
+  update NAME                  change the fields of an item given as flags
+  delete NAME                  delete an item
+
+Run "invctl command -h" for the flags of a command.
+
+Flags:
+`
+
+// env returns the environment variable, or def if it is not set
+func env(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	server := flag.String("server", env("INVENTORY_URL", "http://localhost:8080"), "URL of the inventory server")
+	tenant := flag.String("tenant", env("INVENTORY_TENANT", ""), "tenant of the inventory, the default one if empty")
+	apiKey := flag.String("api-key", env("INVENTORY_API_KEY", ""), "API key to authenticate")
+	user := flag.String("user", env("INVENTORY_USER", ""), "user name to authenticate with HTTP Basic")
+	output := flag.String("o", "table", "output format: table or json")
+	currency := flag.String("currency", inventory.DefaultCurrency, "currency for prices given without one")
+	timeout := flag.Duration("timeout", 30*time.Second, "maximum time for the command")
+	retries := flag.Int("retries", 2, "times to retry when the server is unavailable")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		fatal(fmt.Errorf("unknown output format: %s", *output))
+	}
+	if !inventory.ValidCurrency(strings.ToUpper(*currency)) {
+		fatal(fmt.Errorf("unknown currency: %s", *currency))
+	}
+	inventory.DefaultCurrency = strings.ToUpper(*currency)
+
+	c := client.New(*server)
+	c.Tenant = *tenant
+	c.APIKey = *apiKey
+	c.Username, c.Password = *user, os.Getenv("INVENTORY_PASSWORD")
+	c.Retries = *retries
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	cmd := &command{client: c, out: os.Stdout, json: *output == "json"}
+	if err := cmd.run(ctx, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fatal(err)
+	}
+}
+
+// fatal prints the error and exits with status 1
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "invctl: %v\n", err)
+	os.Exit(1)
+}
+
+// command runs the subcommands against the server
+type command struct {
+	client *client.Client
+	out    io.Writer
+	json   bool // print JSON instead of tables
+}
+
+// run parses the arguments of the subcommand and runs it
+func (c *command) run(ctx context.Context, name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	switch name {
+	case "list":
+		var opts client.ListOptions
+		fs.StringVar(&opts.Prefix, "prefix", "", "name starts with")
+		fs.StringVar(&opts.Contains, "q", "", "name contains, ignoring the case")
+		fs.StringVar(&opts.MinPrice, "min-price", "", "minimum price")
+		fs.StringVar(&opts.MaxPrice, "max-price", "", "maximum price")
+		fs.StringVar(&opts.SortBy, "sort", "name", "sort by name or price")
+		fs.BoolVar(&opts.Desc, "desc", false, "sort in the descending order")
+		fs.Parse(args)
+		opts.Currency = inventory.DefaultCurrency
+		items, err := c.client.List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if c.json {
+			if items == nil {
+				items = []client.Item{}
+			}
+			return c.printJSON(items)
+		}
+		return c.printTable(items)
+
+	case "get", "price":
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: invctl %s NAME", name)
+		}
+		it, err := c.client.Get(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if name == "get" {
+			return c.printItem(it)
+		}
+		if c.json {
+			return c.printJSON(it.Price)
+		}
+		_, err = fmt.Fprintln(c.out, it.Price)
+		return err
+
+	case "create":
+		var it inventory.Item
+		fs.StringVar(&it.SKU, "sku", "", "stock keeping unit")
+		fs.StringVar(&it.Description, "description", "", "description of the item")
+		tags := fs.String("tags", "", "comma separated tags")
+		fs.IntVar(&it.Quantity, "quantity", 0, "units in stock")
+		fs.Parse(args)
+		if fs.NArg() != 2 {
+			return errors.New("usage: invctl create [flags] NAME PRICE")
+		}
+		price, err := inventory.ParseMoney(fs.Arg(1), "")
+		if err != nil {
+			return err
+		}
+		it.Name, it.Price, it.Tags = fs.Arg(0), price, splitTags(*tags)
+		created, err := c.client.Create(ctx, it)
+		if err != nil {
+			return err
+		}
+		return c.printItem(created)
+
+	case "update":
+		version := fs.Int64("version", inventory.AnyVersion, "change only this version of the item")
+		fs.String("sku", "", "stock keeping unit")
+		fs.String("description", "", "description of the item")
+		fs.String("tags", "", "comma separated tags")
+		fs.String("price", "", "price like 19.99 or \"19.99 EUR\"")
+		fs.Int("quantity", 0, "units in stock")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			return errors.New("usage: invctl update [flags] NAME")
+		}
+		changes, err := flagChanges(fs)
+		if err != nil {
+			return err
+		}
+		it, err := c.client.Update(ctx, fs.Arg(0), *version, changes)
+		if err != nil {
+			return err
+		}
+		return c.printItem(it)
+
+	case "delete":
+		version := fs.Int64("version", inventory.AnyVersion, "delete only this version of the item")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			return errors.New("usage: invctl delete [flags] NAME")
+		}
+		return c.client.Delete(ctx, fs.Arg(0), *version)
+	}
+	return fmt.Errorf("unknown command: %s", name)
+}
+
+// flagChanges returns the changes of only the flags given on the command line
+func flagChanges(fs *flag.FlagSet) (client.Changes, error) {
+	var changes client.Changes
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		v := f.Value.String()
+		switch f.Name {
+		case "sku":
+			changes.SKU = &v
+		case "description":
+			changes.Description = &v
+		case "tags":
+			tags := splitTags(v)
+			if tags == nil {
+				tags = []string{}
+			}
+			changes.Tags = &tags
+		case "price":
+			var price inventory.Money
+			if price, err = inventory.ParseMoney(v, ""); err == nil {
+				changes.Price = &price
+			}
+		case "quantity":
+			qty := f.Value.(flag.Getter).Get().(int)
+			changes.Quantity = &qty
+		}
+	})
+	return changes, err
+}
+
+// splitTags splits the comma separated tags
+func splitTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// printItem prints a single item as a table, or as JSON
+func (c *command) printItem(it client.Item) error {
+	if c.json {
+		return c.printJSON(it)
+	}
+	return c.printTable([]client.Item{it})
+}
+
+// printTable prints the items as a table
+func (c *command) printTable(items []client.Item) error {
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSKU\tPRICE\tQUANTITY\tRESERVED\tAVAILABLE\tVERSION\tTAGS")
+	for _, it := range items {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n", it.Name, it.SKU, it.Price,
+			it.Quantity, it.Reserved, it.Available, it.Version, strings.Join(it.Tags, ","))
+	}
+	return tw.Flush()
+}
+
+// printJSON prints v as indented JSON
+func (c *command) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
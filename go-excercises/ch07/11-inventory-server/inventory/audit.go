@@ -0,0 +1,309 @@
+package inventory
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Operations recorded in the audit log, in addition to opCreate, opUpdate and opDelete
+const opRollback = "rollback"
+
+// ErrVersionNotFound is returned when rolling back to a version without history
+var ErrVersionNotFound = errors.New("version not found in history")
+
+// auditWindow is the number of recent changes kept in memory for History
+// and Rollback. The file keeps them all.
+const auditWindow = 10000
+
+// auditTail is how much of the end of the file is read on opening, to load
+// the recent changes without reading the whole history. A var for the tests.
+var auditTail int64 = 32 << 20
+
+// Change is an entry of the audit log
+type Change struct {
+	Seq   int64     `json:"seq"`
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	Op    string    `json:"op"`
+	Item  string    `json:"item"`
+	Old   *Item     `json:"old,omitempty"` // nil for create
+	New   *Item     `json:"new,omitempty"` // nil for delete
+}
+
+// AuditLog is an append-only trail of every change made through the stores
+// returned by Store. The recent changes are kept in memory, and all of them
+// are optionally appended to a file of JSON lines that is never rewritten.
+type AuditLog struct {
+	mu      sync.Mutex // also serializes the changes, so the log has the store order
+	changes []Change   // the recent changes, oldest first
+	window  int        // the changes served from memory
+	seq     int64      // of the last change
+	f       logFile
+
+	writeErrors int64 // changes kept in memory only, as the file could not be written
+}
+
+// NewAuditLog creates an in-memory audit log
+func NewAuditLog() *AuditLog {
+	return &AuditLog{window: auditWindow}
+}
+
+// OpenAuditLog loads the audit log file at path and opens it for appending
+func OpenAuditLog(path string) (*AuditLog, error) {
+	a := NewAuditLog()
+	if f, err := os.Open(path); err == nil {
+		err = a.load(f, path)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a.f = f
+	return a, nil
+}
+
+// load reads the recent changes of an existing log from the last auditTail
+// bytes of the file. Like the FileStore, only the final line may be broken
+// by a crash.
+func (a *AuditLog) load(f *os.File, path string) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var r io.Reader = f
+	offset := info.Size() - auditTail
+	if offset > 0 {
+		// Skip the line cut by the offset
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		br := bufio.NewReader(f)
+		cut, err := br.ReadBytes('\n')
+		if err != nil {
+			return fmt.Errorf("%s: no change in the last %d bytes", path, auditTail)
+		}
+		offset += int64(len(cut))
+		r = br
+	} else {
+		offset = 0
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 4<<20)
+	badOffset := int64(-1)
+	for ; scanner.Scan(); offset += int64(len(scanner.Bytes())) + 1 {
+		if badOffset >= 0 {
+			return fmt.Errorf("%s: corrupted change at offset %d", path, badOffset)
+		}
+		var c Change
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			badOffset = offset
+			continue
+		}
+		a.add(c)
+	}
+	return scanner.Err()
+}
+
+// add appends the change, and forgets the changes out of the window. They
+// are dropped a window at a time, so that adding stays cheap. It must be
+// called with the lock held.
+func (a *AuditLog) add(c Change) {
+	a.seq = c.Seq
+	a.changes = append(a.changes, c)
+	if len(a.changes) >= 2*a.window {
+		a.changes = append([]Change(nil), a.recent()...)
+	}
+}
+
+// recent returns the changes of the window. It must be called with the
+// lock held.
+func (a *AuditLog) recent() []Change {
+	if len(a.changes) > a.window {
+		return a.changes[len(a.changes)-a.window:]
+	}
+	return a.changes
+}
+
+// record appends a change. It must be called with the lock held, after
+// the change is committed to the store. A change the file cannot take is
+// still kept in memory for History and Rollback, and reported by
+// WriteErrors, as failing the committed change would have it retried.
+func (a *AuditLog) record(actor, op, item string, old, new *Item) {
+	c := Change{
+		Seq:   a.seq + 1,
+		Time:  time.Now().UTC(),
+		Actor: actor,
+		Op:    op,
+		Item:  item,
+		Old:   old,
+		New:   new,
+	}
+	if a.f != nil {
+		if err := appendLine(a.f, c); err != nil {
+			a.writeErrors++
+			log.Printf("audit: writing change %d of %s: %v", c.Seq, item, err)
+		}
+	}
+	a.add(c)
+}
+
+// WriteErrors returns the number of changes missing from the file since it
+// could not be written
+func (a *AuditLog) WriteErrors() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.writeErrors
+}
+
+// History returns the recent changes of an item, oldest first
+func (a *AuditLog) History(item string) []Change {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var list []Change
+	for _, c := range a.recent() {
+		if c.Item == item {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+// Close closes the log file
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.f == nil {
+		return nil
+	}
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
+
+// Store returns a view of s that records the changes in the audit log
+// under the given actor. The view must not be closed, since s is shared.
+func (a *AuditLog) Store(s Store, actor string) Store {
+	return &auditedStore{Store: s, audit: a, actor: actor}
+}
+
+// auditedStore records the successful changes of a Store
+type auditedStore struct {
+	Store
+	audit *AuditLog
+	actor string
+}
+
+func (s *auditedStore) Create(item Item) (Item, error) {
+	s.audit.mu.Lock()
+	defer s.audit.mu.Unlock()
+
+	it, err := s.Store.Create(item)
+	if err != nil {
+		return it, err
+	}
+	s.audit.record(s.actor, opCreate, it.Name, nil, &it)
+	return it, nil
+}
+
+func (s *auditedStore) Update(name string, version int64, change func(*Item) error) (Item, error) {
+	s.audit.mu.Lock()
+	defer s.audit.mu.Unlock()
+	return s.update(opUpdate, name, version, change)
+}
+
+// update applies the change and records the old and new items.
+// It must be called with the audit lock held.
+func (s *auditedStore) update(op, name string, version int64, change func(*Item) error) (Item, error) {
+	var old Item
+	it, err := s.Store.Update(name, version, func(it *Item) error {
+		old = it.clone()
+		return change(it)
+	})
+	if err != nil {
+		return it, err
+	}
+	s.audit.record(s.actor, op, name, &old, &it)
+	return it, nil
+}
+
+func (s *auditedStore) Delete(name string, version int64) error {
+	s.audit.mu.Lock()
+	defer s.audit.mu.Unlock()
+
+	// All the writers hold the audit lock, so the item stays the same
+	// between the Get and the Delete
+	old, err := s.Store.Get(name)
+	if err != nil {
+		return err
+	}
+	if err := s.Store.Delete(name, version); err != nil {
+		return err
+	}
+	s.audit.record(s.actor, opDelete, name, &old, nil)
+	return nil
+}
+
+// Rollback restores the item as it was at the given version, recording it
+// as a new change. Only the fields owned by the users (SKU, description,
+// tags and price) are restored. The stock and the reservations are kept,
+// since the units have moved since then. A deleted item is created again
+// with the stock it had at that version. Only the versions of the recent
+// changes kept in memory can be restored.
+//
+// The store must be a view returned by AuditLog.Store.
+func Rollback(s Store, name string, version int64) (Item, error) {
+	as, ok := s.(*auditedStore)
+	if !ok {
+		return Item{}, errors.New("rollback needs an audited store")
+	}
+	as.audit.mu.Lock()
+	defer as.audit.mu.Unlock()
+
+	// Find the latest state of the item at that version. The versions
+	// start again from 1 when a deleted item is created again.
+	var target *Item
+	for _, c := range as.audit.recent() {
+		if c.Item == name && c.New != nil && c.New.Version == version {
+			target = c.New
+		}
+	}
+	if target == nil {
+		return Item{}, fmt.Errorf("%w: %s version %d", ErrVersionNotFound, name, version)
+	}
+
+	it, err := as.update(opRollback, name, AnyVersion, func(it *Item) error {
+		rec := NewRecord(target.clone())
+		rec.Quantity = it.Quantity
+		rec.apply(it)
+		return nil
+	})
+	if !errors.Is(err, ErrNotFound) {
+		return it, err
+	}
+
+	it, err = as.Store.Create(target.clone())
+	if err != nil {
+		return it, err
+	}
+	as.audit.record(as.actor, opRollback, name, nil, &it)
+	return it, nil
+}
@@ -0,0 +1,173 @@
+package inventory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ops returns a summary of the changes
+func ops(changes []Change) string {
+	var s []string
+	for _, c := range changes {
+		s = append(s, fmt.Sprintf("%d:%s:%s", c.Seq, c.Actor, c.Op))
+	}
+	return fmt.Sprint(s)
+}
+
+func TestAuditRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemStore()
+	alice, bob := audit.Store(mem, "alice"), audit.Store(mem, "bob")
+
+	alice.Create(Item{Name: "shoe", Price: usd(700), Quantity: 5})
+	bob.Update("shoe", AnyVersion, func(it *Item) error { it.Price = usd(900); return it.AdjustStock(-2) })
+	if _, err := bob.Update("shoe", AnyVersion, func(it *Item) error { return it.AdjustStock(-10) }); err == nil {
+		t.Fatal("oversold the stock")
+	}
+
+	h := audit.History("shoe")
+	if ops(h) != "[1:alice:create 2:bob:update]" || h[1].Old.Price != usd(700) || h[1].New.Price != usd(900) {
+		t.Errorf("history = %s", ops(h))
+	}
+
+	// The price returns to version 1, but the stock stays
+	it, err := Rollback(alice, "shoe", 1)
+	if err != nil || it.Price != usd(700) || it.Quantity != 3 || it.Version != 3 {
+		t.Errorf("Rollback = %+v, %v", it, err)
+	}
+	if _, err := Rollback(alice, "shoe", 7); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("Rollback to an unknown version: %v", err)
+	}
+
+	// A deleted item is created again
+	bob.Delete("shoe", AnyVersion)
+	if it, err := Rollback(alice, "shoe", 2); err != nil || it.Price != usd(900) || it.Quantity != 3 {
+		t.Errorf("Rollback of a deleted item = %+v, %v", it, err)
+	}
+	audit.Close()
+
+	audit, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	want := "[1:alice:create 2:bob:update 3:alice:rollback 4:bob:delete 5:alice:rollback]"
+	if got := ops(audit.History("shoe")); got != want {
+		t.Errorf("reopened history = %s, want %s", got, want)
+	}
+}
+
+func TestAuditWriteError(t *testing.T) {
+	audit, err := OpenAuditLog(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.f.Close() // every write fails from now on
+	s := audit.Store(NewMemStore(), "alice")
+
+	// The changes are committed once, and kept in the history
+	if _, err := s.Create(Item{Name: "shoe", Price: usd(700), Quantity: 5}); err != nil {
+		t.Fatalf("Create = %v, want no error as the item is stored", err)
+	}
+	if _, err := s.Update("shoe", AnyVersion, func(it *Item) error { it.Price = usd(900); return nil }); err != nil {
+		t.Fatalf("Update = %v, want no error as the item is stored", err)
+	}
+	if h := audit.History("shoe"); ops(h) != "[1:alice:create 2:alice:update]" {
+		t.Errorf("history = %s", ops(h))
+	}
+	if it, err := Rollback(s, "shoe", 1); err != nil || it.Price != usd(700) {
+		t.Errorf("Rollback = %+v, %v", it, err)
+	}
+	if n := audit.WriteErrors(); n != 3 {
+		t.Errorf("WriteErrors = %d, want 3", n)
+	}
+}
+
+func TestAuditWindow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.window = 2
+	s := audit.Store(NewMemStore(), "alice")
+	s.Create(Item{Name: "shoe", Price: usd(700), Quantity: 5})
+	for _, price := range []int64{800, 900, 1000, 1100} {
+		s.Update("shoe", AnyVersion, func(it *Item) error { it.Price = usd(price); return nil })
+	}
+
+	// Only the recent changes are kept in memory
+	if got := ops(audit.History("shoe")); got != "[4:alice:update 5:alice:update]" {
+		t.Errorf("history = %s, want the last 2 changes", got)
+	}
+	if len(audit.changes) >= 2*audit.window {
+		t.Errorf("%d changes in memory, want less than %d", len(audit.changes), 2*audit.window)
+	}
+	if _, err := Rollback(s, "shoe", 1); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("Rollback out of the window: %v, want %v", err, ErrVersionNotFound)
+	}
+	if it, err := Rollback(s, "shoe", 4); err != nil || it.Price != usd(1000) {
+		t.Errorf("Rollback = %+v, %v", it, err)
+	}
+	audit.Close()
+
+	// Reopening reads only the end of the file, from within the change 4,
+	// and goes on with the sequence
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	defer func(tail int64) { auditTail = tail }(auditTail)
+	auditTail = int64(len(lines[4]) + len(lines[5]) + 10)
+	audit, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	if got := ops(audit.History("shoe")); got != "[5:alice:update 6:alice:rollback]" {
+		t.Errorf("reopened history = %s, want the changes 5 and 6", got)
+	}
+	audit.Store(NewMemStore(), "bob").Create(Item{Name: "hat", Price: usd(300)})
+	if got := ops(audit.History("hat")); got != "[7:bob:create]" {
+		t.Errorf("history after reopening = %s, want 7:bob:create", got)
+	}
+}
+
+func TestAuditPartialWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	audit, err := OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ff := &failingFile{File: audit.f.(*os.File)}
+	audit.f = ff
+	s := audit.Store(NewMemStore(), "alice")
+	s.Create(Item{Name: "shoe", Price: usd(700)})
+	ff.fail = true
+	s.Create(Item{Name: "hat", Price: usd(300)})
+	ff.fail = false
+	s.Create(Item{Name: "socks", Price: usd(100)})
+	audit.Close()
+
+	// The partial line is gone, so the log opens with the changes written
+	audit, err = OpenAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+	if got := ops(audit.History("hat")); got != "[]" {
+		t.Errorf("history of the change not written = %s, want none", got)
+	}
+	if got := ops(audit.History("socks")); got != "[3:alice:create]" {
+		t.Errorf("history = %s, want 3:alice:create", got)
+	}
+}
@@ -0,0 +1,382 @@
+// Package client provides a Go API for the JSON resources of the inventory server.
+//
+//	c := client.New("http://localhost:8080")
+//	c.APIKey = "..."
+//	items, err := c.List(ctx, client.ListOptions{Prefix: "shoe"})
+//	if errors.Is(err, client.ErrNotFound) { ... }
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+// Errors matched by errors.Is for the status codes of the server responses
+var (
+	ErrBadRequest     = errors.New("bad request")         // 400, 422
+	ErrUnauthorized   = errors.New("unauthorized")        // 401
+	ErrForbidden      = errors.New("forbidden")           // 403
+	ErrNotFound       = errors.New("not found")           // 404
+	ErrConflict       = errors.New("conflict")            // 409
+	ErrVersionChanged = errors.New("version changed")     // 412
+	ErrUnavailable    = errors.New("service unavailable") // 429, 5xx
+)
+
+// Error is an error response of the server
+type Error struct {
+	Status  int    // HTTP status code
+	Message string // message of the server
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// Is matches the error with the sentinel of its status code
+func (e *Error) Is(target error) bool {
+	switch e.Status {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusPreconditionFailed:
+		return target == ErrVersionChanged
+	}
+	return (e.Status == http.StatusTooManyRequests || e.Status >= 500) && target == ErrUnavailable
+}
+
+// Item is an inventory item as returned by the server
+type Item struct {
+	inventory.Item
+	Available int `json:"available"`
+}
+
+// Changes are the fields to change with Update. Nil fields are kept.
+type Changes struct {
+	SKU         *string          `json:"sku,omitempty"`
+	Description *string          `json:"description,omitempty"`
+	Tags        *[]string        `json:"tags,omitempty"`
+	Price       *inventory.Money `json:"price,omitempty"`
+	Quantity    *int             `json:"quantity,omitempty"`
+}
+
+// ListOptions search and sort the items. See parseQuery of the server.
+type ListOptions struct {
+	Prefix   string // name starts with
+	Contains string // name contains, ignoring the case
+	MinPrice string // price range like "10" or "9.99 EUR"
+	MaxPrice string
+	Currency string // currency of the price range without one
+	SortBy   string // "name" (default) or "price"
+	Desc     bool
+	PageSize int // items fetched per request, the server default if zero
+}
+
+// values encodes the options as the query parameters
+func (o ListOptions) values() url.Values {
+	v := url.Values{}
+	for param, s := range map[string]string{
+		"prefix": o.Prefix, "q": o.Contains, "min_price": o.MinPrice,
+		"max_price": o.MaxPrice, "currency": o.Currency, "sort": o.SortBy,
+	} {
+		if s != "" {
+			v.Set(param, s)
+		}
+	}
+	if o.Desc {
+		v.Set("order", "desc")
+	}
+	if o.PageSize > 0 {
+		v.Set("limit", strconv.Itoa(o.PageSize))
+	}
+	return v
+}
+
+// Client sends requests to an inventory server. The fields must not be
+// changed while requests are in progress.
+type Client struct {
+	BaseURL    string       // like "http://localhost:8080"
+	HTTPClient *http.Client // http.DefaultClient if nil
+
+	// Tenant selects the inventory of a multi-tenant server, the default one if empty
+	Tenant string
+
+	// APIKey or Username and Password authenticate the requests
+	APIKey             string
+	Username, Password string
+
+	// Retries is the number of times an idempotent request is repeated
+	// after a timeout, a connection refused or reset, or an unavailable
+	// server. The wait starts at Backoff and doubles on each retry, unless
+	// the server sets Retry-After. A retried Delete finding the item gone
+	// succeeds, as the failed attempt may have deleted it.
+	Retries int
+	Backoff time.Duration
+}
+
+// New creates a client of the server with 2 retries
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Retries: 2,
+		Backoff: 200 * time.Millisecond,
+	}
+}
+
+// List returns all the items matching the options, following the pages
+func (c *Client) List(ctx context.Context, opts ListOptions) ([]Item, error) {
+	var all []Item
+	path := "/items"
+	if q := opts.values().Encode(); q != "" {
+		path += "?" + q
+	}
+	for path != "" {
+		var page []Item
+		resp, err := c.do(ctx, http.MethodGet, path, nil, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if path, err = c.resolve(nextLink(resp.Header.Get("Link"))); err != nil {
+			return nil, err
+		}
+	}
+	return all, nil
+}
+
+// resolve returns the absolute URL of a link of the server, which has the
+// path prefix of the BaseURL already, like "/t/acme/items?cursor=x"
+func (c *Client) resolve(link string) (string, error) {
+	if link == "" {
+		return "", nil
+	}
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return "", fmt.Errorf("invalid link %q: %v", link, err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
+
+// Get returns a single item
+func (c *Client) Get(ctx context.Context, name string) (Item, error) {
+	var it Item
+	_, err := c.do(ctx, http.MethodGet, itemPath(name), nil, nil, &it)
+	return it, err
+}
+
+// Price returns the price of an item
+func (c *Client) Price(ctx context.Context, name string) (inventory.Money, error) {
+	it, err := c.Get(ctx, name)
+	return it.Price, err
+}
+
+// Create adds a new item. The version, the reservations and the
+// timestamps of the item are set by the server.
+func (c *Client) Create(ctx context.Context, item inventory.Item) (Item, error) {
+	body := struct {
+		Name string `json:"name"`
+		Changes
+	}{item.Name, Changes{
+		SKU:         &item.SKU,
+		Description: &item.Description,
+		Tags:        &item.Tags,
+		Price:       &item.Price,
+		Quantity:    &item.Quantity,
+	}}
+	if item.Tags == nil {
+		body.Tags = nil
+	}
+	var it Item
+	_, err := c.do(ctx, http.MethodPost, "/items", nil, body, &it)
+	return it, err
+}
+
+// Update changes the given fields of an item. A version other than
+// inventory.AnyVersion fails with ErrVersionChanged if the item has changed.
+func (c *Client) Update(ctx context.Context, name string, version int64, changes Changes) (Item, error) {
+	var it Item
+	_, err := c.do(ctx, http.MethodPatch, itemPath(name), ifMatch(version), changes, &it)
+	return it, err
+}
+
+// Delete removes an item, only at the given version unless it is inventory.AnyVersion
+func (c *Client) Delete(ctx context.Context, name string, version int64) error {
+	_, err := c.do(ctx, http.MethodDelete, itemPath(name), ifMatch(version), nil, nil)
+	return err
+}
+
+// itemPath returns the resource path of an item
+func itemPath(name string) string {
+	return "/items/" + url.PathEscape(name)
+}
+
+// ifMatch returns the header to change only the given version
+func ifMatch(version int64) http.Header {
+	if version == inventory.AnyVersion {
+		return nil
+	}
+	return http.Header{"If-Match": {strconv.Quote(strconv.FormatInt(version, 10))}}
+}
+
+// nextLink returns the target of the rel="next" link, or "" if none
+func nextLink(h string) string {
+	for _, link := range strings.Split(h, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, p := range parts[1:] {
+			if strings.TrimSpace(p) == `rel="next"` {
+				return strings.Trim(target, "<>")
+			}
+		}
+	}
+	return ""
+}
+
+// do sends the request with retries and decodes the response body into out
+func (c *Client) do(ctx context.Context, method, path string, header http.Header, in, out interface{}) (*http.Response, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return nil, err
+		}
+	}
+
+	// POST and PATCH are not repeated, as the first attempt may have
+	// changed the item before the failure
+	retries := c.Retries
+	if method == http.MethodPost || method == http.MethodPatch {
+		retries = 0
+	}
+
+	wait := c.Backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, header, body, out)
+		if attempt > 0 && method == http.MethodDelete && errors.Is(err, ErrNotFound) {
+			// The item is gone, likely by the attempt whose response was lost
+			return resp, nil
+		}
+		if err == nil || attempt >= retries || !retryable(err) {
+			return resp, err
+		}
+		if resp != nil {
+			if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(s) * time.Second
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		wait *= 2
+	}
+}
+
+// retryable reports whether the error may not happen again: an unavailable
+// server, a timeout, or a connection refused or reset. The other failures,
+// like a bad URL, a certificate not trusted, an unknown host or a bad
+// response, would fail again, and so would the canceled or expired context.
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return errors.Is(e, ErrUnavailable)
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// send makes a single attempt of the request. The path is relative to the
+// BaseURL, unless it is an absolute URL resolved from a link.
+func (c *Client) send(ctx context.Context, method, path string, header http.Header, body []byte, out interface{}) (*http.Response, error) {
+	target := c.BaseURL + path
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		target = path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Tenant != "" {
+		req.Header.Set("X-Tenant", c.Tenant)
+	}
+	if c.APIKey != "" {
+		req.Header.Set("X-API-Key", c.APIKey)
+	} else if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return resp, responseError(resp)
+	}
+	if out != nil && resp.StatusCode != http.StatusNoContent {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("%s %s: decoding the response: %v", method, path, err)
+		}
+	}
+	return resp, nil
+}
+
+// responseError reads the structured error of the response
+func responseError(resp *http.Response) error {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	msg := strings.TrimSpace(string(b))
+	if json.Unmarshal(b, &e) == nil && e.Error.Message != "" {
+		msg = e.Error.Message
+	}
+	return &Error{Status: resp.StatusCode, Message: msg}
+}
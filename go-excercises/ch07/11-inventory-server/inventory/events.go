@@ -0,0 +1,152 @@
+package inventory
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types published by the Broker
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+// eventTypes maps the store operations to the event types
+var eventTypes = map[string]string{opCreate: EventCreated, opUpdate: EventUpdated, opDelete: EventDeleted}
+
+// Event is a change of an item pushed to the subscribers
+type Event struct {
+	ID   int64     `json:"id"`
+	Type string    `json:"type"`
+	Item string    `json:"item"`
+	Time time.Time `json:"time"`
+	Data *Item     `json:"data,omitempty"` // nil for EventDeleted
+}
+
+// subscriberBuffer is the number of events a subscriber can fall behind
+// before it is disconnected
+const subscriberBuffer = 64
+
+// Broker fans out the changes of a store to the subscribers. It keeps the
+// recent events in a ring buffer, so a subscriber can resume after a
+// reconnection without missing the events in between.
+type Broker struct {
+	mu     sync.Mutex
+	lastID int64
+	ring   []Event // the recent events, oldest first once full
+	next   int     // position of the next event in the ring
+	full   bool
+	subs   map[*Subscription]bool
+}
+
+// Subscription receives the events of a Broker
+type Subscription struct {
+	// C delivers the events. It is closed when the subscriber is too slow
+	// to keep up, and then the subscriber should resume with a new
+	// subscription from the last received event.
+	C <-chan Event
+
+	c      chan Event
+	prefix string
+}
+
+// NewBroker creates a broker remembering the last size events
+func NewBroker(size int) *Broker {
+	if size < 1 {
+		size = 1
+	}
+	return &Broker{ring: make([]Event, size), subs: make(map[*Subscription]bool)}
+}
+
+// Watch publishes the changes of the store
+func (b *Broker) Watch(s Store) {
+	s.Watch(func(op string, old, new *Item) {
+		name := ""
+		if new != nil {
+			name = new.Name
+		} else if old != nil {
+			name = old.Name
+		}
+		b.Publish(eventTypes[op], name, new)
+	})
+}
+
+// Publish sends an event to the subscribers of the item without blocking.
+// A subscriber with a full buffer is dropped, so slow subscribers never
+// hold up the writers.
+func (b *Broker) Publish(typ, item string, data *Item) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e := Event{ID: b.lastID, Type: typ, Item: item, Time: time.Now().UTC(), Data: data}
+	b.ring[b.next] = e
+	b.next = (b.next + 1) % len(b.ring)
+	if b.next == 0 {
+		b.full = true
+	}
+
+	for sub := range b.subs {
+		if !strings.HasPrefix(item, sub.prefix) {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			delete(b.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// recent returns the events in the ring buffer, oldest first.
+// It must be called with the lock held.
+func (b *Broker) recent() []Event {
+	if !b.full {
+		return b.ring[:b.next]
+	}
+	return append(append([]Event{}, b.ring[b.next:]...), b.ring[:b.next]...)
+}
+
+// Subscribe registers a subscriber for the items starting with prefix.
+// It also returns the buffered events after lastID, so nothing is missed or
+// repeated between them and C. With lastID 0, only the new events are sent.
+// complete is false when some events after lastID have already left the
+// buffer, and then the subscriber must reload the items.
+func (b *Broker) Subscribe(lastID int64, prefix string) (sub *Subscription, missed []Event, complete bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	complete = true
+	if lastID > 0 {
+		recent := b.recent()
+		// The IDs start again from 1 when the server restarts
+		restarted := lastID > b.lastID
+		if restarted || lastID < b.lastID && (len(recent) == 0 || recent[0].ID > lastID+1) {
+			complete = false
+		}
+		for _, e := range recent {
+			if e.ID > lastID && strings.HasPrefix(e.Item, prefix) {
+				missed = append(missed, e)
+			}
+		}
+	}
+
+	c := make(chan Event, subscriberBuffer)
+	sub = &Subscription{C: c, c: c, prefix: prefix}
+	b.subs[sub] = true
+	return sub, missed, complete
+}
+
+// Unsubscribe stops the events of the subscription
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.c)
+	}
+}
@@ -0,0 +1,80 @@
+package inventory
+
+import (
+	"fmt"
+	"testing"
+)
+
+// ids returns the IDs and items of the events
+func ids(events []Event) string {
+	var s []string
+	for _, e := range events {
+		s = append(s, fmt.Sprintf("%d:%s:%s", e.ID, e.Type, e.Item))
+	}
+	return fmt.Sprint(s)
+}
+
+func TestBrokerWatch(t *testing.T) {
+	s := NewMemStore()
+	b := NewBroker(10)
+	b.Watch(s)
+	sub, _, _ := b.Subscribe(0, "shoe")
+
+	s.Create(Item{Name: "shoe", Price: usd(1)})
+	s.Create(Item{Name: "hat", Price: usd(1)})
+	s.Update("shoe", AnyVersion, func(it *Item) error { return it.AdjustStock(2) })
+	s.Delete("shoe", AnyVersion)
+
+	var got []Event
+	for i := 0; i < 3; i++ {
+		got = append(got, <-sub.C)
+	}
+	if ids(got) != "[1:created:shoe 3:updated:shoe 4:deleted:shoe]" || got[1].Data.Quantity != 2 {
+		t.Errorf("events = %s", ids(got))
+	}
+}
+
+func TestBrokerResume(t *testing.T) {
+	b := NewBroker(3)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		b.Publish(EventCreated, name, nil)
+	}
+
+	tests := []struct {
+		lastID   int64
+		want     string
+		complete bool
+	}{
+		{0, "[]", true},
+		{5, "[]", true},
+		{3, "[4:created:d 5:created:e]", true},
+		{2, "[3:created:c 4:created:d 5:created:e]", true},
+		{1, "[3:created:c 4:created:d 5:created:e]", false}, // 2 left the buffer
+		{9, "[]", false},                                    // from before a restart
+	}
+	for _, test := range tests {
+		sub, missed, complete := b.Subscribe(test.lastID, "")
+		if ids(missed) != test.want || complete != test.complete {
+			t.Errorf("Subscribe(%d) = %s %v, want %s %v", test.lastID, ids(missed), complete, test.want, test.complete)
+		}
+		b.Unsubscribe(sub)
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	b := NewBroker(10)
+	slow, _, _ := b.Subscribe(0, "")
+
+	// The publisher never blocks, and the slow subscriber is dropped
+	for i := 0; i < subscriberBuffer+1; i++ {
+		b.Publish(EventUpdated, "shoe", nil)
+	}
+	n := 0
+	for range slow.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Errorf("received %d events before the drop, want %d", n, subscriberBuffer)
+	}
+	b.Unsubscribe(slow) // no double close
+}
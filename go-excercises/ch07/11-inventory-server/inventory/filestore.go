@@ -0,0 +1,222 @@
+package inventory
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// Operations recorded in the log file
+const (
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+)
+
+// record is a single line of the append-only log. A create or update
+// carries the whole item after the change. Example:
+//
+//	{"op":"update","item":"shoe-model1","data":{"name":"shoe-model1","price":...}}
+//	{"op":"delete","item":"shoe-model1"}
+//
+// The older versions wrote only a price, like {"op":"create","item":"x","price":700},
+// which is still read and becomes an item without stock.
+type record struct {
+	Op    string `json:"op"`
+	Item  string `json:"item"`
+	Data  *Item  `json:"data,omitempty"`
+	Price *Money `json:"price,omitempty"`
+}
+
+// FileStore is a Store persisted as an append-only JSON log.
+//
+// Every change is appended to the log as one JSON line before it is applied
+// to the in-memory copy that serves the reads. On startup, the log is
+// replayed and then compacted into a snapshot holding a single create record
+// per item, so the file does not grow forever across restarts.
+type FileStore struct {
+	*MemStore
+	path        string
+	f           logFile
+	writeErrors int64 // failed appends to the log, guarded by MemStore.mu
+}
+
+// logFile is an append-only log file, replaced by the tests to fail the writes
+type logFile interface {
+	io.WriteSeeker
+	Truncate(size int64) error
+	Sync() error
+	Close() error
+}
+
+// OpenFileStore loads the items from the log file at path and opens it for
+// appending the further changes. The file is created if it does not exist.
+func OpenFileStore(path string) (*FileStore, error) {
+	items, err := replay(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := compact(path, items); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{MemStore: &MemStore{}, path: path, f: f}
+	s.items = items
+	s.journal = func(rec record) error {
+		err := appendLine(s.f, rec)
+		if err != nil {
+			s.writeErrors++
+		}
+		return err
+	}
+	return s, nil
+}
+
+// replay reads the log file and applies the records in order
+func replay(path string) (map[string]Item, error) {
+	items := make(map[string]Item)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return items, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20) // items with long descriptions exceed the default 64KB
+	line := 0
+	var badLine int // last line that could not be decoded
+	for scanner.Scan() {
+		line++
+		if badLine != 0 {
+			// Only the final line may be broken (a write interrupted by a crash)
+			return nil, fmt.Errorf("%s:%d: corrupted record", path, badLine)
+		}
+		var rec record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			badLine = line
+			continue
+		}
+		switch rec.Op {
+		case opCreate, opUpdate:
+			var it Item
+			switch {
+			case rec.Data != nil:
+				it = *rec.Data
+			case rec.Price != nil:
+				// Legacy record of an older version
+				it = items[rec.Item]
+				it.Name, it.Price = rec.Item, *rec.Price
+				it.Version++
+			default:
+				return nil, fmt.Errorf("%s:%d: item not found in record", path, line)
+			}
+			items[it.Name] = it
+		case opDelete:
+			delete(items, rec.Item)
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown operation %q", path, line, rec.Op)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// compact rewrites the log with a single create record per item.
+// The snapshot is written to a temporary file and renamed over the log,
+// so a crash in between leaves the old log intact.
+func compact(path string, items map[string]Item) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	// Sort the items to produce a stable snapshot
+	names := make([]string, 0, len(items))
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := bufio.NewWriter(f)
+	for _, name := range names {
+		it := items[name]
+		if err := writeLine(w, record{Op: opCreate, Item: name, Data: &it}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// appendLine appends v to the log as a single JSON line. A write failing
+// partway, like on a full disk, would leave a broken line in the middle of
+// the log, so the file is truncated back to its size before the write.
+func appendLine(f logFile, v interface{}) error {
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if err := writeLine(f, v); err != nil {
+		if terr := f.Truncate(size); terr != nil {
+			return fmt.Errorf("%v, and removing the partial line: %v", err, terr)
+		}
+		f.Seek(size, io.SeekStart)
+		return err
+	}
+	return nil
+}
+
+// writeLine encodes v as a single JSON line
+func writeLine(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// WriteErrors returns the number of changes dropped since the log could not
+// be written
+func (s *FileStore) WriteErrors() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.writeErrors
+}
+
+// Close flushes the log to the disk and closes it
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.f.Sync(); err != nil {
+		s.f.Close()
+		return err
+	}
+	return s.f.Close()
+}
@@ -0,0 +1,137 @@
+package inventory
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Item is a product in the inventory along with its stock
+type Item struct {
+	Name        string   `json:"name"`
+	SKU         string   `json:"sku,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Price       Money    `json:"price"`
+
+	// Quantity is the number of units in stock including the reserved ones
+	Quantity int `json:"quantity"`
+
+	// Reserved is the number of units held by the open reservations
+	Reserved int `json:"reserved"`
+
+	// Reservations maps the reservation ID to the units it holds
+	Reservations map[string]int `json:"reservations,omitempty"`
+
+	// Version is incremented on every change for the optimistic concurrency
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Available returns the units that can still be reserved
+func (it *Item) Available() int {
+	return it.Quantity - it.Reserved
+}
+
+// clone returns a deep copy, so the stored item is never shared with callers
+func (it Item) clone() Item {
+	if it.Tags != nil {
+		it.Tags = append([]string(nil), it.Tags...)
+	}
+	if it.Reservations != nil {
+		r := make(map[string]int, len(it.Reservations))
+		for id, qty := range it.Reservations {
+			r[id] = qty
+		}
+		it.Reservations = r
+	}
+	return it
+}
+
+// validate checks the item before it is stored
+func (it *Item) validate() error {
+	switch {
+	case it.Name == "":
+		return fmt.Errorf("%w: name not provided", ErrInvalid)
+	case strings.Contains(it.Name, "/"):
+		return fmt.Errorf("%w: name cannot contain '/'", ErrInvalid)
+	case !ValidCurrency(it.Price.Currency):
+		return fmt.Errorf("%w: unknown currency %q", ErrInvalid, it.Price.Currency)
+	case it.Price.Amount < 0:
+		return fmt.Errorf("%w: negative price %s", ErrInvalid, it.Price)
+	case it.Quantity < 0:
+		return fmt.Errorf("%w: negative quantity %d", ErrInvalid, it.Quantity)
+	case it.Quantity < it.Reserved:
+		return fmt.Errorf("%w: quantity %d is below the reserved %d units",
+			ErrInsufficientStock, it.Quantity, it.Reserved)
+	}
+	return nil
+}
+
+// AdjustStock adds delta units to the stock, or removes them when negative.
+// The reserved units cannot be removed.
+func (it *Item) AdjustStock(delta int) error {
+	if it.Available()+delta < 0 {
+		return fmt.Errorf("%w: %d available, cannot remove %d",
+			ErrInsufficientStock, it.Available(), -delta)
+	}
+	it.Quantity += delta
+	return nil
+}
+
+// Reserve holds qty units for an order and returns the reservation ID
+func (it *Item) Reserve(qty int) (string, error) {
+	if qty <= 0 {
+		return "", fmt.Errorf("%w: quantity must be positive", ErrInvalid)
+	}
+	if qty > it.Available() {
+		return "", fmt.Errorf("%w: %d available, cannot reserve %d",
+			ErrInsufficientStock, it.Available(), qty)
+	}
+
+	id, err := newReservationID()
+	if err != nil {
+		return "", err
+	}
+	if it.Reservations == nil {
+		it.Reservations = make(map[string]int)
+	}
+	it.Reservations[id] = qty
+	it.Reserved += qty
+	return id, nil
+}
+
+// Release cancels the reservation and makes its units available again
+func (it *Item) Release(id string) error {
+	qty, ok := it.Reservations[id]
+	if !ok {
+		return ErrReservationNotFound
+	}
+	delete(it.Reservations, id)
+	it.Reserved -= qty
+	return nil
+}
+
+// Commit fulfils the reservation, so its units leave the stock
+func (it *Item) Commit(id string) error {
+	qty, ok := it.Reservations[id]
+	if !ok {
+		return ErrReservationNotFound
+	}
+	delete(it.Reservations, id)
+	it.Reserved -= qty
+	it.Quantity -= qty
+	return nil
+}
+
+// newReservationID returns a random ID like "9f86d081884c7d65"
+func newReservationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
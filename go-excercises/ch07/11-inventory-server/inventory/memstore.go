@@ -0,0 +1,176 @@
+package inventory
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemStore is an in-memory Store guarded by a sync.RWMutex.
+// The data is lost when the process exits.
+type MemStore struct {
+	mu    sync.RWMutex
+	items map[string]Item
+
+	// journal is called with every change before it is applied, while
+	// holding the lock. The change is dropped when journal fails.
+	journal func(record) error
+
+	// watchers are called with every applied change, see Watch
+	watchers []func(op string, old, new *Item)
+}
+
+// NewMemStore creates an empty in-memory store
+func NewMemStore() *MemStore {
+	return &MemStore{items: make(map[string]Item)}
+}
+
+// List returns a copy of all the items, so callers can iterate without the lock
+func (s *MemStore) List() ([]Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := make([]Item, 0, len(s.items))
+	for _, it := range s.items {
+		items = append(items, it.clone())
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	return items, nil
+}
+
+// Len returns the number of items
+func (s *MemStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.items)
+}
+
+// Get returns a copy of the item
+func (s *MemStore) Get(name string) (Item, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	it, ok := s.items[name]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+	return it.clone(), nil
+}
+
+// Create adds a new item
+func (s *MemStore) Create(item Item) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.items[item.Name]; ok {
+		return Item{}, ErrExists
+	}
+
+	item = item.clone()
+	item.Reserved, item.Reservations = 0, nil
+	item.Version = 1
+	item.CreatedAt = time.Now().UTC()
+	item.UpdatedAt = item.CreatedAt
+	if err := item.validate(); err != nil {
+		return Item{}, err
+	}
+	if err := s.put(opCreate, nil, item); err != nil {
+		return Item{}, err
+	}
+	return item.clone(), nil
+}
+
+// Update applies the change to a copy of the item and stores it
+func (s *MemStore) Update(name string, version int64, change func(*Item) error) (Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.items[name]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+	if version != AnyVersion && version != old.Version {
+		return Item{}, ErrVersionMismatch
+	}
+
+	item := old.clone()
+	if err := change(&item); err != nil {
+		return Item{}, err
+	}
+
+	// The identity and bookkeeping fields are owned by the store
+	item.Name, item.CreatedAt = old.Name, old.CreatedAt
+	item.Version = old.Version + 1
+	item.UpdatedAt = time.Now().UTC()
+	if err := item.validate(); err != nil {
+		return Item{}, err
+	}
+	if err := s.put(opUpdate, &old, item); err != nil {
+		return Item{}, err
+	}
+	return item.clone(), nil
+}
+
+// Delete removes an existing item
+func (s *MemStore) Delete(name string, version int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.items[name]
+	if !ok {
+		return ErrNotFound
+	}
+	if version != AnyVersion && version != old.Version {
+		return ErrVersionMismatch
+	}
+
+	if s.journal != nil {
+		if err := s.journal(record{Op: opDelete, Item: name}); err != nil {
+			return err
+		}
+	}
+	delete(s.items, name)
+	s.notify(opDelete, &old, nil)
+	return nil
+}
+
+// put journals and stores the item. It must be called with the lock held.
+func (s *MemStore) put(op string, old *Item, item Item) error {
+	if s.journal != nil {
+		if err := s.journal(record{Op: op, Item: item.Name, Data: &item}); err != nil {
+			return err
+		}
+	}
+	s.items[item.Name] = item
+	s.notify(op, old, &item)
+	return nil
+}
+
+// notify calls the watchers with copies of the items, so they cannot
+// change the stored ones. It must be called with the lock held.
+func (s *MemStore) notify(op string, old, new *Item) {
+	for _, fn := range s.watchers {
+		var o, n *Item
+		if old != nil {
+			c := old.clone()
+			o = &c
+		}
+		if new != nil {
+			c := new.clone()
+			n = &c
+		}
+		fn(op, o, n)
+	}
+}
+
+// Watch registers fn to be called after every change
+func (s *MemStore) Watch(fn func(op string, old, new *Item)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers = append(s.watchers, fn)
+}
+
+// Close does nothing for the in-memory store
+func (s *MemStore) Close() error {
+	return nil
+}
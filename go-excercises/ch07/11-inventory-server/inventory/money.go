@@ -0,0 +1,243 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is used when a price is given without a currency code
+var DefaultCurrency = "USD"
+
+// currencies maps the supported ISO 4217 codes to the number of digits
+// after the decimal point (the minor unit exponent)
+var currencies = map[string]int{
+	"AUD": 2, "CAD": 2, "CHF": 2, "CNY": 2, "EUR": 2, "GBP": 2,
+	"INR": 2, "SGD": 2, "USD": 2, "JPY": 0, "KRW": 0, "BHD": 3, "KWD": 3,
+}
+
+var (
+	// ErrCurrencyMismatch is returned for arithmetic between different currencies
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+
+	// ErrOverflow is returned when the result does not fit in the minor units
+	ErrOverflow = errors.New("money overflow")
+)
+
+// Money is a fixed-point amount in the minor units of a currency.
+// For example, 19.99 USD is stored as Amount 1999 and Currency "USD".
+// Floats are never used, so there are no rounding errors.
+type Money struct {
+	Amount   int64  // amount in minor units (cents for USD)
+	Currency string // ISO 4217 currency code
+}
+
+// ValidCurrency reports whether the currency code is supported
+func ValidCurrency(code string) bool {
+	_, ok := currencies[code]
+	return ok
+}
+
+// Currencies returns the supported currency codes in order
+func Currencies() []string {
+	codes := make([]string, 0, len(currencies))
+	for code := range currencies {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// ParseMoney parses an amount like "19.99" or "19.99 USD".
+// The currency can be given either within s or separately, and falls back
+// to DefaultCurrency when both are empty.
+func ParseMoney(s, currency string) (Money, error) {
+	amount, code := strings.TrimSpace(s), ""
+	if i := strings.IndexByte(amount, ' '); i >= 0 {
+		amount, code = amount[:i], strings.TrimSpace(amount[i+1:])
+	}
+	code, currency = strings.ToUpper(code), strings.ToUpper(currency)
+	switch {
+	case code == "":
+		code = currency
+	case currency != "" && currency != code:
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, code, currency)
+	}
+	if code == "" {
+		code = DefaultCurrency
+	}
+
+	exp, ok := currencies[code]
+	if !ok {
+		return Money{}, fmt.Errorf("unknown currency: %q", code)
+	}
+	minor, err := parseMinor(amount, exp)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: minor, Currency: code}, nil
+}
+
+// parseMinor converts a decimal string to minor units with exp digits after
+// the decimal point. More digits than exp are rejected instead of rounded.
+func parseMinor(s string, exp int) (int64, error) {
+	invalid := fmt.Errorf("invalid amount: %q", s)
+	neg := false
+	if strings.HasPrefix(s, "-") {
+		neg, s = true, s[1:]
+	} else if strings.HasPrefix(s, "+") {
+		s = s[1:]
+	}
+
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return 0, invalid
+	}
+	if len(frac) > exp {
+		return 0, fmt.Errorf("invalid amount: %q has more than %d decimal places", s, exp)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	n, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return 0, ErrOverflow
+		}
+		return 0, invalid
+	}
+	if neg {
+		n = -n
+	}
+	return n, nil
+}
+
+// isDigits reports whether s has only the decimal digits
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Decimal formats the amount without the currency. Example: 19.99
+func (m Money) Decimal() string {
+	exp := currencies[m.Currency]
+	s := strconv.FormatInt(m.Amount, 10)
+	if exp == 0 {
+		return s
+	}
+
+	sign := ""
+	if m.Amount < 0 {
+		sign, s = "-", s[1:]
+	}
+	if len(s) <= exp {
+		s = strings.Repeat("0", exp-len(s)+1) + s
+	}
+	return sign + s[:len(s)-exp] + "." + s[len(s)-exp:]
+}
+
+// String formats the amount with the currency. Example: 19.99 USD
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+// Add returns m + o, both must be in the same currency
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	sum := m.Amount + o.Amount
+	// Overflow happens only when both have the same sign and the sum does not
+	if (m.Amount >= 0) == (o.Amount >= 0) && (sum >= 0) != (m.Amount >= 0) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Sub returns m - o, both must be in the same currency
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(o.Neg())
+}
+
+// Mul returns m multiplied by n, such as the total price of n units
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+	p := m.Amount * n
+	if p/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) ||
+		(n == -1 && m.Amount == math.MinInt64) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: p, Currency: m.Currency}, nil
+}
+
+// Neg returns -m
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Cmp compares m and o of the same currency, and returns -1, 0 or +1
+func (m Money) Cmp(o Money) (int, error) {
+	if m.Currency != o.Currency {
+		return 0, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, o.Currency)
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return +1, nil
+	}
+	return 0, nil
+}
+
+// moneyJSON is the JSON form of Money. The amount is a decimal string, so
+// JSON decoders in other languages do not turn it into a float.
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// MarshalJSON encodes m as {"amount":"19.99","currency":"USD"}
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal(), Currency: m.Currency})
+}
+
+// UnmarshalJSON decodes the object form, or a bare number or string such as
+// 19.99 and "19.99 EUR" for the older clients. A bare number is taken in
+// DefaultCurrency and parsed from its literal text, never through a float.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	var err error
+	switch {
+	case len(b) > 0 && b[0] == '{':
+		var v moneyJSON
+		if err := json.Unmarshal(b, &v); err != nil {
+			return err
+		}
+		*m, err = ParseMoney(v.Amount, v.Currency)
+	case len(b) > 0 && b[0] == '"':
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		*m, err = ParseMoney(s, "")
+	default:
+		*m, err = ParseMoney(string(b), "")
+	}
+	return err
+}
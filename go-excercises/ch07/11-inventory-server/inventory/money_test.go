@@ -0,0 +1,100 @@
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		s, currency string
+		want        Money
+		err         bool
+	}{
+		{"19.99", "", Money{1999, "USD"}, false},
+		{"19.9", "", Money{1990, "USD"}, false},
+		{"800", "", Money{80000, "USD"}, false},
+		{".5", "EUR", Money{50, "EUR"}, false},
+		{"-0.01 GBP", "", Money{-1, "GBP"}, false},
+		{"4.99 eur", "EUR", Money{499, "EUR"}, false},
+		{"1500", "JPY", Money{1500, "JPY"}, false},
+		{"1.234", "KWD", Money{1234, "KWD"}, false},
+		{"19.999", "", Money{}, true},      // no silent rounding
+		{"10.5", "JPY", Money{}, true},     // JPY has no minor units
+		{"4.99 EUR", "USD", Money{}, true}, // conflicting currencies
+		{"12", "XXX", Money{}, true},
+		{"", "", Money{}, true},
+		{".", "", Money{}, true},
+		{"1e3", "", Money{}, true},
+		{"99999999999999999999", "", Money{}, true},
+	}
+	for _, test := range tests {
+		got, err := ParseMoney(test.s, test.currency)
+		if (err != nil) != test.err || got != test.want {
+			t.Errorf("ParseMoney(%q, %q) = %v, %v", test.s, test.currency, got, err)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		m    Money
+		want string
+	}{
+		{Money{1999, "USD"}, "19.99 USD"},
+		{Money{5, "USD"}, "0.05 USD"},
+		{Money{-5, "USD"}, "-0.05 USD"},
+		{Money{-1234, "KWD"}, "-1.234 KWD"},
+		{Money{1500, "JPY"}, "1500 JPY"},
+	}
+	for _, test := range tests {
+		if got := test.m.String(); got != test.want {
+			t.Errorf("%#v.String() = %q, want %q", test.m, got, test.want)
+		}
+	}
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	a, b := Money{1999, "USD"}, Money{1, "USD"}
+	if sum, err := a.Add(b); err != nil || sum != (Money{2000, "USD"}) {
+		t.Errorf("Add = %v, %v", sum, err)
+	}
+	if diff, err := a.Sub(b); err != nil || diff != (Money{1998, "USD"}) {
+		t.Errorf("Sub = %v, %v", diff, err)
+	}
+	if total, err := a.Mul(3); err != nil || total != (Money{5997, "USD"}) {
+		t.Errorf("Mul = %v, %v", total, err)
+	}
+	if c, err := a.Cmp(b); err != nil || c != 1 {
+		t.Errorf("Cmp = %v, %v", c, err)
+	}
+	if _, err := a.Add(Money{1, "EUR"}); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add of different currencies: %v", err)
+	}
+	if _, err := (Money{1 << 62, "USD"}).Mul(4); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Mul overflow: %v", err)
+	}
+	if _, err := (Money{1 << 62, "USD"}).Add(Money{1 << 62, "USD"}); !errors.Is(err, ErrOverflow) {
+		t.Errorf("Add overflow: %v", err)
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	b, err := json.Marshal(Money{1999, "USD"})
+	if err != nil || string(b) != `{"amount":"19.99","currency":"USD"}` {
+		t.Errorf("Marshal = %s, %v", b, err)
+	}
+
+	for input, want := range map[string]Money{
+		`{"amount":"19.99","currency":"EUR"}`: {1999, "EUR"},
+		`"4.99 GBP"`:                          {499, "GBP"},
+		`19.99`:                               {1999, "USD"},
+		`700`:                                 {70000, "USD"},
+	} {
+		var m Money
+		if err := json.Unmarshal([]byte(input), &m); err != nil || m != want {
+			t.Errorf("Unmarshal(%s) = %v, %v", input, m, err)
+		}
+	}
+}
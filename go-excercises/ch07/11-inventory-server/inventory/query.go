@@ -0,0 +1,163 @@
+package inventory
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidQuery is returned for a malformed search query or cursor
+var ErrInvalidQuery = errors.New("invalid query")
+
+// Sort orders supported by Query
+const (
+	SortByName  = "name"
+	SortByPrice = "price"
+)
+
+// Query selects a page of items for the list endpoints
+type Query struct {
+	Prefix   string // name starts with
+	Contains string // name contains, ignoring the case
+	MinPrice *Money // inclusive, only the items in the same currency match
+	MaxPrice *Money // inclusive, only the items in the same currency match
+
+	SortBy string // SortByName (default) or SortByPrice
+	Desc   bool   // descending order
+
+	Limit  int    // maximum items in a page, 0 means no limit
+	Cursor string // the NextCursor of the previous page
+}
+
+// Page is a result of Search
+type Page struct {
+	Items []Item
+
+	// NextCursor fetches the following page, empty for the last page
+	NextCursor string
+}
+
+// cursor is the position after the last item of a page. It holds the sort
+// key of that item instead of an offset, so the following pages remain
+// stable while the items are added or deleted.
+type cursor struct {
+	SortBy   string `json:"s"`
+	Desc     bool   `json:"d,omitempty"`
+	Name     string `json:"n"`
+	Currency string `json:"c,omitempty"`
+	Amount   int64  `json:"a,omitempty"`
+}
+
+// encode returns the cursor as an opaque URL-safe string
+func (c cursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor parses a cursor made by encode
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("%w: bad cursor", ErrInvalidQuery)
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("%w: bad cursor", ErrInvalidQuery)
+	}
+	return c, nil
+}
+
+// Search filters, sorts and paginates the items
+func Search(items []Item, q Query) (Page, error) {
+	if q.SortBy == "" {
+		q.SortBy = SortByName
+	}
+	if q.SortBy != SortByName && q.SortBy != SortByPrice {
+		return Page{}, fmt.Errorf("%w: unknown sort %q", ErrInvalidQuery, q.SortBy)
+	}
+	if q.Limit < 0 {
+		return Page{}, fmt.Errorf("%w: negative limit", ErrInvalidQuery)
+	}
+
+	var matched []Item
+	for _, it := range items {
+		if q.match(&it) {
+			matched = append(matched, it)
+		}
+	}
+
+	less := func(a, b *Item) bool { return compare(q.SortBy, a, b) < 0 }
+	if q.Desc {
+		less = func(a, b *Item) bool { return compare(q.SortBy, a, b) > 0 }
+	}
+	sort.Slice(matched, func(i, j int) bool { return less(&matched[i], &matched[j]) })
+
+	// Skip the items up to the cursor
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return Page{}, err
+		}
+		if c.SortBy != q.SortBy || c.Desc != q.Desc {
+			return Page{}, fmt.Errorf("%w: cursor of a different sort order", ErrInvalidQuery)
+		}
+		last := Item{Name: c.Name, Price: Money{Amount: c.Amount, Currency: c.Currency}}
+		start := sort.Search(len(matched), func(i int) bool { return less(&last, &matched[i]) })
+		matched = matched[start:]
+	}
+
+	var page Page
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+		last := matched[len(matched)-1]
+		c := cursor{SortBy: q.SortBy, Desc: q.Desc, Name: last.Name}
+		if q.SortBy == SortByPrice {
+			c.Currency, c.Amount = last.Price.Currency, last.Price.Amount
+		}
+		page.NextCursor = c.encode()
+	}
+	page.Items = matched
+	return page, nil
+}
+
+// match reports whether the item passes the filters of the query
+func (q *Query) match(it *Item) bool {
+	if !strings.HasPrefix(it.Name, q.Prefix) {
+		return false
+	}
+	if q.Contains != "" && !strings.Contains(strings.ToLower(it.Name), strings.ToLower(q.Contains)) {
+		return false
+	}
+	if q.MinPrice != nil {
+		if c, err := it.Price.Cmp(*q.MinPrice); err != nil || c < 0 {
+			return false
+		}
+	}
+	if q.MaxPrice != nil {
+		if c, err := it.Price.Cmp(*q.MaxPrice); err != nil || c > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// compare orders the items by the sort key and then by the unique name,
+// which gives a total order for a stable pagination. The prices in
+// different currencies are not comparable, so they are grouped by currency.
+func compare(sortBy string, a, b *Item) int {
+	if sortBy == SortByPrice {
+		if c := strings.Compare(a.Price.Currency, b.Price.Currency); c != 0 {
+			return c
+		}
+		if a.Price.Amount != b.Price.Amount {
+			if a.Price.Amount < b.Price.Amount {
+				return -1
+			}
+			return +1
+		}
+	}
+	return strings.Compare(a.Name, b.Name)
+}
@@ -0,0 +1,89 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// names returns the item names of a page
+func names(items []Item) string {
+	var s []string
+	for _, it := range items {
+		s = append(s, it.Name)
+	}
+	return fmt.Sprint(s)
+}
+
+var catalog = []Item{
+	{Name: "shoe-model1", Price: usd(700)},
+	{Name: "shoe-model2", Price: usd(800)},
+	{Name: "socks-type1", Price: usd(100)},
+	{Name: "socks-type2", Price: usd(100)},
+	{Name: "Hat-Model1", Price: usd(300)},
+	{Name: "scarf", Price: Money{Amount: 2000, Currency: "EUR"}},
+}
+
+func TestSearch(t *testing.T) {
+	min, max := usd(100), usd(700)
+	tests := []struct {
+		q    Query
+		want string
+	}{
+		{Query{}, "[Hat-Model1 scarf shoe-model1 shoe-model2 socks-type1 socks-type2]"},
+		{Query{Prefix: "shoe"}, "[shoe-model1 shoe-model2]"},
+		{Query{Contains: "MODEL1"}, "[Hat-Model1 shoe-model1]"},
+		{Query{MinPrice: &min, MaxPrice: &max}, "[Hat-Model1 shoe-model1 socks-type1 socks-type2]"},
+		{Query{SortBy: SortByPrice}, "[scarf socks-type1 socks-type2 Hat-Model1 shoe-model1 shoe-model2]"},
+		{Query{SortBy: SortByPrice, Desc: true, Prefix: "s"}, "[shoe-model2 shoe-model1 socks-type2 socks-type1 scarf]"},
+	}
+	for _, test := range tests {
+		page, err := Search(catalog, test.q)
+		if err != nil || names(page.Items) != test.want || page.NextCursor != "" {
+			t.Errorf("Search(%+v) = %s %q, %v, want %s", test.q, names(page.Items), page.NextCursor, err, test.want)
+		}
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	for _, q := range []Query{
+		{SortBy: SortByName, Limit: 4},
+		{SortBy: SortByPrice, Limit: 2},
+		{SortBy: SortByPrice, Desc: true, Limit: 1},
+	} {
+		all, _ := Search(catalog, Query{SortBy: q.SortBy, Desc: q.Desc})
+
+		var got []Item
+		for {
+			page, err := Search(catalog, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, page.Items...)
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		if names(got) != names(all.Items) {
+			t.Errorf("pages of %+v = %s, want %s", q, names(got), names(all.Items))
+		}
+	}
+}
+
+func TestSearchCursorStable(t *testing.T) {
+	page, _ := Search(catalog, Query{Limit: 2})
+
+	// Delete an item of the first page before fetching the second
+	items := append([]Item{}, catalog[:4]...)
+	items = append(items, catalog[5])
+	next, err := Search(items, Query{Limit: 2, Cursor: page.NextCursor})
+	if err != nil || names(next.Items) != "[shoe-model1 shoe-model2]" {
+		t.Errorf("second page = %s, %v", names(next.Items), err)
+	}
+
+	_, err = Search(catalog, Query{SortBy: SortByPrice, Cursor: page.NextCursor})
+	if !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("cursor of another sort order: %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrQuotaExceeded is returned when creating more items than the quota of the store
+var ErrQuotaExceeded = errors.New("item quota exceeded")
+
+// quotaStore limits the number of items of a Store
+type quotaStore struct {
+	Store
+	max int
+
+	// mu serializes the creations, so two of them cannot both pass the
+	// count for the last item. The deletions only lower the count.
+	mu sync.Mutex
+}
+
+// WithQuota limits the store to max items. The store is returned as is
+// when max is 0 or less.
+func WithQuota(s Store, max int) Store {
+	if max <= 0 {
+		return s
+	}
+	return &quotaStore{Store: s, max: max}
+}
+
+// Create adds the item unless the store is full
+func (q *quotaStore) Create(item Item) (Item, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n, err := q.len()
+	if err != nil {
+		return Item{}, err
+	}
+	if n >= q.max {
+		// An existing name is reported as such, not as a full store
+		if _, err := q.Store.Get(item.Name); err == nil {
+			return Item{}, ErrExists
+		}
+		return Item{}, fmt.Errorf("%w: the limit is %d items", ErrQuotaExceeded, q.max)
+	}
+	return q.Store.Create(item)
+}
+
+// len counts the items of the limited store
+func (q *quotaStore) len() (int, error) {
+	return Len(q.Store)
+}
+
+// Unwrap returns the limited store
+func (q *quotaStore) Unwrap() Store {
+	return q.Store
+}
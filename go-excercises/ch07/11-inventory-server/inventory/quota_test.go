@@ -0,0 +1,46 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	s := WithQuota(NewMemStore(), 2)
+	for _, name := range []string{"hat", "cap"} {
+		if _, err := s.Create(Item{Name: name, Price: usd(1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Create(Item{Name: "scarf", Price: usd(1)}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Create over the quota = %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := s.Create(Item{Name: "hat", Price: usd(1)}); err != ErrExists {
+		t.Errorf("Create of an existing item = %v, want ErrExists", err)
+	}
+	if _, err := s.Update("hat", AnyVersion, func(it *Item) error { it.Quantity = 3; return nil }); err != nil {
+		t.Errorf("Update in a full store = %v", err)
+	}
+	s.Delete("cap", AnyVersion)
+	if _, err := s.Create(Item{Name: "scarf", Price: usd(1)}); err != nil {
+		t.Errorf("Create after a delete = %v", err)
+	}
+}
+
+func TestQuotaConcurrent(t *testing.T) {
+	s := WithQuota(NewMemStore(), 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s.Create(Item{Name: fmt.Sprintf("item%d", i), Price: usd(1)})
+		}(i)
+	}
+	wg.Wait()
+	if items, _ := s.List(); len(items) != 10 {
+		t.Errorf("%d items created, want the quota of 10", len(items))
+	}
+}
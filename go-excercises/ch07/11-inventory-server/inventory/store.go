@@ -0,0 +1,95 @@
+// Package inventory provides the storage layer for the inventory server.
+//
+// A Store keeps the items with their price and stock, where the price is a
+// fixed-point Money amount in a currency. All the implementations are safe
+// for concurrent use, so the HTTP handlers can share a single Store across
+// the goroutines serving the requests.
+package inventory
+
+import "errors"
+
+var (
+	// ErrNotFound is returned when the requested item does not exist
+	ErrNotFound = errors.New("item not found")
+
+	// ErrExists is returned when creating an item that already exists
+	ErrExists = errors.New("item already exists")
+
+	// ErrInvalid is returned when an item fails the validation
+	ErrInvalid = errors.New("invalid item")
+
+	// ErrInsufficientStock is returned when the stock cannot cover a change
+	ErrInsufficientStock = errors.New("insufficient stock")
+
+	// ErrReservationNotFound is returned for an unknown reservation ID
+	ErrReservationNotFound = errors.New("reservation not found")
+
+	// ErrVersionMismatch is returned when the item was changed by someone else
+	ErrVersionMismatch = errors.New("version mismatch")
+)
+
+// AnyVersion skips the version check in Update and Delete
+const AnyVersion = 0
+
+// Store represents the inventory items
+type Store interface {
+	// List returns a copy of all the items sorted by name
+	List() ([]Item, error)
+
+	// Get returns the item or ErrNotFound
+	Get(name string) (Item, error)
+
+	// Create adds a new item at version 1 or returns ErrExists
+	Create(item Item) (Item, error)
+
+	// Update applies change to the item atomically and returns the result.
+	// The change is discarded when it returns an error. Unless version is
+	// AnyVersion, it must match the current version or ErrVersionMismatch
+	// is returned.
+	Update(name string, version int64, change func(*Item) error) (Item, error)
+
+	// Delete removes an existing item or returns ErrNotFound. Unless version
+	// is AnyVersion, it must match the current version.
+	Delete(name string, version int64) error
+
+	// Watch registers fn to be called after every change with the item
+	// before and after it. The op is "create", "update" or "delete", and
+	// old is nil for create while new is nil for delete. fn is called while
+	// holding the lock of the store, so the changes are seen in order, but
+	// fn must return quickly and must not call the store.
+	Watch(fn func(op string, old, new *Item))
+
+	// Close flushes and releases the underlying resources
+	Close() error
+}
+
+// Len returns the number of items of the store, without copying them when
+// the store, or the one it wraps, allows it
+func Len(s Store) (int, error) {
+	for {
+		switch v := s.(type) {
+		case interface{ Len() int }:
+			return v.Len(), nil
+		case interface{ Unwrap() Store }:
+			s = v.Unwrap()
+		default:
+			items, err := s.List()
+			return len(items), err
+		}
+	}
+}
+
+// WriteErrors returns the number of changes the store, or the one it wraps,
+// failed to persist. It is 0 for the stores kept in memory.
+func WriteErrors(s Store) int64 {
+	for {
+		switch v := s.(type) {
+		case interface{ WriteErrors() int64 }:
+			return v.WriteErrors()
+		case interface{ Unwrap() Store }:
+			s = v.Unwrap()
+		default:
+			return 0
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package inventory
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// go test -race .
+
+// usd returns the whole dollars as Money
+func usd(dollars int64) Money {
+	return Money{Amount: dollars * 100, Currency: "USD"}
+}
+
+// stores returns the Store implementations under test
+func stores(t *testing.T) map[string]Store {
+	fs, err := OpenFileStore(filepath.Join(t.TempDir(), "inventory.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return map[string]Store{"MemStore": NewMemStore(), "FileStore": fs}
+}
+
+// TestConcurrentCreate creates the same items from many goroutines and
+// checks that every item is created exactly once
+func TestConcurrentCreate(t *testing.T) {
+	const workers, items = 8, 50
+
+	for name, s := range stores(t) {
+		var mu sync.Mutex
+		created := 0
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < items; i++ {
+					_, err := s.Create(Item{Name: fmt.Sprintf("item%d", i), Price: usd(int64(i))})
+					if err == nil {
+						mu.Lock()
+						created++
+						mu.Unlock()
+					} else if !errors.Is(err, ErrExists) {
+						t.Error(err)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		list, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if created != items || len(list) != items {
+			t.Errorf("%s: created %d and listed %d items, want %d", name, created, len(list), items)
+		}
+	}
+}
+
+// TestConcurrentReserve reserves from many goroutines and checks that the
+// stock is never oversold
+func TestConcurrentReserve(t *testing.T) {
+	const stock, orders = 10, 40
+
+	for name, s := range stores(t) {
+		s.Create(Item{Name: "shoe", Price: usd(700), Quantity: stock})
+
+		var mu sync.Mutex
+		reserved := 0
+		var wg sync.WaitGroup
+		for i := 0; i < orders; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.Update("shoe", AnyVersion, func(it *Item) error {
+					_, err := it.Reserve(1)
+					return err
+				})
+				if err == nil {
+					mu.Lock()
+					reserved++
+					mu.Unlock()
+				} else if !errors.Is(err, ErrInsufficientStock) {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		it, _ := s.Get("shoe")
+		if reserved != stock || it.Reserved != stock || it.Available() != 0 {
+			t.Errorf("%s: reserved %d, item has %d reserved and %d available",
+				name, reserved, it.Reserved, it.Available())
+		}
+	}
+}
+
+func TestReservation(t *testing.T) {
+	s := NewMemStore()
+	s.Create(Item{Name: "shoe", Price: usd(700), Quantity: 5})
+
+	var id string
+	it, err := s.Update("shoe", AnyVersion, func(it *Item) (err error) {
+		id, err = it.Reserve(3)
+		return err
+	})
+	if err != nil || it.Available() != 2 {
+		t.Fatalf("Reserve = %+v, %v", it, err)
+	}
+	if _, err := s.Update("shoe", AnyVersion, func(it *Item) error { return it.AdjustStock(-3) }); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("removing the reserved stock: %v", err)
+	}
+	it, err = s.Update("shoe", AnyVersion, func(it *Item) error { return it.Commit(id) })
+	if err != nil || it.Quantity != 2 || it.Reserved != 0 {
+		t.Errorf("Commit = %+v, %v", it, err)
+	}
+	if _, err := s.Update("shoe", AnyVersion, func(it *Item) error { return it.Release(id) }); !errors.Is(err, ErrReservationNotFound) {
+		t.Errorf("releasing a committed reservation: %v", err)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	s := NewMemStore()
+	it, _ := s.Create(Item{Name: "shoe", Price: usd(700)})
+	if it.Version != 1 {
+		t.Errorf("created version = %d, want 1", it.Version)
+	}
+
+	setPrice := func(it *Item) error { it.Price = usd(650); return nil }
+	if it, err := s.Update("shoe", 1, setPrice); err != nil || it.Version != 2 {
+		t.Errorf("Update = %+v, %v", it, err)
+	}
+	if _, err := s.Update("shoe", 1, setPrice); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Update of a stale version: %v", err)
+	}
+	if err := s.Delete("shoe", 1); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Delete of a stale version: %v", err)
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.db")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Create(Item{Name: "shoe", Price: usd(700), Tags: []string{"men"}})
+	s.Create(Item{Name: "socks", Price: usd(100)})
+	s.Create(Item{Name: "hat", Price: usd(300), Quantity: 4})
+	s.Update("shoe", AnyVersion, func(it *Item) error { it.Price = usd(600); return nil })
+	s.Delete("socks", AnyVersion)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen twice to cover the replay of a compacted log
+	for i := 0; i < 2; i++ {
+		s, err = OpenFileStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, _ := s.List()
+		var got []string
+		for _, it := range list {
+			got = append(got, fmt.Sprintf("%s %s %d %v v%d", it.Name, it.Price, it.Quantity, it.Tags, it.Version))
+		}
+		want := "[hat 300.00 USD 4 [] v1 shoe 600.00 USD 0 [men] v2]"
+		if fmt.Sprint(got) != want {
+			t.Errorf("reopen %d: got %v, want %v", i, got, want)
+		}
+		s.Close()
+	}
+}
+
+// failingFile writes half of the lines while fail is set, like a full disk
+type failingFile struct {
+	*os.File
+	fail bool
+}
+
+func (f *failingFile) Write(b []byte) (int, error) {
+	if f.fail {
+		n, _ := f.File.Write(b[:len(b)/2])
+		return n, errors.New("no space left on device")
+	}
+	return f.File.Write(b)
+}
+
+func TestFileStoreWriteError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.db")
+	s, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ff := &failingFile{File: s.f.(*os.File)}
+	s.f = ff
+	s.Create(Item{Name: "shoe", Price: usd(700)})
+	ff.fail = true
+	if _, err := s.Create(Item{Name: "hat", Price: usd(300)}); err == nil {
+		t.Fatal("Create succeeded with a failed write")
+	}
+	ff.fail = false
+	s.Create(Item{Name: "socks", Price: usd(100)})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The partial line is gone, so the store opens with the changes written
+	s, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	list, _ := s.List()
+	var got []string
+	for _, it := range list {
+		got = append(got, it.Name)
+	}
+	if fmt.Sprint(got) != "[shoe socks]" {
+		t.Errorf("reopened items = %v, want [shoe socks]", got)
+	}
+}
@@ -0,0 +1,361 @@
+package inventory
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats supported by Import and Export
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// csvHeader is the first row of the CSV files. The tags are separated by
+// ';' within a single column. Example:
+//
+//	name,sku,description,tags,price,currency,quantity
+//	shoe-model1,SH-001,Running shoe,men;sports,700.00,USD,10
+var csvHeader = []string{"name", "sku", "description", "tags", "price", "currency", "quantity"}
+
+// Record is the portable form of an item for import and export. It has only
+// the fields owned by the users, not the stock reservations or versions.
+type Record struct {
+	Name        string   `json:"name"`
+	SKU         string   `json:"sku,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	Price       Money    `json:"price"`
+	Quantity    int      `json:"quantity"`
+}
+
+// NewRecord returns the portable fields of the item
+func NewRecord(it Item) Record {
+	return Record{Name: it.Name, SKU: it.SKU, Description: it.Description,
+		Tags: it.Tags, Price: it.Price, Quantity: it.Quantity}
+}
+
+// apply copies the record into the item
+func (rec *Record) apply(it *Item) {
+	it.Name, it.SKU, it.Description = rec.Name, rec.SKU, rec.Description
+	it.Tags, it.Price, it.Quantity = rec.Tags, rec.Price, rec.Quantity
+}
+
+// RowError reports a problem in a single row of an import.
+// Row is the line number for CSV (the header is row 1) and the
+// position in the array for JSON (starting from 1).
+type RowError struct {
+	Row int    `json:"row"`
+	Err string `json:"error"`
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Err)
+}
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	Created int        `json:"created"`
+	Updated int        `json:"updated"`
+	Errors  []RowError `json:"errors,omitempty"`
+}
+
+// row is a decoded record with its position in the input
+type row struct {
+	n   int
+	rec Record
+}
+
+// Import reads the items in the given format and creates them, or replaces
+// the existing items of the same name (keeping their reservations).
+//
+// Every row is validated before anything is stored. When some rows are
+// invalid, nothing is imported unless partial is set, in which case the
+// valid rows are still imported. When the store fails on a row, such as
+// over its quota, the rows stored before are undone unless partial is set.
+// The row errors are reported in the result, with the counts of the items
+// left created and updated, while the returned error is only for an
+// unreadable input.
+func Import(s Store, format string, r io.Reader, partial bool) (ImportResult, error) {
+	var rows []row
+	var result ImportResult
+	var err error
+	switch format {
+	case FormatCSV:
+		rows, result.Errors, err = decodeCSV(r)
+	case FormatJSON:
+		rows, result.Errors, err = decodeJSON(r)
+	default:
+		return result, fmt.Errorf("unknown format: %q", format)
+	}
+	if err != nil {
+		return result, err
+	}
+	result.Errors = append(result.Errors, validateRows(rows)...)
+	sort.Slice(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+	if len(result.Errors) > 0 && !partial {
+		return result, nil
+	}
+
+	bad := make(map[int]bool)
+	for _, e := range result.Errors {
+		bad[e.Row] = true
+	}
+	var done []applied
+	for _, rw := range rows {
+		if bad[rw.n] {
+			continue
+		}
+		a, err := applyRecord(s, rw)
+		if err == nil {
+			done = append(done, a)
+			continue
+		}
+		result.Errors = append(result.Errors, RowError{Row: rw.n, Err: err.Error()})
+		if !partial {
+			var errs []RowError
+			done, errs = undo(s, done)
+			result.Errors = append(result.Errors, errs...)
+			sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+			break
+		}
+	}
+	for _, a := range done {
+		if a.old == nil {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+	}
+	return result, nil
+}
+
+// applied is a row stored by an import
+type applied struct {
+	row  int
+	item Item    // as stored
+	old  *Record // the item replaced, nil if created
+}
+
+// applyRecord replaces the item of the row, or creates it
+func applyRecord(s Store, rw row) (applied, error) {
+	rec := rw.rec
+	var old Record
+	it, err := s.Update(rec.Name, AnyVersion, func(it *Item) error {
+		old = NewRecord(*it)
+		rec.apply(it)
+		return nil
+	})
+	if err == nil {
+		return applied{row: rw.n, item: it, old: &old}, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return applied{}, err
+	}
+	var created Item
+	rec.apply(&created)
+	created, err = s.Create(created)
+	return applied{row: rw.n, item: created}, err
+}
+
+// undo reverts the rows stored, the last first, and returns those left as
+// they are. A row changed since it was stored is not reverted.
+func undo(s Store, done []applied) ([]applied, []RowError) {
+	var left []applied
+	var errs []RowError
+	for i := len(done) - 1; i >= 0; i-- {
+		a := done[i]
+		var err error
+		if a.old == nil {
+			err = s.Delete(a.item.Name, a.item.Version)
+		} else {
+			_, err = s.Update(a.item.Name, a.item.Version, func(it *Item) error {
+				a.old.apply(it)
+				return nil
+			})
+		}
+		if err != nil {
+			left = append(left, a)
+			errs = append(errs, RowError{Row: a.row, Err: "not undone: " + err.Error()})
+		}
+	}
+	return left, errs
+}
+
+// validateRows checks every record as a new item and finds the duplicates
+func validateRows(rows []row) []RowError {
+	var errs []RowError
+	seen := make(map[string]int)
+	for _, rw := range rows {
+		var it Item
+		rw.rec.apply(&it)
+		if err := it.validate(); err != nil {
+			errs = append(errs, RowError{Row: rw.n, Err: err.Error()})
+			continue
+		}
+		if first, ok := seen[it.Name]; ok {
+			errs = append(errs, RowError{Row: rw.n, Err: fmt.Sprintf("duplicate of row %d: %s", first, it.Name)})
+			continue
+		}
+		seen[it.Name] = rw.n
+	}
+	return errs
+}
+
+// decodeCSV reads the records after the header row
+func decodeCSV(r io.Reader) ([]row, []RowError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // the count is checked per row to report it as a row error
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading the CSV header: %v", err)
+	}
+	if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+		return nil, nil, fmt.Errorf("CSV header must be: %s", strings.Join(csvHeader, ","))
+	}
+
+	var rows []row
+	var errs []RowError
+	for {
+		fields, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			if errors.As(err, &perr) {
+				// The reader continues with the next line after a parse error
+				errs = append(errs, RowError{Row: perr.StartLine, Err: perr.Err.Error()})
+				continue
+			}
+			return nil, nil, err
+		}
+
+		line, _ := cr.FieldPos(0)
+		rec, err := parseCSVRecord(fields)
+		if err != nil {
+			errs = append(errs, RowError{Row: line, Err: err.Error()})
+			continue
+		}
+		rows = append(rows, row{n: line, rec: rec})
+	}
+	return rows, errs, nil
+}
+
+// parseCSVRecord converts the CSV fields to a Record
+func parseCSVRecord(fields []string) (Record, error) {
+	if len(fields) != len(csvHeader) {
+		return Record{}, fmt.Errorf("want %d fields, got %d", len(csvHeader), len(fields))
+	}
+	rec := Record{Name: fields[0], SKU: fields[1], Description: fields[2]}
+	for _, tag := range strings.Split(fields[3], ";") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			rec.Tags = append(rec.Tags, tag)
+		}
+	}
+
+	var err error
+	if rec.Price, err = ParseMoney(fields[4], fields[5]); err != nil {
+		return Record{}, err
+	}
+	if fields[6] != "" {
+		if rec.Quantity, err = strconv.Atoi(fields[6]); err != nil {
+			return Record{}, fmt.Errorf("invalid quantity: %q", fields[6])
+		}
+	}
+	return rec, nil
+}
+
+// decodeJSON reads an array of records one element at a time
+func decodeJSON(r io.Reader) ([]row, []RowError, error) {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return nil, nil, errors.New("JSON input must be an array of items")
+	}
+
+	var rows []row
+	var errs []RowError
+	for n := 1; dec.More(); n++ {
+		// A syntax error stops the decoding, but a bad element is only
+		// a row error since the decoder can continue after a RawMessage
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, nil, fmt.Errorf("row %d: %v", n, err)
+		}
+
+		var rec Record
+		rd := json.NewDecoder(bytes.NewReader(raw))
+		rd.DisallowUnknownFields()
+		if err := rd.Decode(&rec); err != nil {
+			errs = append(errs, RowError{Row: n, Err: err.Error()})
+			continue
+		}
+		rows = append(rows, row{n: n, rec: rec})
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, err
+	}
+	return rows, errs, nil
+}
+
+// Export writes the items in the given format. Each item is written as soon
+// as it is encoded, so the output is streamed rather than built in memory.
+func Export(w io.Writer, format string, items []Item) error {
+	switch format {
+	case FormatCSV:
+		return exportCSV(w, items)
+	case FormatJSON:
+		return exportJSON(w, items)
+	}
+	return fmt.Errorf("unknown format: %q", format)
+}
+
+// exportCSV writes the header and a row per item
+func exportCSV(w io.Writer, items []Item) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, it := range items {
+		err := cw.Write([]string{it.Name, it.SKU, it.Description, strings.Join(it.Tags, ";"),
+			it.Price.Decimal(), it.Price.Currency, strconv.Itoa(it.Quantity)})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportJSON writes a JSON array with a record per line
+func exportJSON(w io.Writer, items []Item) error {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	for i, it := range items {
+		sep := ",\n"
+		if i == 0 {
+			sep = "\n"
+		}
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
+		}
+		b, err := json.Marshal(NewRecord(it))
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\n]\n")
+	return err
+}
@@ -0,0 +1,112 @@
+package inventory
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	const input = `name,sku,description,tags,price,currency,quantity
+shoe-model1,SH-1,"Running shoe, blue",men;sports,700.00,USD,10
+socks-type1,,,,1.999,USD,5
+hat,,,,3,XXX,
+scarf,,,,20,EUR,abc
+shoe-model1,,,,1,USD,1
+too,few
+`
+	s := NewMemStore()
+	result, err := Import(s, FormatCSV, strings.NewReader(input), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []int
+	for _, e := range result.Errors {
+		rows = append(rows, e.Row)
+	}
+	if fmt.Sprint(rows) != "[3 4 5 6 7]" {
+		t.Errorf("row errors = %v", result.Errors)
+	}
+	if items, _ := s.List(); len(items) != 0 || result.Created != 0 {
+		t.Errorf("imported %d items despite the errors", len(items))
+	}
+
+	// The valid rows are imported with partial
+	result, _ = Import(s, FormatCSV, strings.NewReader(input), true)
+	it, err := s.Get("shoe-model1")
+	if result.Created != 1 || err != nil || it.Description != "Running shoe, blue" ||
+		fmt.Sprint(it.Tags) != "[men sports]" || it.Quantity != 10 {
+		t.Errorf("partial import = %+v, item = %+v", result, it)
+	}
+}
+
+func TestImportJSON(t *testing.T) {
+	const input = `[
+	{"name":"shoe","price":{"amount":"7.00","currency":"USD"},"quantity":3},
+	{"name":"hat","price":"3 EUR","color":"red"},
+	{"name":"cap","price":12}
+]`
+	result, err := Import(NewMemStore(), FormatJSON, strings.NewReader(input), true)
+	if err != nil || result.Created != 2 || len(result.Errors) != 1 || result.Errors[0].Row != 2 {
+		t.Errorf("Import = %+v, %v", result, err)
+	}
+
+	if _, err := Import(NewMemStore(), FormatJSON, strings.NewReader(`[{"name":`), true); err == nil {
+		t.Error("Import of broken JSON did not fail")
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	src := NewMemStore()
+	src.Create(Item{Name: "shoe", SKU: "S-1", Tags: []string{"a", "b"}, Price: usd(700), Quantity: 2})
+	src.Create(Item{Name: "hat", Description: `wool, "warm"`, Price: Money{Amount: 350, Currency: "EUR"}})
+	items, _ := src.List()
+
+	for _, format := range []string{FormatCSV, FormatJSON} {
+		var buf bytes.Buffer
+		if err := Export(&buf, format, items); err != nil {
+			t.Fatal(err)
+		}
+		dst := NewMemStore()
+		result, err := Import(dst, format, &buf, false)
+		if err != nil || result.Created != 2 {
+			t.Fatalf("%s: Import = %+v, %v", format, result, err)
+		}
+		got, _ := dst.List()
+		for i := range got {
+			if fmt.Sprint(NewRecord(got[i])) != fmt.Sprint(NewRecord(items[i])) {
+				t.Errorf("%s: got %+v, want %+v", format, NewRecord(got[i]), NewRecord(items[i]))
+			}
+		}
+	}
+}
+
+func TestImportUndo(t *testing.T) {
+	const input = `name,sku,description,tags,price,currency,quantity
+shoe,,,,7,USD,1
+hat,,,,3,USD,2
+scarf,,,,20,USD,3
+`
+	s := WithQuota(NewMemStore(), 2)
+	s.Create(Item{Name: "hat", Description: "old", Price: usd(100), Quantity: 9})
+
+	// The scarf is over the quota, so the shoe and the hat are undone
+	result, err := Import(s, FormatCSV, strings.NewReader(input), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Created != 0 || result.Updated != 0 || len(result.Errors) != 1 || result.Errors[0].Row != 4 {
+		t.Errorf("Import = %+v, want only the scarf failed", result)
+	}
+	items, _ := s.List()
+	if len(items) != 1 || items[0].Description != "old" || items[0].Quantity != 9 {
+		t.Errorf("items after the undo = %+v, want the old hat only", items)
+	}
+
+	// The rows stored are kept with partial
+	result, _ = Import(s, FormatCSV, strings.NewReader(input), true)
+	if result.Created != 1 || result.Updated != 1 || len(result.Errors) != 1 {
+		t.Errorf("partial Import = %+v, want 1 created, 1 updated and 1 error", result)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the request latency histogram in seconds
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// requestKey identifies a series of the request metrics
+type requestKey struct {
+	handler string
+	code    int
+}
+
+// requestStats is a latency histogram of the requests
+type requestStats struct {
+	buckets []uint64 // count per bucket, not cumulative
+	count   uint64
+	sum     float64
+}
+
+// metrics collects the server metrics and serves them in the Prometheus
+// text exposition format, without depending on the Prometheus client
+type metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]*requestStats
+
+	// tenants are read for the item counts once the storage is loaded
+	tenants atomic.Value // *tenants
+}
+
+// newMetrics creates an empty collector
+func newMetrics() *metrics {
+	return &metrics{requests: make(map[requestKey]*requestStats)}
+}
+
+// instrument counts the requests of the handler and their latency per
+// status code. The handler name is a label, so it must be a constant.
+func (m *metrics) instrument(handler string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		m.observe(handler, rec.status, time.Since(start).Seconds())
+	}
+}
+
+// observe records a single request
+func (m *metrics) observe(handler string, code int, seconds float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := requestKey{handler, code}
+	st, ok := m.requests[key]
+	if !ok {
+		st = &requestStats{buckets: make([]uint64, len(latencyBuckets))}
+		m.requests[key] = st
+	}
+	st.count++
+	st.sum += seconds
+	if i := sort.SearchFloat64s(latencyBuckets, seconds); i < len(latencyBuckets) {
+		st.buckets[i]++
+	}
+}
+
+// ServeHTTP writes the metrics
+//
+//	curl "http://localhost:8080/metrics"
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.write(w)
+}
+
+// write formats all the metrics in a stable order
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].handler != keys[j].handler {
+			return keys[i].handler < keys[j].handler
+		}
+		return keys[i].code < keys[j].code
+	})
+	stats := make([]requestStats, len(keys))
+	for i, k := range keys {
+		st := m.requests[k]
+		stats[i] = requestStats{buckets: append([]uint64(nil), st.buckets...), count: st.count, sum: st.sum}
+	}
+	m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP inventory_http_requests_total Number of HTTP requests by handler and status code.")
+	fmt.Fprintln(w, "# TYPE inventory_http_requests_total counter")
+	for i, k := range keys {
+		fmt.Fprintf(w, "inventory_http_requests_total{%s} %d\n", k.labels(), stats[i].count)
+	}
+
+	fmt.Fprintln(w, "# HELP inventory_http_request_duration_seconds Latency of HTTP requests by handler and status code.")
+	fmt.Fprintln(w, "# TYPE inventory_http_request_duration_seconds histogram")
+	for i, k := range keys {
+		var cumulative uint64
+		for b, le := range latencyBuckets {
+			cumulative += stats[i].buckets[b]
+			fmt.Fprintf(w, "inventory_http_request_duration_seconds_bucket{%s,le=%q} %d\n",
+				k.labels(), strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "inventory_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", k.labels(), stats[i].count)
+		fmt.Fprintf(w, "inventory_http_request_duration_seconds_sum{%s} %g\n", k.labels(), stats[i].sum)
+		fmt.Fprintf(w, "inventory_http_request_duration_seconds_count{%s} %d\n", k.labels(), stats[i].count)
+	}
+
+	ts, _ := m.tenants.Load().(*tenants)
+	if ts == nil {
+		return
+	}
+	storage := ts.storageStats()
+	fmt.Fprintln(w, "# HELP inventory_items Number of items in the inventory by tenant.")
+	fmt.Fprintln(w, "# TYPE inventory_items gauge")
+	for _, st := range storage {
+		if st.items >= 0 {
+			fmt.Fprintf(w, "inventory_items{tenant=%q} %d\n", escapeLabel(st.tenant), st.items)
+		}
+	}
+	fmt.Fprintln(w, "# HELP inventory_storage_write_errors_total Number of changes lost by failed storage writes by tenant.")
+	fmt.Fprintln(w, "# TYPE inventory_storage_write_errors_total counter")
+	for _, st := range storage {
+		fmt.Fprintf(w, "inventory_storage_write_errors_total{tenant=%q} %d\n", escapeLabel(st.tenant), st.writeErrors)
+	}
+	fmt.Fprintln(w, "# HELP inventory_audit_write_errors_total Number of changes missing from the audit log file by tenant.")
+	fmt.Fprintln(w, "# TYPE inventory_audit_write_errors_total counter")
+	for _, st := range storage {
+		if st.audited {
+			fmt.Fprintf(w, "inventory_audit_write_errors_total{tenant=%q} %d\n", escapeLabel(st.tenant), st.auditErrors)
+		}
+	}
+}
+
+// labels formats the labels of the series
+func (k requestKey) labels() string {
+	return fmt.Sprintf("handler=%q,code=\"%d\"", escapeLabel(k.handler), k.code)
+}
+
+// escapeLabel escapes the characters not allowed in the label values
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// health reports the liveness and the readiness of the server
+type health struct {
+	mu    sync.Mutex
+	ready bool
+	err   error // why the server is not ready
+}
+
+// setReady marks the server ready to serve, or not ready with the reason
+func (h *health) setReady(ready bool, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ready, h.err = ready, err
+}
+
+// healthz reports that the process is alive
+func (h *health) healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyz reports whether the storage is loaded and the server accepts requests
+func (h *health) readyz(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	ready, err := h.ready, h.err
+	h.mu.Unlock()
+
+	if ready {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+		return
+	}
+	reason := "starting"
+	if err != nil {
+		reason = err.Error()
+	}
+	writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready", "reason": reason})
+}
+
+// gate serves the handler set once the server is ready, and 503 until then
+type gate struct {
+	h atomic.Value // http.Handler
+}
+
+func (g *gate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, _ := g.h.Load().(http.Handler)
+	if h == nil {
+		w.Header().Set("Retry-After", "1")
+		jsonError(w, http.StatusServiceUnavailable, "server is not ready")
+		return
+	}
+	h.ServeHTTP(w, r)
+}
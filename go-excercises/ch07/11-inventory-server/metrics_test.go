@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+func TestMetrics(t *testing.T) {
+	db := &database{store: inventory.NewMemStore(), audit: inventory.NewAuditLog()}
+	m := newMetrics()
+	ts, err := newTenants("", db, &authorizer{}, m, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme, _, err := ts.create("acme", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme.db.store.Create(inventory.Item{Name: "cap", Price: inventory.Money{Amount: 100, Currency: "USD"}})
+	acme.db.store.Create(inventory.Item{Name: "scarf", Price: inventory.Money{Amount: 200, Currency: "USD"}})
+	m.tenants.Store(ts)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items", m.instrument("items", db.items))
+	mux.Handle("/metrics", m)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	do(t, "POST", srv.URL+"/items", `{"name":"hat","price":3}`)
+	do(t, "POST", srv.URL+"/items", `{"name":"hat","price":3}`)
+	do(t, "GET", srv.URL+"/items", "")
+
+	_, body := do(t, "GET", srv.URL+"/metrics", "")
+	for _, want := range []string{
+		`inventory_http_requests_total{handler="items",code="200"} 1`,
+		`inventory_http_requests_total{handler="items",code="201"} 1`,
+		`inventory_http_requests_total{handler="items",code="409"} 1`,
+		`inventory_http_request_duration_seconds_bucket{handler="items",code="201",le="+Inf"} 1`,
+		`inventory_http_request_duration_seconds_count{handler="items",code="409"} 1`,
+		`inventory_items{tenant="acme"} 2`,
+		`inventory_items{tenant="default"} 1`,
+		`inventory_storage_write_errors_total{tenant="acme"} 0`,
+		`inventory_storage_write_errors_total{tenant="default"} 0`,
+		`inventory_audit_write_errors_total{tenant="default"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %s\n%s", want, body)
+		}
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	hc := &health{}
+	app := &gate{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/readyz", hc.readyz)
+	mux.Handle("/", app)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	if resp, body := do(t, "GET", ts.URL+"/readyz", ""); resp.StatusCode != 503 || !strings.Contains(body, "starting") {
+		t.Errorf("readyz before loading = %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := do(t, "GET", ts.URL+"/items", ""); resp.StatusCode != 503 || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("items before loading = %d, want 503 with Retry-After", resp.StatusCode)
+	}
+
+	hc.setReady(false, errors.New("loading the storage: corrupted"))
+	if _, body := do(t, "GET", ts.URL+"/readyz", ""); !strings.Contains(body, "corrupted") {
+		t.Errorf("readyz on failure = %s, want the reason", body)
+	}
+
+	app.h.Store(http.Handler(http.NotFoundHandler()))
+	hc.setReady(true, nil)
+	if resp, _ := do(t, "GET", ts.URL+"/readyz", ""); resp.StatusCode != 200 {
+		t.Errorf("readyz when loaded = %d, want 200", resp.StatusCode)
+	}
+	if resp, _ := do(t, "GET", ts.URL+"/items", ""); resp.StatusCode != 404 {
+		t.Errorf("items when loaded = %d, want the app handler", resp.StatusCode)
+	}
+}
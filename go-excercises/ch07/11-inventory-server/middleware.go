@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// writeTimeout is the http.Server.WriteTimeout, which the streaming
+// responses extend after every write (see streamWriter)
+var writeTimeout = 30 * time.Second
+
+// accessLogger writes the access log as JSON lines on stdout
+var accessLogger = log.New(os.Stdout, "", 0)
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// requestIDFrom returns the ID of the request, or "" outside withRequestID
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether an ID from the client is safe to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID assigns an ID to every request, or keeps the X-Request-ID
+// set by a proxy, and returns it in the X-Request-ID response header
+func withRequestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// statusRecorder captures the status code and the size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets the event streams flush through the recorder
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// accessEntry is a line of the access log
+type accessEntry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Remote    string    `json:"remote"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	LatencyMS float64   `json:"latency_ms"`
+	Bytes     int64     `json:"bytes"`
+}
+
+// withAccessLog logs every request as a JSON line once it completes
+func withAccessLog(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK // nothing was written
+		}
+
+		b, err := json.Marshal(accessEntry{
+			Time:      start.UTC(),
+			RequestID: requestIDFrom(r.Context()),
+			Remote:    r.RemoteAddr,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    rec.status,
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			Bytes:     rec.bytes,
+		})
+		if err == nil {
+			accessLogger.Print(string(b))
+		}
+	})
+}
+
+// connKey is the context key of the client connection, see saveConn
+type connKey struct{}
+
+// saveConn keeps the connection in the request context, so the streaming
+// handlers can extend the write deadline set by the server WriteTimeout.
+// It is the http.Server.ConnContext.
+func saveConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// deadlineWriter extends the write deadline of the connection before
+// every write, so a long response only times out when the client stalls
+type deadlineWriter struct {
+	w       io.Writer
+	conn    net.Conn
+	timeout time.Duration
+}
+
+// streamWriter returns a writer for the long responses like the event
+// streams and the exports. Without it, the server WriteTimeout would cut
+// them off in the middle.
+func streamWriter(w http.ResponseWriter, r *http.Request) io.Writer {
+	conn, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok || writeTimeout == 0 {
+		return w
+	}
+	return &deadlineWriter{w: w, conn: conn, timeout: writeTimeout}
+}
+
+func (d *deadlineWriter) Write(b []byte) (int, error) {
+	d.conn.SetWriteDeadline(time.Now().Add(d.timeout))
+	return d.w.Write(b)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+// maxPageSize limits the items in a single page
+const maxPageSize = 1000
+
+// parseQuery reads the search parameters of the list endpoints
+//
+//	prefix=shoe                      name starts with "shoe"
+//	q=model                          name contains "model", ignoring the case
+//	min_price=10&max_price=99.99     price range in the default currency
+//	currency=EUR                     currency of the price range
+//	sort=name|price&order=asc|desc   sort order, by name by default
+//	limit=50&cursor=...              page size and the position of the page
+func parseQuery(r *http.Request) (inventory.Query, error) {
+	v := r.URL.Query()
+	q := inventory.Query{
+		Prefix:   v.Get("prefix"),
+		Contains: v.Get("q"),
+		SortBy:   v.Get("sort"),
+		Cursor:   v.Get("cursor"),
+	}
+
+	for _, p := range []struct {
+		param string
+		price **inventory.Money
+	}{{"min_price", &q.MinPrice}, {"max_price", &q.MaxPrice}} {
+		if s := v.Get(p.param); s != "" {
+			m, err := inventory.ParseMoney(s, v.Get("currency"))
+			if err != nil {
+				return q, fmt.Errorf("%w: %s: %v", inventory.ErrInvalidQuery, p.param, err)
+			}
+			*p.price = &m
+		}
+	}
+
+	switch order := v.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		return q, fmt.Errorf("%w: unknown order %q", inventory.ErrInvalidQuery, order)
+	}
+
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxPageSize {
+			return q, fmt.Errorf("%w: limit must be 1 to %d", inventory.ErrInvalidQuery, maxPageSize)
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// search runs the query of the request against the store
+func (d *database) search(r *http.Request) (inventory.Page, error) {
+	q, err := parseQuery(r)
+	if err != nil {
+		return inventory.Page{}, err
+	}
+	items, err := d.store.List()
+	if err != nil {
+		return inventory.Page{}, err
+	}
+	return inventory.Search(items, q)
+}
+
+// setNextLink points to the following page with a Link header, so the
+// response body keeps the same shape with or without the pagination
+func setNextLink(w http.ResponseWriter, r *http.Request, page inventory.Page) {
+	if page.NextCursor == "" {
+		return
+	}
+	u := *r.URL
+	v := u.Query()
+	v.Set("cursor", page.NextCursor)
+	u.RawQuery = v.Encode()
+	w.Header().Set("Link", fmt.Sprintf("<%s%s>; rel=\"next\"", basePath(r), u.RequestURI()))
+}
@@ -3,42 +3,229 @@ server manages the inventory items
 Start the server and run the following commands in another terminal to access the API
 
 	curl "http://localhost:8080/create?item=shoe-model2&price=800"
+	curl "http://localhost:8080/create?item=socks-type2&price=4.99&currency=EUR"
 	curl "http://localhost:8080/list"
 	curl "http://localhost:8080/price?item=socks-type1"
 	curl "http://localhost:8080/update?item=shoe-model1&price=600"
 	curl "http://localhost:8080/list"
 	curl "http://localhost:8080/delete?item=shoe-model1"
 	curl "http://localhost:8080/list"
+
+The lists can be searched, sorted and paginated (see parseQuery):
+
+	curl "http://localhost:8080/list?q=model&max_price=500&sort=price&order=desc"
+	curl -i "http://localhost:8080/items?prefix=shoe&limit=20"
+
+The same items are available as JSON resources:
+
+	curl "http://localhost:8080/items"
+	curl -X POST -d '{"name":"hat-model1","price":300}' "http://localhost:8080/items"
+	curl "http://localhost:8080/items/hat-model1"
+	curl -X PUT -d '{"price":{"amount":"349.99","currency":"USD"}}' "http://localhost:8080/items/hat-model1"
+	curl -X PATCH -d '{"price":320}' "http://localhost:8080/items/hat-model1"
+	curl -X DELETE "http://localhost:8080/items/hat-model1"
+
+Go programs can use the inventory/client package instead, and the invctl
+command wraps it for the shell:
+
+	invctl create -quantity 5 hat-model1 300
+	invctl list -prefix hat
+
+Items carry a stock quantity that can be adjusted, or reserved for an order
+and later released or committed. Every response has an ETag with the item
+version, and the changes accept If-Match for optimistic concurrency:
+
+	curl -X POST -d '{"delta":5}' "http://localhost:8080/items/shoe-model1/stock"
+	curl -X POST -d '{"quantity":2}' "http://localhost:8080/items/shoe-model1/reservations"
+	curl -X POST "http://localhost:8080/items/shoe-model1/reservations/{id}/commit"
+	curl -X DELETE "http://localhost:8080/items/shoe-model1/reservations/{id}"
+	curl -X PATCH -H 'If-Match: "3"' -d '{"tags":["men"]}' "http://localhost:8080/items/shoe-model1"
+
+Prices are fixed-point amounts with a currency. The currency defaults to USD
+(see -currency) when a price is given as a bare number.
+
+By default, the items are kept in memory. Use -data to persist them in a file:
+
+	./server -data inventory.db
+
+The items can be imported from a CSV or JSON file at startup or through
+/import, and exported through /export (see importItems and exportItems):
+
+	./server -import items.csv
+	curl "http://localhost:8080/export?format=csv"
+
+The access can be restricted with API keys and HTTP Basic users having the
+roles reader, editor or admin. The readers can only list and read the items,
+while the editors and admins can also change them:
+
+	./server -api-keys keys.txt -users users.txt
+	curl -H "X-API-Key: 3f1c9a0e77d24b6b" "http://localhost:8080/list"
+	curl -u alice "http://localhost:8080/delete?item=shoe-model1"
+
+Given -audit, every change is recorded in an audit log file with the actor,
+and the old and new item. An item can be rolled back to the fields of one
+of its recent versions:
+
+	./server -audit audit.log
+	curl "http://localhost:8080/items/shoe-model1/history"
+	curl -X POST -d '{"version":2}' "http://localhost:8080/items/shoe-model1/rollback"
+
+The changes are pushed as Server-Sent Events, which can be resumed with
+Last-Event-ID and filtered by a prefix of the item names (see events):
+
+	curl -N "http://localhost:8080/events?prefix=shoe"
+
+The items can also be edited in a browser at http://localhost:8080/admin.
+With -users, the browser asks for the user name and password (see admin).
+
+Several inventories can be served as tenants, each with its own items, API
+keys and item quota. A global admin creates them, and the editor and reader
+keys of a new tenant are only shown in the response. A tenant is selected by
+the path prefix /t/{name} or the X-Tenant header, and only accepts its own
+keys or a global admin (see tenants):
+
+	./server -api-keys keys.txt -tenants tenants/ -tenant-quota 5000
+	curl -H "X-API-Key: $ADMIN" -d '{"name":"acme","quota":1000}' "http://localhost:8080/tenants"
+	curl -H "X-API-Key: $ACME" "http://localhost:8080/t/acme/items"
+	curl -H "X-API-Key: $ACME" -H "X-Tenant: acme" "http://localhost:8080/items"
+	curl -H "X-API-Key: $ADMIN" -X DELETE "http://localhost:8080/tenants/acme"
+
+The same items and changes are served over gRPC with -grpc-addr (see
+proto/inventory.proto). The credentials go in the "x-api-key" metadata:
+
+	./server -grpc-addr localhost:50051
+	grpcurl -plaintext -d '{"prefix":"shoe"}' localhost:50051 inventory.Inventory/ListItems
+	grpcurl -plaintext localhost:50051 inventory.Inventory/WatchItems
+
+The stubs are generated as in go-advanced/grpc/hello:
+
+	protoc --go_out=. --go_opt=paths=source_relative \
+	       --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+	       proto/inventory.proto
+
+The server logs every request as a JSON line on stdout, and drains the
+requests in progress before exiting on SIGINT or SIGTERM. The health and
+metrics are served in the Prometheus text format, and /readyz turns ready
+once the storage is loaded:
+
+	curl "http://localhost:8080/metrics"
+	curl "http://localhost:8080/healthz"
+	curl "http://localhost:8080/readyz"
+
+The inventory endpoints limit abusive clients (see httplimit): each client IP
+and each authenticated API key or user has a request rate, the requests served
+at once are capped, and so are the query and body sizes. Over a limit, the
+server answers 429 with a Retry-After header. The health and metrics endpoints
+are not limited:
+
+	./server -rate 20 -burst 40 -key-rate 50 -key-burst 100 -max-concurrent 512
 */
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
-	"strconv"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+	"github.com/rajkumar-km/go-play/go-excercises/httplimit"
+	"google.golang.org/grpc"
 )
 
-// database represents a map of item name and its price
-type database map[string]int
+// database serves the inventory items from a concurrency-safe store
+type database struct {
+	store  inventory.Store
+	audit  *inventory.AuditLog // optional
+	broker *inventory.Broker
+
+	// closing is closed when the server shuts down, to end the event streams
+	closing chan struct{}
+}
+
+// storeFor returns the store to change the items on behalf of the client
+// of the request, so the changes are recorded in the audit log
+func (d *database) storeFor(r *http.Request) inventory.Store {
+	return d.storeAs(r.Context())
+}
 
-// list lists the items in inventory with price
+// storeAs returns the store recording the changes as the principal of the context
+func (d *database) storeAs(ctx context.Context) inventory.Store {
+	if d.audit == nil {
+		return d.store
+	}
+	actor := "anonymous"
+	if p := principalFrom(ctx); p != nil {
+		actor = p.Name
+	}
+	return d.audit.Store(d.store, actor)
+}
+
+// storeStatus maps the error returned by the store to a HTTP status code and message
+func storeStatus(err error, item string) (int, string) {
+	switch {
+	case errors.Is(err, inventory.ErrNotFound):
+		return http.StatusNotFound, "item not found: " + item
+	case errors.Is(err, inventory.ErrExists):
+		return http.StatusConflict, "item already exists: " + item
+	case errors.Is(err, inventory.ErrInvalid), errors.Is(err, inventory.ErrCurrencyMismatch),
+		errors.Is(err, inventory.ErrInvalidQuery):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, inventory.ErrInsufficientStock):
+		return http.StatusConflict, err.Error()
+	case errors.Is(err, inventory.ErrReservationNotFound), errors.Is(err, inventory.ErrVersionNotFound):
+		return http.StatusNotFound, err.Error()
+	case errors.Is(err, inventory.ErrVersionMismatch):
+		return http.StatusPreconditionFailed, "item was changed, fetch it again: " + item
+	case errors.Is(err, inventory.ErrQuotaExceeded):
+		return http.StatusForbidden, err.Error()
+	default:
+		log.Printf("store error: %v", err)
+		return http.StatusInternalServerError, "internal error"
+	}
+}
+
+// storeError reports the error returned by the store as plain text
+func storeError(w http.ResponseWriter, err error, item string) {
+	status, msg := storeStatus(err, item)
+	http.Error(w, msg, status)
+}
+
+// list lists the items in inventory with price. It accepts the search
+// parameters of parseQuery, and the Link header points to the next page.
 func (d *database) list(w http.ResponseWriter, r *http.Request) {
-	for item, price := range *d {
-		fmt.Fprintf(w, "%q, %d\n", item, price)
+	page, err := d.search(r)
+	if err != nil {
+		storeError(w, err, "")
+		return
+	}
+	setNextLink(w, r, page)
+	for _, it := range page.Items {
+		fmt.Fprintf(w, "%q, %s\n", it.Name, it.Price)
 	}
 }
 
 // price returns the price for the given item
 func (d *database) price(w http.ResponseWriter, r *http.Request) {
 	item := r.URL.Query().Get("item")
-	price, ok := (*d)[item]
-	if !ok {
+	it, err := d.store.Get(item)
+	if errors.Is(err, inventory.ErrNotFound) {
 		http.Error(w, "no such item: "+item, http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		storeError(w, err, item)
+		return
+	}
 
-	fmt.Fprintf(w, "%q, %d\n", item, price)
+	fmt.Fprintf(w, "%q, %s\n", item, it.Price)
 }
 
 // create allows to add a new item in inventory
@@ -54,19 +241,17 @@ func (d *database) create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	item, priceStr := q.Get("item"), q.Get("price")
-	_, ok := (*d)[item]
-	if ok {
-		http.Error(w, "item already exists: "+item, http.StatusConflict)
+	price, err := inventory.ParseMoney(priceStr, q.Get("currency"))
+	if err != nil {
+		http.Error(w, "invalid price: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	price, err := strconv.Atoi(priceStr)
-	if err != nil {
-		http.Error(w, "invalid price: "+priceStr, http.StatusBadRequest)
+	// The store checks the existence and adds the item atomically
+	if _, err := d.storeFor(r).Create(inventory.Item{Name: item, Price: price}); err != nil {
+		storeError(w, err, item)
 		return
 	}
-
-	(*d)[item] = price
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -83,19 +268,20 @@ func (d *database) update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	item, priceStr := q.Get("item"), q.Get("price")
-	_, ok := (*d)[item]
-	if !ok {
-		http.Error(w, "item not found: "+item, http.StatusNotFound)
+	price, err := inventory.ParseMoney(priceStr, q.Get("currency"))
+	if err != nil {
+		http.Error(w, "invalid price: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	price, err := strconv.Atoi(priceStr)
+	_, err = d.storeFor(r).Update(item, inventory.AnyVersion, func(it *inventory.Item) error {
+		it.Price = price
+		return nil
+	})
 	if err != nil {
-		http.Error(w, "invalid price: "+priceStr, http.StatusBadRequest)
+		storeError(w, err, item)
 		return
 	}
-
-	(*d)[item] = price
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -108,32 +294,279 @@ func (d *database) delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	item := q.Get("item")
-	_, ok := (*d)[item]
-	if !ok {
-		http.Error(w, "item not found: "+item, http.StatusNotFound)
+	if err := d.storeFor(r).Delete(item, inventory.AnyVersion); err != nil {
+		storeError(w, err, item)
 		return
 	}
-
-	delete(*d, item)
 	w.WriteHeader(http.StatusOK)
 }
 
+// initialItems are created in a new store, unless items are imported
+var initialItems = []inventory.Item{
+	{Name: "shoe-model1", Price: inventory.Money{Amount: 700_00, Currency: "USD"}, Quantity: 10},
+	{Name: "socks-type1", Price: inventory.Money{Amount: 100_00, Currency: "USD"}, Quantity: 50},
+}
+
+// openStorage opens the store and the audit log, and loads the initial items
+// into a new store
+func openStorage(dataFile, auditFile, importPath string) (inventory.Store, *inventory.AuditLog, error) {
+	// Only a new store gets the initial items, never one emptied on purpose
+	fresh := dataFile == ""
+	var store inventory.Store = inventory.NewMemStore()
+	if dataFile != "" {
+		if _, err := os.Stat(dataFile); errors.Is(err, os.ErrNotExist) {
+			fresh = true
+		}
+		fs, err := inventory.OpenFileStore(dataFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		store = fs
+	}
+
+	if importPath != "" {
+		if err := importFile(store, importPath); err != nil {
+			store.Close()
+			return nil, nil, err
+		}
+	} else if fresh {
+		for _, it := range initialItems {
+			if _, err := store.Create(it); err != nil {
+				store.Close()
+				return nil, nil, fmt.Errorf("creating the initial items: %v", err)
+			}
+		}
+	}
+
+	var audit *inventory.AuditLog // disabled without a file
+	if auditFile != "" {
+		var err error
+		if audit, err = inventory.OpenAuditLog(auditFile); err != nil {
+			store.Close()
+			return nil, nil, err
+		}
+	}
+	return store, audit, nil
+}
+
+// routes registers the handlers of the inventory with their roles and metrics
+func routes(db *database, auth *authorizer, m *metrics) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/list", m.instrument("list", auth.require(RoleReader, db.list)))
+	mux.HandleFunc("/price", m.instrument("price", auth.require(RoleReader, db.price)))
+	mux.HandleFunc("/create", m.instrument("create", auth.require(RoleEditor, db.create)))
+	mux.HandleFunc("/update", m.instrument("update", auth.require(RoleEditor, db.update)))
+	mux.HandleFunc("/delete", m.instrument("delete", auth.require(RoleEditor, db.delete)))
+	mux.HandleFunc("/items", m.instrument("items", auth.requireByMethod(db.items)))
+	mux.HandleFunc("/items/", m.instrument("item", auth.requireByMethod(db.item)))
+	mux.HandleFunc("/import", m.instrument("import", auth.require(RoleEditor, db.importItems)))
+	mux.HandleFunc("/export", m.instrument("export", auth.require(RoleReader, db.exportItems)))
+	mux.HandleFunc("/events", m.instrument("events", auth.require(RoleReader, db.events)))
+	mux.HandleFunc("/admin", m.instrument("admin", auth.requireByMethod(db.admin)))
+	mux.HandleFunc("/admin/", m.instrument("admin", auth.requireByMethod(db.admin)))
+	return mux
+}
+
 func main() {
-	// Initial inventory
-	db := database{
-		"shoe-model1": 700,
-		"socks-type1": 100,
+	addr := flag.String("addr", "localhost:8080", "address to listen on")
+	dataFile := flag.String("data", "", "file to persist the inventory (default in-memory)")
+	currency := flag.String("currency", inventory.DefaultCurrency, "currency for prices given without one")
+	importPath := flag.String("import", "", "CSV or JSON file of items to import at startup")
+	apiKeysFile := flag.String("api-keys", "", "file of API keys with roles (see LoadAPIKeys)")
+	usersFile := flag.String("users", "", "file of HTTP Basic users with roles (see LoadBasicUsers)")
+	auditFile := flag.String("audit", "", "file to keep the audit log of the changes (default disabled)")
+	tenantsDir := flag.String("tenants", "", "directory to persist the tenants and their items (default in-memory)")
+	tenantQuota := flag.Int("tenant-quota", 10000, "maximum items of a new tenant, unless given on creation")
+	eventsBuffer := flag.Int("events-buffer", 1024, "number of recent events kept to resume the event streams")
+	readTimeout := flag.Duration("read-timeout", 10*time.Second, "maximum time to read a request")
+	flag.DurationVar(&writeTimeout, "write-timeout", writeTimeout, "maximum time to write a response, or between the writes of a stream")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "maximum time to keep an idle connection")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 15*time.Second, "maximum time to drain the requests on shutdown")
+	rate := flag.Float64("rate", 20, "requests per second of a client IP (0 for no limit)")
+	burst := flag.Int("burst", 40, "requests a client IP can make at once")
+	keyRate := flag.Float64("key-rate", 50, "requests per second of an authenticated API key or user (0 for no limit)")
+	keyBurst := flag.Int("key-burst", 100, "requests an authenticated API key or user can make at once")
+	maxConcurrent := flag.Int("max-concurrent", 512, "requests served at once, including the event streams (0 for no limit)")
+	maxQuery := flag.Int("max-query", 8<<10, "maximum size of a query string in bytes")
+	grpcAddr := flag.String("grpc-addr", "", "address to serve the gRPC API on (default disabled)")
+	hashPass := flag.Bool("hash-password", false, "read a password from stdin and print the bcrypt hash")
+	flag.Parse()
+
+	if *hashPass {
+		if err := hashPassword(); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	// Register http handlers
-	http.HandleFunc("/list", db.list)
-	http.HandleFunc("/price", db.price)
-	http.HandleFunc("/create", db.create)
-	http.HandleFunc("/update", db.update)
-	http.HandleFunc("/delete", db.delete)
+	if !inventory.ValidCurrency(*currency) {
+		log.Fatalf("unknown currency: %s", *currency)
+	}
+	inventory.DefaultCurrency = *currency
+
+	// Set up the authentication
+	auth := &authorizer{}
+	if *apiKeysFile != "" {
+		keys, err := LoadAPIKeys(*apiKeysFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		auth.authenticators = append(auth.authenticators, keys)
+	}
+	if *usersFile != "" {
+		users, err := LoadBasicUsers(*usersFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		auth.authenticators = append(auth.authenticators, users)
+	}
+	if len(auth.authenticators) == 0 {
+		log.Print("WARNING: authentication is disabled, use -api-keys or -users")
+	}
+
+	// The health and metrics endpoints are served from the start, while
+	// the inventory is served once the storage is loaded
+	m := newMetrics()
+	hc := &health{}
+	app := &gate{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", hc.healthz)
+	mux.HandleFunc("/readyz", hc.readyz)
+	mux.Handle("/metrics", m)
+
+	reject := func(w http.ResponseWriter, r *http.Request, status int, msg string) {
+		jsonError(w, status, msg)
+	}
+	limiter := httplimit.New(httplimit.Config{
+		IPRate:        *rate,
+		IPBurst:       *burst,
+		MaxConcurrent: *maxConcurrent,
+		MaxBodyBytes:  maxImportSize, // the largest of the endpoints, which limit their own
+		MaxQueryBytes: *maxQuery,
+		Reject:        reject,
+	})
+	// The keys are limited once authenticated, so that the made-up ones
+	// do not get a bucket each
+	if *keyRate > 0 {
+		auth.limiter = httplimit.New(httplimit.Config{
+			KeyRate:  *keyRate,
+			KeyBurst: *keyBurst,
+			KeyFunc:  principalLimit,
+			Reject:   reject,
+		})
+	}
+	mux.Handle("/", limiter.Handler(app))
+
+	closing := make(chan struct{})
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           withRequestID(withAccessLog(mux)),
+		ReadTimeout:       *readTimeout,
+		ReadHeaderTimeout: *readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       *idleTimeout,
+		ConnContext:       saveConn,
+	}
+	srv.RegisterOnShutdown(func() { close(closing) })
 
 	// Start the server
-	addr := "localhost:8080"
-	fmt.Printf("Inventory server listening on: %s\n", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	errc := make(chan error, 2)
+	go func() {
+		fmt.Printf("Inventory server listening on: %s\n", *addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	// Load the storage. On failure, the server stays up but not ready, so
+	// /readyz reports the reason.
+	var grpcSrv *grpc.Server
+	var reg *tenants
+	store, audit, err := openStorage(*dataFile, *auditFile, *importPath)
+	if err == nil {
+		// Publish the changes to the event streams
+		broker := inventory.NewBroker(*eventsBuffer)
+		broker.Watch(store)
+		db := &database{store: store, audit: audit, broker: broker, closing: closing}
+
+		if reg, err = newTenants(*tenantsDir, db, auth, m, *eventsBuffer, *tenantQuota); err != nil {
+			store.Close()
+			if audit != nil {
+				audit.Close()
+			}
+			store = nil
+		}
+	}
+	if err != nil {
+		log.Printf("loading the storage: %v", err)
+		hc.setReady(false, fmt.Errorf("loading the storage: %v", err))
+	} else {
+		m.tenants.Store(reg)
+		app.h.Store(http.Handler(reg))
+		srv.RegisterOnShutdown(reg.stopAll)
+		hc.setReady(true, nil)
+
+		if *grpcAddr != "" {
+			grpcSrv = newGRPCServer(reg)
+			go func() {
+				lis, err := net.Listen("tcp", *grpcAddr)
+				if err != nil {
+					errc <- err
+					return
+				}
+				fmt.Printf("Inventory gRPC server listening on: %s\n", *grpcAddr)
+				errc <- grpcSrv.Serve(lis)
+			}()
+		}
+	}
+
+	// Wait for SIGINT (Ctrl-C) or SIGTERM from the process supervisor
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var failed bool
+	select {
+	case err := <-errc:
+		log.Print(err)
+		failed = true
+	case <-ctx.Done():
+		log.Print("shutting down")
+	}
+	hc.setReady(false, errors.New("shutting down"))
+
+	// Drain the requests in progress, and then flush the storage
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	if grpcSrv != nil {
+		// The watch streams end with the HTTP shutdown, which closes the
+		// closing channels of the tenants
+		done := make(chan struct{})
+		go func() {
+			grpcSrv.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			grpcSrv.Stop()
+		}
+	}
+	if reg != nil {
+		if err := reg.closeAll(); err != nil {
+			log.Printf("closing the tenants: %v", err)
+		}
+	}
+	if store != nil {
+		if err := store.Close(); err != nil {
+			log.Printf("closing the store: %v", err)
+		}
+		if audit != nil {
+			if err := audit.Close(); err != nil {
+				log.Printf("closing the audit log: %v", err)
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
 }
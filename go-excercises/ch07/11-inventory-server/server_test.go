@@ -0,0 +1,38 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+func TestOpenStorageSeed(t *testing.T) {
+	dataFile := filepath.Join(t.TempDir(), "items.log")
+	count := func() int {
+		t.Helper()
+		store, _, err := openStorage(dataFile, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		items, err := store.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, it := range items {
+			if err := store.Delete(it.Name, inventory.AnyVersion); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return len(items)
+	}
+
+	if n := count(); n != len(initialItems) {
+		t.Errorf("new store has %d items, want %d", n, len(initialItems))
+	}
+	// Emptied on purpose, so it stays empty
+	if n := count(); n != 0 {
+		t.Errorf("emptied store has %d items after a restart, want 0", n)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+// heartbeatInterval keeps the idle event streams alive through the proxies
+const heartbeatInterval = 15 * time.Second
+
+// events streams the item changes as Server-Sent Events
+//
+//	curl -N "http://localhost:8080/events?prefix=shoe"
+//	curl -N -H "Last-Event-ID: 42" "http://localhost:8080/events"
+//
+// Each event has the ID, the type (created, updated or deleted) and the
+// item as data. A client reconnecting with Last-Event-ID first receives the
+// events it missed. When they are no longer buffered, a "reset" event tells
+// the client to reload the items.
+func (d *database) events(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		jsonError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	var lastID int64
+	if s := r.Header.Get("Last-Event-ID"); s != "" {
+		var err error
+		if lastID, err = strconv.ParseInt(s, 10, 64); err != nil {
+			jsonError(w, http.StatusBadRequest, "invalid Last-Event-ID: "+s)
+			return
+		}
+	}
+
+	sub, missed, complete := d.broker.Subscribe(lastID, r.URL.Query().Get("prefix"))
+	defer d.broker.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	out := streamWriter(w, r)
+	if !complete {
+		fmt.Fprint(out, "event: reset\ndata: {}\n\n")
+	}
+	for _, e := range missed {
+		if err := writeEvent(out, e); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// Dropped for being too slow, the client resumes with Last-Event-ID
+				return
+			}
+			if err := writeEvent(out, e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(out, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-d.closing:
+			// The server is shutting down, the client reconnects to another one
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes a single event in the text/event-stream format
+func writeEvent(w io.Writer, e inventory.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("events: %v", err)
+		return nil
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
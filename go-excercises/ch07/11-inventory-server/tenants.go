@@ -0,0 +1,620 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+// tenantHeader selects the tenant of a request, as does the path prefix /t/{name}
+const tenantHeader = "X-Tenant"
+
+// defaultTenant serves the requests without a tenant, with the storage and
+// the credentials given by the flags
+const defaultTenant = "default"
+
+// validTenant matches the tenant names, which are also the directory names
+var validTenant = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+var (
+	errTenantNotFound = errors.New("tenant not found")
+	errTenantExists   = errors.New("tenant already exists")
+	errTenantRemoving = errors.New("tenant is being deleted")
+)
+
+// tenantAPIKey is an API key of a tenant. Only the hash is kept, the key
+// is shown once when the tenant is created.
+type tenantAPIKey struct {
+	Name   string `json:"name"`
+	Role   string `json:"role"`
+	SHA256 string `json:"sha256"`
+}
+
+// tenant is a named inventory with its own storage, API keys and quota
+type tenant struct {
+	Name      string         `json:"name"`
+	Quota     int            `json:"quota"` // maximum items, 0 for no limit
+	CreatedAt time.Time      `json:"created_at"`
+	Keys      []tenantAPIKey `json:"keys"`
+
+	db       *database
+	auth     *authorizer
+	handler  http.Handler
+	stopOnce sync.Once
+
+	// inUse is held for reading by the requests to the tenant, so that
+	// close waits for them before closing the storage
+	inUse  sync.RWMutex
+	closed bool
+}
+
+// stop ends the event streams of the tenant
+func (t *tenant) stop() {
+	t.stopOnce.Do(func() { close(t.db.closing) })
+}
+
+// tenantAuth authenticates the API keys of a tenant, and the global admins
+// who operate all the tenants. Any other global credentials are rejected,
+// so the keys of one tenant never reach the items of another one.
+type tenantAuth struct {
+	keys   *APIKeyAuth
+	global *authorizer
+}
+
+// Authenticate implements Authenticator
+func (a *tenantAuth) Authenticate(r *http.Request) (*Principal, error) {
+	p, err := a.keys.Authenticate(r)
+	if err == nil {
+		return p, nil
+	}
+	if gp, gerr := a.global.authenticate(r); gerr == nil && gp.Role >= RoleAdmin {
+		return gp, nil
+	}
+	return nil, err
+}
+
+// tenants holds the inventories and routes the requests to them. With a
+// directory, the tenants and their items are kept in it as
+//
+//	dir/tenants.json        the names, quotas and key hashes
+//	dir/{name}/items.db     the items (see inventory.FileStore)
+//	dir/{name}/audit.log    the audit log
+type tenants struct {
+	mu       sync.RWMutex
+	list     map[string]*tenant
+	removing map[string]bool // the tenants closing, whose names are not free yet
+	dir      string          // empty to keep the tenants in memory
+
+	global       *authorizer // the credentials of the default tenant and the tenant admins
+	metrics      *metrics
+	eventsBuffer int
+	defaultQuota int
+	admin        http.Handler
+}
+
+// newTenants serves def as the default tenant, and opens the other tenants
+// kept in dir
+func newTenants(dir string, def *database, global *authorizer, m *metrics, eventsBuffer, defaultQuota int) (*tenants, error) {
+	ts := &tenants{
+		list:         make(map[string]*tenant),
+		removing:     make(map[string]bool),
+		dir:          dir,
+		global:       global,
+		metrics:      m,
+		eventsBuffer: eventsBuffer,
+		defaultQuota: defaultQuota,
+	}
+	ts.list[defaultTenant] = &tenant{
+		Name:    defaultTenant,
+		db:      def,
+		auth:    global,
+		handler: routes(def, global, m),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenants", m.instrument("tenants", global.require(RoleAdmin, ts.serveTenants)))
+	mux.HandleFunc("/tenants/", m.instrument("tenant", global.require(RoleAdmin, ts.serveTenant)))
+	ts.admin = mux
+
+	if dir == "" {
+		return ts, nil
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "tenants.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return ts, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved []*tenant
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, fmt.Errorf("reading the tenants: %v", err)
+	}
+	for _, t := range saved {
+		if err := ts.open(t); err != nil {
+			ts.closeAll()
+			return nil, fmt.Errorf("opening the tenant %s: %v", t.Name, err)
+		}
+		ts.list[t.Name] = t
+	}
+	return ts, nil
+}
+
+// open loads the storage and the credentials of a tenant
+func (ts *tenants) open(t *tenant) error {
+	keys := newAPIKeyAuth()
+	for _, k := range t.Keys {
+		role, err := parseRole(k.Role)
+		if err != nil {
+			return err
+		}
+		b, err := hex.DecodeString(k.SHA256)
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("invalid hash of the key %s", k.Name)
+		}
+		var hash [sha256.Size]byte
+		copy(hash[:], b)
+		keys.keys[hash] = &Principal{Name: k.Name, Role: role}
+	}
+
+	// The tenants kept in memory have no audit log
+	var store inventory.Store = inventory.NewMemStore()
+	var audit *inventory.AuditLog
+	if ts.dir != "" {
+		dir := filepath.Join(ts.dir, t.Name)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		fs, err := inventory.OpenFileStore(filepath.Join(dir, "items.db"))
+		if err != nil {
+			return err
+		}
+		if audit, err = inventory.OpenAuditLog(filepath.Join(dir, "audit.log")); err != nil {
+			fs.Close()
+			return err
+		}
+		store = fs
+	}
+
+	broker := inventory.NewBroker(ts.eventsBuffer)
+	broker.Watch(store)
+	t.db = &database{
+		store:   inventory.WithQuota(store, t.Quota),
+		audit:   audit,
+		broker:  broker,
+		closing: make(chan struct{}),
+	}
+	t.auth = &authorizer{
+		authenticators: []Authenticator{&tenantAuth{keys: keys, global: ts.global}},
+		limiter:        ts.global.limiter,
+	}
+	t.handler = routes(t.db, t.auth, ts.metrics)
+	return nil
+}
+
+// acquire keeps the tenant open for a request until release. It returns
+// false once the tenant is closed.
+func (t *tenant) acquire() bool {
+	t.inUse.RLock()
+	if t.closed {
+		t.inUse.RUnlock()
+		return false
+	}
+	return true
+}
+
+// release ends a request of acquire
+func (t *tenant) release() {
+	t.inUse.RUnlock()
+}
+
+// close stops the tenant, and closes its storage once the requests in
+// progress are done. The event streams end on stop, the other requests
+// are short.
+func (t *tenant) close() error {
+	t.stop()
+	t.inUse.Lock()
+	defer t.inUse.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	err := t.db.store.Close()
+	if t.db.audit != nil {
+		if aerr := t.db.audit.Close(); err == nil {
+			err = aerr
+		}
+	}
+	return err
+}
+
+// closeAll closes the storage of the tenants other than the default
+func (ts *tenants) closeAll() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	var err error
+	for name, t := range ts.list {
+		if name == defaultTenant {
+			continue
+		}
+		if cerr := t.close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// stopAll ends the event streams of the tenants on shutdown
+func (ts *tenants) stopAll() {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	for name, t := range ts.list {
+		if name != defaultTenant {
+			t.stop()
+		}
+	}
+}
+
+// get returns the tenant, or nil if there is none of the name
+func (ts *tenants) get(name string) *tenant {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.list[name]
+}
+
+// create adds a tenant with a new editor and reader key. It returns the
+// keys by their names, as only their hashes are kept.
+func (ts *tenants) create(name string, quota int) (*tenant, map[string]string, error) {
+	if !validTenant.MatchString(name) {
+		return nil, nil, fmt.Errorf("invalid tenant name %q, use lowercase letters, digits and '-'", name)
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.list[name] != nil {
+		return nil, nil, errTenantExists
+	}
+	if ts.removing[name] {
+		return nil, nil, errTenantRemoving
+	}
+
+	t := &tenant{Name: name, Quota: quota, CreatedAt: time.Now().UTC()}
+	plain := make(map[string]string)
+	for _, role := range []Role{RoleEditor, RoleReader} {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		key := hex.EncodeToString(b)
+		hash := sha256.Sum256([]byte(key))
+		k := tenantAPIKey{Name: name + "-" + role.String(), Role: role.String(), SHA256: hex.EncodeToString(hash[:])}
+		t.Keys = append(t.Keys, k)
+		plain[k.Name] = key
+	}
+	// A new tenant never sees the items left by a failed deletion of the same name
+	if ts.dir != "" {
+		if err := os.RemoveAll(filepath.Join(ts.dir, name)); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := ts.open(t); err != nil {
+		return nil, nil, err
+	}
+
+	ts.list[name] = t
+	if err := ts.save(); err != nil {
+		delete(ts.list, name)
+		t.close()
+		return nil, nil, err
+	}
+	return t, plain, nil
+}
+
+// remove deletes the tenant with all its items. The tenant is closed
+// once its requests in progress are done, without holding the lock, so
+// that the other tenants are served meanwhile.
+func (ts *tenants) remove(name string) error {
+	if name == defaultTenant {
+		return errors.New("the default tenant cannot be deleted")
+	}
+
+	ts.mu.Lock()
+	t := ts.list[name]
+	if t == nil {
+		ts.mu.Unlock()
+		return errTenantNotFound
+	}
+	delete(ts.list, name)
+	if err := ts.save(); err != nil {
+		ts.list[name] = t
+		ts.mu.Unlock()
+		return err
+	}
+	ts.removing[name] = true
+	ts.mu.Unlock()
+
+	t.close()
+	var err error
+	if ts.dir != "" {
+		err = os.RemoveAll(filepath.Join(ts.dir, name))
+	}
+	ts.mu.Lock()
+	delete(ts.removing, name)
+	ts.mu.Unlock()
+	return err
+}
+
+// save writes the tenants to dir/tenants.json. It must be called with the lock held.
+func (ts *tenants) save() error {
+	if ts.dir == "" {
+		return nil
+	}
+	var saved []*tenant
+	for name, t := range ts.list {
+		if name != defaultTenant {
+			saved = append(saved, t)
+		}
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Name < saved[j].Name })
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Replace the file at once, so a crash leaves either the old or the new list
+	path := filepath.Join(ts.dir, "tenants.json")
+	if err := os.MkdirAll(ts.dir, 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// basePathKey is the context key of the path prefix of the tenant
+type basePathKey struct{}
+
+// basePath returns the path prefix that selected the tenant of the request,
+// like "/t/acme", to build the links back to it. It is empty for the tenants
+// selected by the header.
+func basePath(r *http.Request) string {
+	p, _ := r.Context().Value(basePathKey{}).(string)
+	return p
+}
+
+// tenantFromPath splits /t/{name}/rest into the name and /rest
+func tenantFromPath(path string) (name, rest string, ok bool) {
+	if !strings.HasPrefix(path, "/t/") {
+		return "", path, false
+	}
+	name, rest, _ = strings.Cut(strings.TrimPrefix(path, "/t/"), "/")
+	return name, "/" + rest, true
+}
+
+// ServeHTTP routes the request to its tenant:
+//
+//	curl "http://localhost:8080/t/acme/items"
+//	curl -H "X-Tenant: acme" "http://localhost:8080/items"
+//
+// The requests without either go to the default tenant.
+func (ts *tenants) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, rest, byPath := tenantFromPath(r.URL.Path)
+	header := r.Header.Get(tenantHeader)
+	switch {
+	case byPath && header != "" && header != name:
+		jsonError(w, http.StatusBadRequest, "the tenant of the path and the "+tenantHeader+" header differ")
+		return
+	case !byPath && header != "":
+		name = header
+	case !byPath:
+		if r.URL.Path == "/tenants" || strings.HasPrefix(r.URL.Path, "/tenants/") {
+			ts.admin.ServeHTTP(w, r)
+			return
+		}
+		name = defaultTenant
+	}
+
+	t := ts.get(name)
+	if t == nil || !t.acquire() {
+		jsonError(w, http.StatusNotFound, "tenant not found: "+name)
+		return
+	}
+	defer t.release()
+	if byPath {
+		r2 := r.WithContext(context.WithValue(r.Context(), basePathKey{}, "/t/"+name))
+		u := *r.URL
+		u.Path, u.RawPath = rest, ""
+		r2.URL = &u
+		r = r2
+	}
+	t.handler.ServeHTTP(w, r)
+}
+
+// storageStats are the storage metrics of a tenant
+type storageStats struct {
+	tenant      string
+	items       int // -1 when they cannot be counted
+	writeErrors int64
+	audited     bool
+	auditErrors int64
+}
+
+// sorted returns the tenants sorted by name
+func (ts *tenants) sorted() []*tenant {
+	ts.mu.RLock()
+	list := make([]*tenant, 0, len(ts.list))
+	for _, t := range ts.list {
+		list = append(list, t)
+	}
+	ts.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// storageStats returns the storage metrics of the tenants open
+func (ts *tenants) storageStats() []storageStats {
+	var stats []storageStats
+	for _, t := range ts.sorted() {
+		if !t.acquire() {
+			continue
+		}
+		st := storageStats{tenant: t.Name, items: -1, writeErrors: inventory.WriteErrors(t.db.store)}
+		if n, err := inventory.Len(t.db.store); err == nil {
+			st.items = n
+		}
+		if t.db.audit != nil {
+			st.audited, st.auditErrors = true, t.db.audit.WriteErrors()
+		}
+		t.release()
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+// tenantInput is the request body to create a tenant
+type tenantInput struct {
+	Name  string `json:"name"`
+	Quota *int   `json:"quota,omitempty"`
+}
+
+// tenantJSON is the JSON representation of a tenant
+type tenantJSON struct {
+	Name      string            `json:"name"`
+	Quota     int               `json:"quota"`
+	Items     int               `json:"items"`
+	CreatedAt *time.Time        `json:"created_at,omitempty"` // nil for the default tenant
+	Keys      []tenantKeyJSON   `json:"keys,omitempty"`
+	NewKeys   map[string]string `json:"new_keys,omitempty"` // shown once on creation
+}
+
+// tenantKeyJSON describes an API key without its hash
+type tenantKeyJSON struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// newTenantJSON describes the tenant with its item count
+func newTenantJSON(t *tenant) tenantJSON {
+	tj := tenantJSON{Name: t.Name, Quota: t.Quota}
+	if !t.CreatedAt.IsZero() {
+		tj.CreatedAt = &t.CreatedAt
+	}
+	if t.acquire() {
+		if n, err := inventory.Len(t.db.store); err == nil {
+			tj.Items = n
+		}
+		t.release()
+	}
+	for _, k := range t.Keys {
+		tj.Keys = append(tj.Keys, tenantKeyJSON{Name: k.Name, Role: k.Role})
+	}
+	return tj
+}
+
+// serveTenants serves the collection of the tenants, for the global admins
+//
+//	GET  /tenants - list the tenants
+//	POST /tenants - create a tenant from {"name": "acme", "quota": 1000}
+//
+// A new tenant gets an editor and a reader API key, which are only shown
+// in the response. Without a quota, the -tenant-quota flag applies, and
+// a quota of 0 means no limit.
+func (ts *tenants) serveTenants(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		list := ts.sorted()
+		out := make([]tenantJSON, 0, len(list))
+		for _, t := range list {
+			out = append(out, newTenantJSON(t))
+		}
+		writeJSON(w, http.StatusOK, out)
+
+	case http.MethodPost:
+		var in tenantInput
+		if err := decodeJSON(w, r, &in); err != nil {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !validTenant.MatchString(in.Name) {
+			jsonError(w, http.StatusBadRequest, fmt.Sprintf("invalid tenant name %q, use lowercase letters, digits and '-'", in.Name))
+			return
+		}
+		quota := ts.defaultQuota
+		if in.Quota != nil {
+			quota = *in.Quota
+		}
+		if quota < 0 {
+			jsonError(w, http.StatusBadRequest, "negative quota")
+			return
+		}
+
+		t, keys, err := ts.create(in.Name, quota)
+		switch {
+		case errors.Is(err, errTenantExists), errors.Is(err, errTenantRemoving):
+			jsonError(w, http.StatusConflict, err.Error()+": "+in.Name)
+			return
+		case err != nil:
+			jsonStoreError(w, err, "")
+			return
+		}
+		tj := newTenantJSON(t)
+		tj.NewKeys = keys
+		w.Header().Set("Location", "/tenants/"+t.Name)
+		writeJSON(w, http.StatusCreated, tj)
+
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead, http.MethodPost)
+	}
+}
+
+// serveTenant serves a single tenant, for the global admins
+//
+//	GET    /tenants/{name} - describe the tenant
+//	DELETE /tenants/{name} - delete the tenant and all its items
+func (ts *tenants) serveTenant(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/tenants/")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		t := ts.get(name)
+		if t == nil {
+			jsonError(w, http.StatusNotFound, "tenant not found: "+name)
+			return
+		}
+		writeJSON(w, http.StatusOK, newTenantJSON(t))
+
+	case http.MethodDelete:
+		if name == defaultTenant {
+			jsonError(w, http.StatusBadRequest, "the default tenant cannot be deleted")
+			return
+		}
+		err := ts.remove(name)
+		switch {
+		case errors.Is(err, errTenantNotFound):
+			jsonError(w, http.StatusNotFound, "tenant not found: "+name)
+		case err != nil:
+			jsonStoreError(w, err, "")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodHead, http.MethodDelete)
+	}
+}
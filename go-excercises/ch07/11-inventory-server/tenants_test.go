@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch07/11-inventory-server/inventory"
+)
+
+// newTenantServer serves the tenants kept in dir, with a global admin key
+// "opskey" and a global reader key "readkey"
+func newTenantServer(t *testing.T, dir string) (*httptest.Server, *tenants) {
+	keysFile := filepath.Join(t.TempDir(), "keys.txt")
+	os.WriteFile(keysFile, []byte("ops admin opskey\nbot reader readkey\n"), 0600)
+	keys, err := LoadAPIKeys(keysFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := &authorizer{authenticators: []Authenticator{keys}}
+
+	db := &database{store: inventory.NewMemStore(), audit: inventory.NewAuditLog(), broker: inventory.NewBroker(16), closing: make(chan struct{})}
+	ts, err := newTenants(dir, db, auth, newMetrics(), 16, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(ts)
+	t.Cleanup(func() {
+		srv.Close()
+		ts.closeAll()
+	})
+	return srv, ts
+}
+
+// createTenant creates a tenant and returns its editor key
+func createTenant(t *testing.T, url, name string) string {
+	t.Helper()
+	resp, body := do(t, "POST", url+"/tenants", `{"name":"`+name+`"}`, "X-API-Key", "opskey")
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create tenant %s = %d %s", name, resp.StatusCode, body)
+	}
+	var tj tenantJSON
+	if err := json.Unmarshal([]byte(body), &tj); err != nil {
+		t.Fatal(err)
+	}
+	return tj.NewKeys[name+"-editor"]
+}
+
+func TestTenantIsolation(t *testing.T) {
+	dir := t.TempDir()
+	srv, _ := newTenantServer(t, dir)
+	acme := createTenant(t, srv.URL, "acme")
+	globex := createTenant(t, srv.URL, "globex")
+
+	hat := `{"name":"hat","price":3}`
+	tests := []struct {
+		name, method, path, body string
+		header                   []string
+		status                   int
+	}{
+		{"create by path", "POST", "/t/acme/items", hat, []string{"X-API-Key", acme}, 201},
+		{"get by header", "GET", "/items/hat", "", []string{"X-Tenant", "acme", "X-API-Key", acme}, 200},
+		{"other tenant's key", "GET", "/t/acme/items/hat", "", []string{"X-API-Key", globex}, 401},
+		{"other tenant's key by header", "GET", "/items/hat", "", []string{"X-Tenant", "acme", "X-API-Key", globex}, 401},
+		{"global reader", "GET", "/t/acme/items/hat", "", []string{"X-API-Key", "readkey"}, 401},
+		{"global admin", "GET", "/t/acme/items/hat", "", []string{"X-API-Key", "opskey"}, 200},
+		{"no key", "GET", "/t/acme/items", "", nil, 401},
+		{"not in other tenant", "GET", "/t/globex/items/hat", "", []string{"X-API-Key", globex}, 404},
+		{"not in default tenant", "GET", "/items/hat", "", []string{"X-API-Key", "readkey"}, 404},
+		{"path and header differ", "GET", "/t/acme/items", "", []string{"X-Tenant", "globex", "X-API-Key", acme}, 400},
+		{"unknown tenant", "GET", "/t/initech/items", "", []string{"X-API-Key", "opskey"}, 404},
+		{"within the quota", "POST", "/t/acme/items", `{"name":"cap","price":1}`, []string{"X-API-Key", acme}, 201},
+		{"over the quota", "POST", "/t/acme/items", `{"name":"scarf","price":1}`, []string{"X-API-Key", acme}, 403},
+		{"tenants by a reader", "GET", "/tenants", "", []string{"X-API-Key", "readkey"}, 403},
+		{"tenants by a tenant", "GET", "/tenants", "", []string{"X-API-Key", acme}, 401},
+		{"invalid name", "POST", "/tenants", `{"name":"../etc"}`, []string{"X-API-Key", "opskey"}, 400},
+		{"delete default", "DELETE", "/tenants/default", "", []string{"X-API-Key", "opskey"}, 400},
+	}
+	for _, test := range tests {
+		resp, body := do(t, test.method, srv.URL+test.path, test.body, test.header...)
+		if resp.StatusCode != test.status {
+			t.Errorf("%s: %s %s = %d %s, want %d", test.name, test.method, test.path, resp.StatusCode, body, test.status)
+		}
+	}
+
+	resp, _ := do(t, "POST", srv.URL+"/t/globex/items", hat, "X-API-Key", globex)
+	if loc := resp.Header.Get("Location"); loc != "/t/globex/items/hat" {
+		t.Errorf("Location = %q, want the tenant path", loc)
+	}
+	if _, body := do(t, "GET", srv.URL+"/tenants/acme", "", "X-API-Key", "opskey"); !strings.Contains(body, `"items":2`) || strings.Contains(body, "sha256") {
+		t.Errorf("tenant = %s, want 2 items and no key hashes", body)
+	}
+
+	// The tenants, their keys and items are kept in the directory
+	srv2, _ := newTenantServer(t, dir)
+	if resp, body := do(t, "GET", srv2.URL+"/t/acme/items/hat", "", "X-API-Key", acme); resp.StatusCode != 200 {
+		t.Errorf("after reopening: %d %s", resp.StatusCode, body)
+	}
+	if resp, _ := do(t, "DELETE", srv2.URL+"/tenants/acme", "", "X-API-Key", "opskey"); resp.StatusCode != 204 {
+		t.Errorf("delete tenant = %d", resp.StatusCode)
+	}
+	if resp, _ := do(t, "GET", srv2.URL+"/t/acme/items", "", "X-API-Key", acme); resp.StatusCode != 404 {
+		t.Errorf("deleted tenant = %d, want 404", resp.StatusCode)
+	}
+
+	// A tenant created again with the same name starts empty, with new keys
+	acme2 := createTenant(t, srv2.URL, "acme")
+	if _, body := do(t, "GET", srv2.URL+"/t/acme/items", "", "X-API-Key", acme2); body != "[]" {
+		t.Errorf("recreated tenant items = %s, want none", body)
+	}
+	if resp, _ := do(t, "GET", srv2.URL+"/t/acme/items", "", "X-API-Key", acme); resp.StatusCode != 401 {
+		t.Errorf("old key of a recreated tenant = %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestTenantRemoveInFlight(t *testing.T) {
+	srv, ts := newTenantServer(t, t.TempDir())
+	key := createTenant(t, srv.URL, "acme")
+	globex := createTenant(t, srv.URL, "globex")
+	acme := ts.get("acme")
+
+	// A request in progress keeps the storage open until it is done
+	if !acme.acquire() {
+		t.Fatal("acme closed before its removal")
+	}
+	removed := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequest("DELETE", srv.URL+"/tenants/acme", nil)
+		req.Header.Set("X-API-Key", "opskey")
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent {
+				err = fmt.Errorf("DELETE /tenants/acme = %d, want 204", resp.StatusCode)
+			}
+		}
+		removed <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-removed:
+		t.Fatalf("DELETE returned %v with a request in progress", err)
+	default:
+	}
+	if _, err := acme.db.store.List(); err != nil {
+		t.Errorf("store of the request in progress: %v", err)
+	}
+
+	// The other tenants are served meanwhile, and the name is not free yet
+	if resp, body := do(t, "GET", srv.URL+"/t/globex/items", "", "X-API-Key", globex); resp.StatusCode != http.StatusOK {
+		t.Errorf("items of another tenant during the removal = %d %s, want 200", resp.StatusCode, body)
+	}
+	if resp, body := do(t, "POST", srv.URL+"/tenants", `{"name":"acme"}`, "X-API-Key", "opskey"); resp.StatusCode != http.StatusConflict {
+		t.Errorf("create acme during its removal = %d %s, want 409", resp.StatusCode, body)
+	}
+	acme.release()
+	if err := <-removed; err != nil {
+		t.Fatal(err)
+	}
+
+	// The requests that found the tenant before its removal are not served
+	if acme.acquire() {
+		t.Error("acme acquired after its removal")
+	}
+	if resp, body := do(t, "GET", srv.URL+"/t/acme/items", "", "X-API-Key", key); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("items of a removed tenant = %d %s, want 404", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/discovery"
+)
+
+// advertisedAddr returns the address announced for the listener when
+// -advertise is not set. The listener bound to all the interfaces is
+// announced with the host name, or with no host to the multicast group, which
+// the listeners fill with the address the datagram came from.
+func advertisedAddr(bind, port string, multicast bool) string {
+	if ip := net.ParseIP(bind); bind != "" && (ip == nil || !ip.IsUnspecified()) {
+		return net.JoinHostPort(bind, port)
+	}
+	if multicast {
+		return net.JoinHostPort("", port)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// announce starts the heartbeats of the clock to the registry and the
+// multicast group, those set. The returned function stops them and waits
+// for the clock to be withdrawn.
+func announce(c discovery.Clock, registry, group string) (stop func(), err error) {
+	var announcers []discovery.Announcer
+	if registry != "" {
+		announcers = append(announcers, &discovery.RemoteRegistry{URL: registry})
+	}
+	if group != "" {
+		m, err := discovery.DialMulticast(group)
+		if err != nil {
+			return nil, err
+		}
+		announcers = append(announcers, m)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, a := range announcers {
+		wg.Add(1)
+		go func(a discovery.Announcer) {
+			defer wg.Done()
+			if err := discovery.Heartbeat(a, c, done, log.Printf); err != nil {
+				log.Printf("announcing clock %s: %v", c.Name, err)
+			}
+		}(a)
+	}
+	log.Printf("Announcing clock %s at %s every %ds", c.Name, c.Addr, c.TTL/3)
+	return func() {
+		close(done)
+		wg.Wait()
+	}, nil
+}
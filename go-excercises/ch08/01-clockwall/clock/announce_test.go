@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestAdvertisedAddr(t *testing.T) {
+	hostname, _ := os.Hostname()
+	for _, test := range []struct {
+		bind      string
+		multicast bool
+		want      string
+	}{
+		{"10.0.0.5", false, "10.0.0.5:8001"},
+		{"lab1.local", true, "lab1.local:8001"},
+		{"::1", false, "[::1]:8001"},
+		{"0.0.0.0", true, ":8001"},
+		{"::", true, ":8001"},
+		{"", false, hostname + ":8001"},
+		{"0.0.0.0", false, hostname + ":8001"},
+	} {
+		if got := advertisedAddr(test.bind, "8001", test.multicast); got != test.want {
+			t.Errorf("advertisedAddr(%q, %v) = %q, want %q", test.bind, test.multicast, got, test.want)
+		}
+	}
+}
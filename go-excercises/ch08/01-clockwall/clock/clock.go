@@ -1,56 +1,191 @@
 /*
-clock serves the time every second through a TCP channel
-It can produce the time based on the timezone set by environment variable CLOCK_TZ
+clock serves the time through a TCP channel in any time zone
 
-Example: Works on Linux environment to set env CLOCK_TZ
+A client sends a hello line with the zone, the format and the interval of
+the ticks, and the server streams them as JSON frames (see clockproto):
+
+	$ ./clock --port 8001
+	$ (echo "CLOCK/1 zone=Asia/Kolkata interval=2s"; cat) | nc localhost 8001
+
+The JSON clients can also measure the offset of the server clock with sync
+requests, answered with the precise times of the server (see clockproto).
+
+The legacy clients sending no hello get the time every second as a plain
+"15:04:05" line, in the default zone set by -zone or the environment variable
+CLOCK_TZ. Example: Works on Linux environment to set env CLOCK_TZ
 
 	$ CLOCK_TZ=Asia/Kolkata ./clock --port 8002
+
+The connections are secured with TLS given a certificate and its key, and
+the clients must present a certificate signed by -client-ca if set. The
+gencert subcommand creates the certificates of a lab network (see gencert):
+
+	$ ./clock gencert -hosts localhost,lab1.local -dir certs
+	$ ./clock -cert certs/server.pem -key certs/server-key.pem -client-ca certs/ca.pem
+
+The clients naming themselves in the hello can register alarms, timers and
+cron schedules, notified over their connections when they fire. They are
+kept per client ID across the connections, and saved in the -schedules file
+across the restarts. A client presenting a certificate must be named in it,
+by its common name or one of its alternative names. The clockctl command
+manages them:
+
+	$ ./clock -schedules schedules.json
+	$ clockctl -client lab alarm wake-up 07:30 Asia/Kolkata
+	$ clockctl -client lab watch
+
+The server announces itself to the clock walls running with -discover, to a
+clockregistry with -registry or to the LAN with -multicast. The announcement
+names the clock, its default zone and its address, and is repeated as a
+heartbeat until the server stops:
+
+	$ ./clock -name lab1 -zone Europe/London -registry http://registry.local:8090
+	$ ./clock -name lab2 -zone Asia/Tokyo -multicast
+
+All the clients tick from a single ticker aligned to the tenth of a second,
+so the clients of an interval tick together, and each tick line is
+formatted once per zone. A client too slow to take its ticks loses them,
+and is disconnected after 10 in a row. Up to -max-clients are served, and
+-debug serves their stats:
+
+	$ ./clock -max-clients 5000 -debug localhost:6061
+	$ curl "localhost:6061/debug/clients?list=1"
 */
 package main
 
 import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/discovery"
 )
 
-// bindAddr is the server bind address to listen for clients
+// bindAddr is the default server bind address to listen for clients
 const bindAddr = "0.0.0.0"
 
-// loc indicates the clock timezone
-var loc *time.Location
+// handshakeTimeout is how long the TLS handshake of a client may take
+const handshakeTimeout = 10 * time.Second
 
-func init() {
-	// Process the timezone from environment variable
-	tz, ok := os.LookupEnv("CLOCK_TZ")
-	if !ok {
-		// Use the default as UTC
-		tz = "UTC"
+// helloTimeout is how long to wait for the hello before serving a legacy client
+var helloTimeout = 500 * time.Millisecond
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "gencert" {
+		if err := gencert(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	var err error
-	loc, err = time.LoadLocation(tz)
-	if err != nil {
-		log.Fatal(err)
+	// Use the timezone of the environment variable as the default, else UTC
+	defaultTZ, ok := os.LookupEnv("CLOCK_TZ")
+	if !ok {
+		defaultTZ = "UTC"
 	}
-}
 
-func main() {
 	// Read the port number from command line arguments
 	port := flag.Int("port", 8001, "port number")
+	bind := flag.String("bind", bindAddr, "address to listen on")
+	certFile := flag.String("cert", "", "PEM certificate to serve TLS (default plain TCP)")
+	keyFile := flag.String("key", "", "PEM key of the certificate")
+	clientCA := flag.String("client-ca", "", "PEM CA the client certificates must be signed by (default no client certificate)")
+	schedulesFile := flag.String("schedules", "", "file to save the schedules of the clients (default in-memory)")
+	zone := flag.String("zone", defaultTZ, "time zone of the clients not asking for one")
+	flag.DurationVar(&helloTimeout, "hello-timeout", helloTimeout, "time to wait for the hello of a client")
+	hostname, _ := os.Hostname()
+	name := flag.String("name", hostname, "name of the clock announced")
+	registry := flag.String("registry", "", "URL of the clockregistry to announce the clock to")
+	multicast := flag.Bool("multicast", false, "announce the clock to the LAN with UDP multicast")
+	group := flag.String("group", discovery.DefaultGroup, "multicast group of the announcements")
+	advertise := flag.String("advertise", "", "address of the clock announced (default the host name and the port)")
+	ttl := flag.Duration("ttl", 30*time.Second, "time the announcement lasts, repeated every third of it")
+	maxClients := flag.Int("max-clients", 10000, "clients served at once (0 for no limit)")
+	debugAddr := flag.String("debug", "", "address to serve the client stats on /debug/clients, such as localhost:6061")
 	flag.Parse()
 
+	loc, err := loadLocation(*zone)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sched, err := newScheduler(*schedulesFile, loc)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go sched.run(nil)
+	h := newHub(*maxClients)
+	go h.run(nil)
+	srv := &server{defaultLoc: loc, sched: sched, hub: h}
+	if *debugAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/clients", h)
+		go func() {
+			log.Fatal(http.ListenAndServe(*debugAddr, mux))
+		}()
+	}
+
 	// Start the server to listen on particular address
-	address := fmt.Sprintf("%s:%d", bindAddr, *port)
+	address := net.JoinHostPort(*bind, fmt.Sprint(*port))
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Printf("Server listening on %s (CLOCK_TZ=%s)\n", address, loc.String())
+	security := "plain TCP"
+	if *certFile != "" || *keyFile != "" {
+		cfg, err := clockproto.ServerTLS(*certFile, *keyFile, *clientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		listener = tls.NewListener(listener, cfg)
+		security = "TLS"
+		if *clientCA != "" {
+			security = "mutual TLS"
+		}
+	} else if *clientCA != "" {
+		log.Fatal("-client-ca needs -cert and -key")
+	}
+	log.Printf("Server listening on %s with %s (default zone %s)\n", address, security, loc)
+
+	if *registry != "" || *multicast {
+		if *ttl < 3*time.Second || *ttl > discovery.MaxTTL {
+			log.Fatalf("-ttl must be between 3s and %s", discovery.MaxTTL)
+		}
+		if *advertise == "" {
+			*advertise = advertisedAddr(*bind, fmt.Sprint(*port), *multicast && *registry == "")
+		}
+		if !*multicast {
+			*group = ""
+		}
+		c := discovery.Clock{Name: *name, Zone: loc.String(), Addr: *advertise, TTL: int(ttl.Seconds())}
+		stop, err := announce(c, *registry, *group)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Withdraw the clock on exit, so that the walls drop it at once
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			stop()
+			os.Exit(0)
+		}()
+	}
 
 	// Accept the client connections and serve on separate goroutine
 	for {
@@ -60,18 +195,299 @@ func main() {
 			continue
 		}
 
-		go handleConn(conn)
+		go srv.handleConn(conn)
+	}
+}
+
+// locations caches the loaded time zones, as loading one reads a file
+var locations = struct {
+	sync.Mutex
+	m map[string]*time.Location
+}{m: make(map[string]*time.Location)}
+
+// loadLocation returns the time zone of the IANA name
+func loadLocation(name string) (*time.Location, error) {
+	locations.Lock()
+	defer locations.Unlock()
+	if loc, ok := locations.m[name]; ok {
+		return loc, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
 	}
+	locations.m[name] = loc
+	return loc, nil
+}
+
+// readHello waits for the hello of the client. The legacy clients send
+// nothing, so they get the text format in the default zone.
+func readHello(c net.Conn, r *bufio.Reader) (clockproto.Hello, error) {
+	legacy := clockproto.Hello{Format: clockproto.FormatText, Interval: clockproto.DefaultInterval}
+
+	c.SetReadDeadline(time.Now().Add(helloTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	line, err := r.ReadString('\n')
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() && line == "" {
+		return legacy, nil
+	}
+	if err != nil && !(err == io.EOF && line != "") {
+		return clockproto.Hello{}, err
+	}
+	if strings.TrimSpace(line) == "" {
+		return legacy, nil
+	}
+	return clockproto.ParseHello(line)
+}
+
+// checkClient verifies that a client presenting a certificate names itself
+// in the hello as the certificate does, by its common name or one of its
+// alternative names, so that it cannot reach the schedules of another client
+func checkClient(c net.Conn, client string) error {
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	certs := tc.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil
+	}
+	cert := certs[0]
+	names := append([]string{cert.Subject.CommonName}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	for _, name := range names {
+		if name == client {
+			return nil
+		}
+	}
+	return fmt.Errorf("client %q is not named in its certificate", client)
+}
+
+// server serves the clock connections
+type server struct {
+	defaultLoc *time.Location // of the clients not asking for a zone
+	sched      *scheduler
+	hub        *hub
 }
 
 // handleConn serves the time for a single client as long as the connection is alive
-func handleConn(c net.Conn) {
+func (s *server) handleConn(c net.Conn) {
 	defer c.Close()
+
+	// The TLS handshake is done first, so that the hello timeout is not spent on it
+	if tc, ok := c.(*tls.Conn); ok {
+		tc.SetDeadline(time.Now().Add(handshakeTimeout))
+		if err := tc.Handshake(); err != nil {
+			log.Printf("%s: %v", c.RemoteAddr(), err)
+			return
+		}
+		tc.SetDeadline(time.Time{})
+	}
+
+	r := bufio.NewReader(c)
+	hello, err := readHello(c, r)
+	loc := s.defaultLoc
+	if err == nil && hello.Zone != "" {
+		loc, err = loadLocation(hello.Zone)
+	}
+	if err == nil && hello.Client != "" {
+		err = checkClient(c, hello.Client)
+	}
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			reject(c, hello.Format, err)
+		}
+		return
+	}
+
+	sub, err := s.hub.subscribe(loc, hello.Format, hello.Interval, c.RemoteAddr().String(), func() { c.Close() })
+	if err != nil {
+		log.Printf("%s: %v", c.RemoteAddr(), err)
+		reject(c, hello.Format, err)
+		return
+	}
+	defer s.hub.unsubscribe(sub)
+
+	w := &frameWriter{w: c, notes: make(chan clockproto.Frame, maxPending)}
+	if hello.Format == clockproto.FormatJSON {
+		err := w.write(clockproto.Frame{
+			Type:     clockproto.FrameWelcome,
+			Version:  clockproto.Version,
+			Zone:     loc.String(),
+			Interval: hello.Interval.String(),
+		})
+		if err != nil {
+			return
+		}
+		if hello.Client != "" {
+			defer s.sched.detach(hello.Client, w)
+			pending := s.sched.attach(hello.Client, w)
+			for i, f := range pending {
+				if err := w.writeBy(f, time.Now().Add(notifyTimeout)); err != nil {
+					w.unsent = pending[i:]
+					return
+				}
+			}
+		}
+
+		// The requests are answered while the ticks go on, and the client
+		// leaving or sending a bad one stops the ticks as well
+		go func() {
+			defer s.hub.unsubscribe(sub)
+			s.serveRequests(r, w, hello.Client)
+		}()
+	}
+
+	// The first tick is sent at once, the next ones come from the hub, and
+	// the notifications from the scheduler
+	if err := w.writeLine(tickLine(time.Now().In(loc), hello.Format)); err != nil {
+		return
+	}
 	for {
-		_, err := io.WriteString(c, time.Now().In(loc).Format("15:04:05\n"))
+		select {
+		case line, ok := <-sub.ch:
+			if !ok {
+				return
+			}
+			if err := w.writeLine(line); err != nil {
+				return
+			}
+		case f := <-w.notes:
+			if err := w.writeBy(f, time.Now().Add(notifyTimeout)); err != nil {
+				w.unsent = append(w.unsent, f)
+				return
+			}
+		}
+	}
+}
+
+// serveRequests answers the requests of a JSON client until it leaves
+func (s *server) serveRequests(r *bufio.Reader, w *frameWriter, client string) {
+	for {
+		line, err := r.ReadString('\n')
+		received := time.Now()
+		if err != nil {
+			return
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		verb, id, arg, err := clockproto.ParseRequest(line)
 		if err != nil {
+			w.write(clockproto.Frame{Type: clockproto.FrameError, Error: err.Error()})
+			return
+		}
+		switch verb {
+		case clockproto.CmdSync:
+			t1, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				w.write(clockproto.Frame{Type: clockproto.FrameError, Error: fmt.Sprintf("invalid sync time %q", arg)})
+				return
+			}
+			w.writeSync(clockproto.Frame{Type: clockproto.FrameSync, ID: id, T1: t1, T2: received.UnixNano()})
+		case clockproto.CmdAdd, clockproto.CmdCancel, clockproto.CmdList:
+			w.write(s.schedule(client, verb, id, arg))
+		default:
+			w.write(clockproto.Frame{Type: clockproto.FrameError, Error: fmt.Sprintf("unknown request %q", verb)})
 			return
 		}
-		time.Sleep(1 * time.Second)	
 	}
-}
\ No newline at end of file
+}
+
+// schedule answers a request about the schedules of the client
+func (s *server) schedule(client, verb string, id uint64, arg string) clockproto.Frame {
+	reply := clockproto.Frame{Type: clockproto.FrameReply, ID: id}
+	if client == "" {
+		reply.Error = "the schedules need a client ID in the hello"
+		return reply
+	}
+	switch verb {
+	case clockproto.CmdAdd:
+		var sch clockproto.Schedule
+		if err := json.Unmarshal([]byte(arg), &sch); err != nil {
+			reply.Error = fmt.Sprintf("invalid schedule: %v", err)
+			return reply
+		}
+		sch, err := s.sched.add(client, sch)
+		if err != nil {
+			reply.Error = err.Error()
+			return reply
+		}
+		reply.Schedules = []clockproto.Schedule{sch}
+	case clockproto.CmdCancel:
+		if err := s.sched.cancel(client, arg); err != nil {
+			reply.Error = err.Error()
+		}
+	case clockproto.CmdList:
+		reply.Schedules = s.sched.list(client)
+	}
+	return reply
+}
+
+// frameWriter serializes the frames of the ticks and of the answers
+type frameWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	notes  chan clockproto.Frame // the notifications to write, queued by the scheduler
+	unsent []clockproto.Frame    // the notifications that failed, pending again on detach
+}
+
+// write writes a frame
+func (fw *frameWriter) write(f clockproto.Frame) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return writeFrame(fw.w, f)
+}
+
+// writeBy writes a frame, failing if the connection does not take it by
+// the deadline
+func (fw *frameWriter) writeBy(f clockproto.Frame, deadline time.Time) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if c, ok := fw.w.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		c.SetWriteDeadline(deadline)
+		defer c.SetWriteDeadline(time.Time{})
+	}
+	return writeFrame(fw.w, f)
+}
+
+// writeLine writes a line formatted already
+func (fw *frameWriter) writeLine(b []byte) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	_, err := fw.w.Write(b)
+	return err
+}
+
+// writeSync writes a sync frame, stamping its transmit time once the
+// frame is next to be written
+func (fw *frameWriter) writeSync(f clockproto.Frame) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	f.T3 = time.Now().UnixNano()
+	return writeFrame(fw.w, f)
+}
+
+// reject sends the error to a JSON client before its connection is closed.
+// The text and legacy clients only read the time lines, so they are just
+// disconnected.
+func reject(c net.Conn, format string, err error) {
+	if format != clockproto.FormatText {
+		writeFrame(c, clockproto.Frame{Type: clockproto.FrameError, Error: err.Error()})
+	}
+}
+
+// writeFrame writes a JSON frame as a line
+func writeFrame(w io.Writer, f clockproto.Frame) error {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
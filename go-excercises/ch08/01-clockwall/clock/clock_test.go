@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+// newTestServer creates a server in UTC keeping the schedules in memory
+func newTestServer(t *testing.T) *server {
+	sched, err := newScheduler("", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go sched.run(done)
+	h := newHub(0)
+	go h.run(done)
+	t.Cleanup(func() { close(done) })
+	return &server{defaultLoc: time.UTC, sched: sched, hub: h}
+}
+
+// serve starts handleConn on a pipe and returns the client side
+func serve(t *testing.T, srv *server) net.Conn {
+	client, conn := net.Pipe()
+	go srv.handleConn(conn)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestLegacyClient(t *testing.T) {
+	helloTimeout = 10 * time.Millisecond
+	c := serve(t, newTestServer(t))
+
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^\d\d:\d\d:\d\d\n$`).MatchString(line) {
+		t.Errorf("legacy line = %q, want 15:04:05", line)
+	}
+}
+
+func TestHello(t *testing.T) {
+	if _, err := loadLocation("Asia/Kolkata"); err != nil {
+		t.Skip(err)
+	}
+
+	conn, err := clockproto.Handshake(serve(t, newTestServer(t)), clockproto.Hello{Zone: "Asia/Kolkata", Interval: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		f, err := conn.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Type != clockproto.FrameTick || f.Zone != "Asia/Kolkata" || f.Abbrev != "IST" || f.Offset != "+05:30" {
+			t.Errorf("tick %d = %+v, want IST +05:30", i, f)
+		}
+		if _, err := f.ParseTime(); err != nil {
+			t.Errorf("tick %d: %v", i, err)
+		}
+	}
+
+	// The text format has the legacy lines in the zone
+	conn, err = clockproto.Handshake(serve(t, newTestServer(t)), clockproto.Hello{Zone: "Asia/Kolkata", Format: clockproto.FormatText})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := conn.Next(); err != nil || len(f.Time) != len(clockproto.TextLayout) {
+		t.Errorf("text tick = %+v, %v, want 15:04:05", f, err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	conn, err := clockproto.Handshake(serve(t, newTestServer(t)), clockproto.Hello{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go conn.Sync()
+	for {
+		f, err := conn.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Type != clockproto.FrameSync {
+			continue
+		}
+		m := f.Sample(time.Now())
+		if f.ID != 1 || f.T1 > f.T2 || f.T2 > f.T3 || m.Delay < 0 || m.Offset > time.Second || m.Offset < -time.Second {
+			t.Errorf("sync = %+v, measure %+v, want times in order and a small offset", f, m)
+		}
+		return
+	}
+}
+
+func TestHelloErrors(t *testing.T) {
+	for _, hello := range []string{"CLOCK/9\n", "CLOCK/1 zone=Mars/Olympus\n", "CLOCK/1 format=xml\n"} {
+		c := serve(t, newTestServer(t))
+		go io.WriteString(c, hello)
+		line, err := bufio.NewReader(c).ReadString('\n')
+		if err != nil || !strings.Contains(line, `"type":"error"`) {
+			t.Errorf("%q: got %q, %v, want an error frame", hello, line, err)
+		}
+	}
+}
+
+func TestServerFull(t *testing.T) {
+	helloTimeout = 10 * time.Millisecond
+	srv := newTestServer(t)
+	srv.hub.maxClients = 1
+	first := bufio.NewReader(serve(t, srv))
+	if _, err := first.ReadString('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	// A JSON client is told why, a legacy one is only disconnected
+	c := serve(t, srv)
+	go io.WriteString(c, "CLOCK/1\n")
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil || !strings.Contains(line, errHubFull.Error()) {
+		t.Errorf("JSON client: got %q, %v, want an error frame", line, err)
+	}
+	b, err := io.ReadAll(serve(t, srv))
+	if len(b) != 0 || err != nil {
+		t.Errorf("legacy client: got %q, %v, want the connection closed", b, err)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCron(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip(err)
+	}
+	// Monday, 1 May 2023
+	from := time.Date(2023, 5, 1, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", from, time.Date(2023, 5, 1, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2023, 5, 1, 10, 15, 0, 0, time.UTC)},
+		{"5/20 * * * *", from, time.Date(2023, 5, 1, 10, 25, 0, 0, time.UTC)},
+		{"0 9-17 * * 1-5", from, time.Date(2023, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"30 9 * * 6,7", from, time.Date(2023, 5, 6, 9, 30, 0, 0, time.UTC)},
+		{"0 0 1 1 *", from, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// The day of month or the day of week, when both are set
+		{"0 12 15 * 3", from, time.Date(2023, 5, 3, 12, 0, 0, 0, time.UTC)},
+		// 01:30 does not exist on the spring forward, and is skipped
+		{"30 1 * * *", time.Date(2023, 3, 26, 0, 0, 0, 0, london), time.Date(2023, 3, 27, 1, 30, 0, 0, london)},
+	}
+	for _, test := range tests {
+		spec, err := parseCron(test.spec)
+		if err != nil {
+			t.Errorf("parseCron(%q): %v", test.spec, err)
+			continue
+		}
+		if got, ok := spec.next(test.from); !ok || !got.Equal(test.want) {
+			t.Errorf("%q next after %v = %v, %t, want %v", test.spec, test.from, got, ok, test.want)
+		}
+	}
+
+	for _, spec := range []string{"* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "x * * * *", "* * * JAN *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) succeeded", spec)
+		}
+	}
+	spec, _ := parseCron("0 0 30 2 *")
+	if _, ok := spec.next(from); ok {
+		t.Error("February 30 matched")
+	}
+}
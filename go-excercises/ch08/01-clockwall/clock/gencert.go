@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// gencert writes a development CA with a server and a client certificate
+// it signed, so that TLS and mutual TLS work without any other tool:
+//
+//	$ ./clock gencert -hosts localhost,lab1.local,10.0.0.5 -dir certs
+//	$ ./clock -cert certs/server.pem -key certs/server-key.pem -client-ca certs/ca.pem
+//	$ ./clockwall -ca certs/ca.pem -cert certs/client.pem -key certs/client-key.pem Lab=lab1.local:8001
+//
+// The client certificate is named after -client, the only client ID it may
+// keep schedules under.
+// The existing files are never overwritten.
+func gencert(args []string) error {
+	fs := flag.NewFlagSet("gencert", flag.ExitOnError)
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "comma separated host names and IPs of the server certificate")
+	dir := fs.String("dir", ".", "directory to write the certificates and keys to")
+	clientName := fs.String("client", "clockwall", "name of the client certificate, its client ID for the schedules")
+	validFor := fs.Duration("valid-for", 365*24*time.Hour, "validity of the certificates")
+	fs.Parse(args)
+
+	now := time.Now()
+	ca := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "clock dev CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(*validFor),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caKey, caDER, err := newCert(ca, nil, nil)
+	if err != nil {
+		return err
+	}
+	if ca, err = x509.ParseCertificate(caDER); err != nil {
+		return err
+	}
+
+	server := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "clock server"},
+		NotBefore:   ca.NotBefore,
+		NotAfter:    ca.NotAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range strings.Split(*hosts, ",") {
+		if h = strings.TrimSpace(h); h == "" {
+			continue
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			server.IPAddresses = append(server.IPAddresses, ip)
+		} else {
+			server.DNSNames = append(server.DNSNames, h)
+		}
+	}
+	serverKey, serverDER, err := newCert(server, ca, caKey)
+	if err != nil {
+		return err
+	}
+
+	client := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: *clientName},
+		NotBefore:   ca.NotBefore,
+		NotAfter:    ca.NotAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientKey, clientDER, err := newCert(client, ca, caKey)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+	for _, f := range []struct {
+		name string
+		der  []byte
+		key  *ecdsa.PrivateKey
+	}{
+		{"ca", caDER, caKey},
+		{"server", serverDER, serverKey},
+		{"client", clientDER, clientKey},
+	} {
+		if err := writePEM(filepath.Join(*dir, f.name+".pem"), "CERTIFICATE", f.der, 0o644); err != nil {
+			return err
+		}
+		keyDER, err := x509.MarshalECPrivateKey(f.key)
+		if err != nil {
+			return err
+		}
+		if err := writePEM(filepath.Join(*dir, f.name+"-key.pem"), "EC PRIVATE KEY", keyDER, 0o600); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("wrote ca, server and client certificates to %s\n", *dir)
+	return nil
+}
+
+// newCert creates a key and its certificate of the template, signed by the
+// parent, or self-signed if the parent is nil
+func newCert(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	if template.SerialNumber, err = rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128)); err != nil {
+		return nil, nil, err
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	return key, der, err
+}
+
+// writePEM writes a PEM block to a new file
+func writePEM(name, blockType string, der []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	if err := gencert([]string{"-dir", dir, "-hosts", "127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gencert([]string{"-dir", dir}); err == nil {
+		t.Error("gencert overwrote the certificates")
+	}
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	cfg, err := clockproto.ServerTLS(file("server.pem"), file("server-key.pem"), file("ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	srv := newTestServer(t)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.handleConn(c)
+		}
+	}()
+
+	tests := []struct {
+		name          string
+		ca, cert, key string
+		wantErr       bool
+	}{
+		{"client certificate", file("ca.pem"), file("client.pem"), file("client-key.pem"), false},
+		{"no client certificate", file("ca.pem"), "", "", true},
+		{"untrusted server", "", file("client.pem"), file("client-key.pem"), true},
+	}
+	for _, test := range tests {
+		clientCfg, err := clockproto.ClientTLS(test.ca, test.cert, test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := &clockproto.Dialer{Timeout: 5 * time.Second, TLSConfig: clientCfg}
+		conn, err := d.Dial(ln.Addr().String(), clockproto.Hello{})
+		if err == nil {
+			_, err = conn.Next()
+			conn.Close()
+		}
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, want error %t", test.name, err, test.wantErr)
+		}
+	}
+
+	// The client ID is the name of the certificate
+	clientCfg, err := clockproto.ClientTLS(file("ca.pem"), file("client.pem"), file("client-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &clockproto.Dialer{Timeout: 5 * time.Second, TLSConfig: clientCfg}
+	for _, client := range []string{"clockwall", "lab"} {
+		conn, err := d.Dial(ln.Addr().String(), clockproto.Hello{Client: client})
+		if err == nil {
+			_, err = conn.List(nil)
+			conn.Close()
+		}
+		if (err != nil) != (client != "clockwall") {
+			t.Errorf("client %s: error = %v", client, err)
+		}
+	}
+
+	// A plain client gets nothing from a TLS server
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := clockproto.Handshake(c, clockproto.Hello{}); err == nil {
+		t.Error("plain client handshake succeeded")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+// Settings of the hub
+const (
+	hubResolution = clockproto.MinInterval // the period of the ticker, the intervals are rounded to it
+	tickBuffer    = 4                      // ticks queued per client
+	maxDropped    = 10                     // ticks dropped in a row before a slow client is disconnected
+)
+
+// errHubFull rejects the clients over the limit
+var errHubFull = errors.New("server full, try again later")
+
+// subscriber is a client of the hub
+type subscriber struct {
+	ch       chan []byte // the lines of the ticks, closed when the hub drops the client
+	loc      *time.Location
+	format   string
+	interval time.Duration
+	remote   string
+	since    time.Time
+	kick     func() // closes the connection of a client disconnected
+
+	last    time.Time // the slot of the interval of the last tick queued
+	sent    uint64
+	dropped uint64
+	behind  int // ticks dropped in a row
+}
+
+// hub sends the ticks of all the clients from a single ticker aligned to
+// the hubResolution, so that the clients of an interval tick together. The
+// tick line of a zone is formatted once for all its clients, and queued to
+// each of them without blocking: a client too slow to take them loses the
+// ticks, and is disconnected once it loses maxDropped in a row.
+type hub struct {
+	mu         sync.Mutex
+	subs       map[*subscriber]bool
+	maxClients int           // no limit if zero
+	wake       chan struct{} // the first client came
+
+	connections, rejected, slow, sent, dropped uint64
+
+	now func() time.Time // the clock, replaced by the tests
+}
+
+// newHub creates a hub accepting up to maxClients, or any number if zero
+func newHub(maxClients int) *hub {
+	return &hub{
+		subs:       make(map[*subscriber]bool),
+		maxClients: maxClients,
+		wake:       make(chan struct{}, 1),
+		now:        time.Now,
+	}
+}
+
+// subscribe adds a client ticking every interval in the zone and format.
+// Its first tick is up to the caller, the hub sends the next ones. kick
+// must close its connection, so that a write blocked on it returns.
+func (h *hub) subscribe(loc *time.Location, format string, interval time.Duration, remote string, kick func()) (*subscriber, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.maxClients > 0 && len(h.subs) >= h.maxClients {
+		h.rejected++
+		return nil, errHubFull
+	}
+	now := h.now()
+	s := &subscriber{
+		ch:       make(chan []byte, tickBuffer),
+		loc:      loc,
+		format:   format,
+		interval: interval,
+		remote:   remote,
+		since:    now,
+		kick:     kick,
+		last:     now.Truncate(interval),
+	}
+	h.subs[s] = true
+	h.connections++
+	if len(h.subs) == 1 {
+		select {
+		case h.wake <- struct{}{}:
+		default:
+		}
+	}
+	return s, nil
+}
+
+// unsubscribe removes the client
+func (h *hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(s)
+}
+
+// remove drops the client and closes its channel. It is called with the
+// lock held.
+func (h *hub) remove(s *subscriber) {
+	if h.subs[s] {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+}
+
+// run broadcasts the ticks at every hubResolution until done is closed.
+// The hub sleeps while it has no client.
+func (h *hub) run(done <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for {
+		h.mu.Lock()
+		idle := len(h.subs) == 0
+		h.mu.Unlock()
+		if idle {
+			select {
+			case <-done:
+				return
+			case <-h.wake:
+			}
+		}
+
+		now := h.now()
+		slot := now.Truncate(hubResolution).Add(hubResolution)
+		timer.Reset(slot.Sub(now))
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+		h.broadcast(slot, h.now())
+	}
+}
+
+// lineKey identifies the tick line shared by the clients
+type lineKey struct {
+	loc    *time.Location
+	format string
+}
+
+// broadcast queues the tick of now to the clients whose interval starts a
+// new slot at the hub slot
+func (h *hub) broadcast(slot, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	lines := make(map[lineKey][]byte)
+	for s := range h.subs {
+		due := slot.Truncate(s.interval)
+		if !due.After(s.last) {
+			continue
+		}
+		s.last = due
+
+		key := lineKey{s.loc, s.format}
+		line, ok := lines[key]
+		if !ok {
+			line = tickLine(now.In(s.loc), s.format)
+			lines[key] = line
+		}
+		select {
+		case s.ch <- line:
+			s.sent++
+			s.behind = 0
+			h.sent++
+		default:
+			s.dropped++
+			s.behind++
+			h.dropped++
+			if s.behind >= maxDropped {
+				log.Printf("%s: disconnected, too slow to take the ticks", s.remote)
+				h.slow++
+				h.remove(s)
+				go s.kick()
+			}
+		}
+	}
+}
+
+// tickLine formats the tick of the time as a line of the format
+func tickLine(t time.Time, format string) []byte {
+	if format == clockproto.FormatText {
+		return []byte(t.Format(clockproto.TextLayout + "\n"))
+	}
+	b, _ := json.Marshal(clockproto.Tick(t))
+	return append(b, '\n')
+}
+
+// hubStats are the connected clients and the counters of the hub
+type hubStats struct {
+	Clients          int            `json:"clients"`
+	MaxClients       int            `json:"max_clients,omitempty"`
+	Connections      uint64         `json:"connections_total"`
+	Rejected         uint64         `json:"rejected_total"`
+	SlowDisconnected uint64         `json:"slow_disconnected_total"`
+	TicksSent        uint64         `json:"ticks_sent_total"`
+	TicksDropped     uint64         `json:"ticks_dropped_total"`
+	ByZone           map[string]int `json:"by_zone"`
+	ByInterval       map[string]int `json:"by_interval"`
+	ByFormat         map[string]int `json:"by_format"`
+	List             []clientStats  `json:"list,omitempty"`
+}
+
+// clientStats is a connected client
+type clientStats struct {
+	Remote   string `json:"remote"`
+	Zone     string `json:"zone"`
+	Format   string `json:"format"`
+	Interval string `json:"interval"`
+	Since    string `json:"since"`
+	Sent     uint64 `json:"sent"`
+	Dropped  uint64 `json:"dropped"`
+	Queued   int    `json:"queued"`
+}
+
+// stats returns the stats of the hub, with the list of the clients if asked
+func (h *hub) stats(list bool) hubStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := hubStats{
+		Clients:          len(h.subs),
+		MaxClients:       h.maxClients,
+		Connections:      h.connections,
+		Rejected:         h.rejected,
+		SlowDisconnected: h.slow,
+		TicksSent:        h.sent,
+		TicksDropped:     h.dropped,
+		ByZone:           make(map[string]int),
+		ByInterval:       make(map[string]int),
+		ByFormat:         make(map[string]int),
+	}
+	for s := range h.subs {
+		st.ByZone[s.loc.String()]++
+		st.ByInterval[s.interval.String()]++
+		st.ByFormat[s.format]++
+		if list {
+			st.List = append(st.List, clientStats{
+				Remote:   s.remote,
+				Zone:     s.loc.String(),
+				Format:   s.format,
+				Interval: s.interval.String(),
+				Since:    s.since.Format(time.RFC3339),
+				Sent:     s.sent,
+				Dropped:  s.dropped,
+				Queued:   len(s.ch),
+			})
+		}
+	}
+	sort.Slice(st.List, func(i, j int) bool { return st.List[i].Remote < st.List[j].Remote })
+	return st
+}
+
+// ServeHTTP serves the stats as JSON, with the list of the clients given
+// ?list=1
+//
+//	curl "http://localhost:6061/debug/clients?list=1"
+func (h *hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(h.stats(r.URL.Query().Get("list") != ""))
+}
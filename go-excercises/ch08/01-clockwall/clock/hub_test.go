@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+func TestHubBroadcast(t *testing.T) {
+	tokyo, err := loadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip(err)
+	}
+	start := time.Date(2023, 5, 1, 12, 0, 0, 50e6, time.UTC)
+	h := newHub(2)
+	h.now = func() time.Time { return start }
+
+	kicked := make(chan bool, 1)
+	seconds, err := h.subscribe(tokyo, clockproto.FormatJSON, time.Second, "a", func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := h.subscribe(time.UTC, clockproto.FormatText, 200*time.Millisecond, "b", func() { kicked <- true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.subscribe(time.UTC, clockproto.FormatText, time.Second, "c", func() {}); err != errHubFull {
+		t.Errorf("subscribe over the limit: %v, want %v", err, errHubFull)
+	}
+
+	// The slots of the hub up to 12:00:01.0, and the ticks queued
+	var got []string
+	for i := 1; i <= 10; i++ {
+		slot := start.Truncate(hubResolution).Add(time.Duration(i) * hubResolution)
+		h.broadcast(slot, slot.Add(time.Millisecond))
+		for _, s := range []*subscriber{seconds, fast} {
+			select {
+			case line := <-s.ch:
+				got = append(got, s.remote+" "+string(line))
+			default:
+			}
+		}
+	}
+	want := []string{
+		"b 12:00:00\n",
+		"b 12:00:00\n",
+		"b 12:00:00\n",
+		"b 12:00:00\n",
+		`a {"type":"tick","time":"2023-05-01T21:00:01+09:00","zone":"Asia/Tokyo","abbrev":"JST","offset":"+09:00"}` + "\n",
+		"b 12:00:01\n",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ticks = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("tick %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	// The slow client loses the ticks past its buffer, and is dropped
+	for i := 1; i <= (tickBuffer+maxDropped)*2; i++ {
+		slot := start.Add(time.Second).Truncate(hubResolution).Add(time.Duration(i) * hubResolution)
+		h.broadcast(slot, slot)
+		select {
+		case <-seconds.ch: // keeps up
+		default:
+		}
+	}
+	select {
+	case <-kicked:
+	case <-time.After(time.Second):
+		t.Fatal("slow client not kicked")
+	}
+	queued := 0
+	for range fast.ch {
+		queued++
+	}
+	if queued != tickBuffer {
+		t.Errorf("%d ticks queued to the slow client, want %d", queued, tickBuffer)
+	}
+
+	st := h.stats(true)
+	if st.Clients != 1 || st.Rejected != 1 || st.SlowDisconnected != 1 || st.TicksDropped != maxDropped {
+		t.Errorf("stats = %+v, want 1 client, 1 rejected, 1 slow and %d dropped", st, maxDropped)
+	}
+	if st.ByZone["Asia/Tokyo"] != 1 || st.ByInterval["1s"] != 1 || len(st.List) != 1 || st.List[0].Remote != "a" {
+		t.Errorf("stats = %+v, want the client a", st)
+	}
+
+	h.unsubscribe(seconds)
+	h.unsubscribe(seconds)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/clients", nil))
+	var served hubStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if served.Clients != 0 || served.Connections != 2 || served.List != nil {
+		t.Errorf("served stats = %+v, want no client of 2", served)
+	}
+}
+
+func TestHubAligned(t *testing.T) {
+	h := newHub(0)
+	done := make(chan struct{})
+	defer close(done)
+	go h.run(done)
+
+	// Two clients joining apart tick at the same tenth of a second
+	var subs []*subscriber
+	for i := 0; i < 2; i++ {
+		s, err := h.subscribe(time.UTC, clockproto.FormatText, hubResolution, "c", func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer h.unsubscribe(s)
+		subs = append(subs, s)
+		time.Sleep(hubResolution / 3)
+	}
+	<-subs[1].ch
+	for _, s := range subs {
+		for len(s.ch) > 0 {
+			<-s.ch
+		}
+	}
+	for i := 0; i < 3; i++ {
+		<-subs[0].ch
+		at := time.Now()
+		<-subs[1].ch
+		if d := time.Since(at); d > hubResolution/2 {
+			t.Errorf("tick %d of the second client came %s after the first", i, d)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+// Limits of a client
+const (
+	maxSchedules = 100 // schedules registered at once
+	maxPending   = 100 // notifications kept while no connection is open
+	maxTimer     = 366 * 24 * time.Hour
+
+	notifyTimeout = 10 * time.Second // to write a notification to a connection
+)
+
+// maxClientIDs is the number of client IDs kept with schedules or pending
+// notifications, so that the saved clients are bounded as well
+const maxClientIDs = 10000
+
+// clientState is what the server keeps of a client ID. The schedules and
+// the pending notifications are saved, the connections are not.
+type clientState struct {
+	Schedules []clockproto.Schedule `json:"schedules,omitempty"`
+	Pending   []clockproto.Frame    `json:"pending,omitempty"`
+
+	conns map[*frameWriter]bool
+}
+
+// scheduler fires the schedules of all the clients from a single goroutine
+type scheduler struct {
+	mu         sync.Mutex
+	clients    map[string]*clientState
+	file       string // where the clients are saved, in memory if empty
+	defaultLoc *time.Location
+	wake       chan struct{} // the schedules changed
+
+	now func() time.Time // the clock, replaced by the tests
+}
+
+// newScheduler creates a scheduler, loading the clients of the file if it exists
+func newScheduler(file string, defaultLoc *time.Location) (*scheduler, error) {
+	s := &scheduler{
+		clients:    make(map[string]*clientState),
+		file:       file,
+		defaultLoc: defaultLoc,
+		wake:       make(chan struct{}, 1),
+		now:        time.Now,
+	}
+	if file == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &s.clients); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return s, nil
+}
+
+// save writes the clients to the file, through a temporary file so that a
+// crash never leaves it half written. It is called with the lock held.
+func (s *scheduler) save() {
+	if s.file == "" {
+		return
+	}
+	b, err := json.MarshalIndent(s.clients, "", "  ")
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(s.file), "."+filepath.Base(s.file)+".tmp")
+		if err = os.WriteFile(tmp, b, 0o600); err == nil {
+			err = os.Rename(tmp, s.file)
+		}
+	}
+	if err != nil {
+		log.Printf("saving the schedules: %v", err)
+	}
+}
+
+// client returns the state of the client ID, created if needed. It is
+// called with the lock held.
+func (s *scheduler) client(id string) *clientState {
+	c, ok := s.clients[id]
+	if !ok {
+		c = &clientState{}
+		s.clients[id] = c
+	}
+	if c.conns == nil {
+		c.conns = make(map[*frameWriter]bool)
+	}
+	return c
+}
+
+// kept reports whether the client has something to save
+func (c *clientState) kept() bool {
+	return len(c.Schedules) > 0 || len(c.Pending) > 0
+}
+
+// keptClients returns the number of clients with something to save. It is
+// called with the lock held.
+func (s *scheduler) keptClients() int {
+	n := 0
+	for _, c := range s.clients {
+		if c.kept() {
+			n++
+		}
+	}
+	return n
+}
+
+// forget drops the client when nothing is left of it. It is called with
+// the lock held.
+func (s *scheduler) forget(id string) {
+	if c := s.clients[id]; c != nil && !c.kept() && len(c.conns) == 0 {
+		delete(s.clients, id)
+	}
+}
+
+// location returns the time zone of a schedule
+func (s *scheduler) location(sch clockproto.Schedule) (*time.Location, error) {
+	if sch.Zone == "" {
+		return s.defaultLoc, nil
+	}
+	return loadLocation(sch.Zone)
+}
+
+// first returns the first time a new schedule fires after now
+func (s *scheduler) first(sch clockproto.Schedule, now time.Time) (time.Time, error) {
+	loc, err := s.location(sch)
+	if err != nil {
+		return time.Time{}, err
+	}
+	now = now.In(loc)
+
+	switch sch.Kind {
+	case clockproto.KindTimer:
+		d, err := time.ParseDuration(sch.After)
+		if err != nil || d <= 0 || d > maxTimer {
+			return time.Time{}, fmt.Errorf("timer needs a duration up to %s, such as 25m", maxTimer)
+		}
+		// Rounded up to the second, as the times are saved to the second
+		return now.Add(d + time.Second - 1).Truncate(time.Second), nil
+
+	case clockproto.KindAlarm:
+		for i, layout := range clockproto.AlarmLayouts {
+			t, err := time.ParseInLocation(layout, sch.At, loc)
+			if err != nil {
+				continue
+			}
+			if i < 2 {
+				// The next time of the day
+				t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+				if !t.After(now) {
+					t = time.Date(now.Year(), now.Month(), now.Day()+1, t.Hour(), t.Minute(), t.Second(), 0, loc)
+				}
+			} else if !t.After(now) {
+				return time.Time{}, fmt.Errorf("alarm time %s is past", sch.At)
+			}
+			return t, nil
+		}
+		return time.Time{}, fmt.Errorf("alarm needs a time such as 07:30 or 2023-05-01T07:30")
+
+	case clockproto.KindCron:
+		spec, err := parseCron(sch.Cron)
+		if err != nil {
+			return time.Time{}, err
+		}
+		t, ok := spec.next(now)
+		if !ok {
+			return time.Time{}, fmt.Errorf("cron spec %q never matches", sch.Cron)
+		}
+		return t, nil
+
+	default:
+		return time.Time{}, fmt.Errorf("unknown schedule kind %q", sch.Kind)
+	}
+}
+
+// add registers the schedule of the client, replacing the one of the same
+// name, and returns it with its next time
+func (s *scheduler) add(client string, sch clockproto.Schedule) (clockproto.Schedule, error) {
+	if !clockproto.ValidName(sch.Name) {
+		return sch, fmt.Errorf("invalid schedule name %q", sch.Name)
+	}
+	next, err := s.first(sch, s.now())
+	if err != nil {
+		return sch, err
+	}
+	sch.Next = next.Format(time.RFC3339)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.client(client)
+	replaced := false
+	for i := range c.Schedules {
+		if c.Schedules[i].Name == sch.Name {
+			c.Schedules[i], replaced = sch, true
+		}
+	}
+	if !replaced {
+		if len(c.Schedules) >= maxSchedules {
+			s.forget(client)
+			return sch, fmt.Errorf("too many schedules, the limit is %d", maxSchedules)
+		}
+		if !c.kept() && s.keptClients() >= maxClientIDs {
+			s.forget(client)
+			return sch, fmt.Errorf("too many clients, the limit is %d", maxClientIDs)
+		}
+		c.Schedules = append(c.Schedules, sch)
+	}
+	s.save()
+	s.poke()
+	return sch, nil
+}
+
+// cancel removes the schedule of the client
+func (s *scheduler) cancel(client, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.clients[client]; c != nil {
+		for i := range c.Schedules {
+			if c.Schedules[i].Name == name {
+				c.Schedules = append(c.Schedules[:i], c.Schedules[i+1:]...)
+				s.forget(client)
+				s.save()
+				s.poke()
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no schedule %q", name)
+}
+
+// list returns the schedules of the client
+func (s *scheduler) list(client string) []clockproto.Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.clients[client]; c != nil {
+		return append([]clockproto.Schedule(nil), c.Schedules...)
+	}
+	return nil
+}
+
+// attach sends the notifications of the client to the connection, and
+// returns those pending since the last one closed
+func (s *scheduler) attach(client string, w *frameWriter) []clockproto.Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.client(client)
+	c.conns[w] = true
+	pending := c.Pending
+	if len(pending) > 0 {
+		c.Pending = nil
+		s.save()
+	}
+	return pending
+}
+
+// detach stops sending the notifications to the connection. Those it
+// failed to write and those still queued to it are pending again, before
+// the ones pending meanwhile which came later.
+func (s *scheduler) detach(client string, w *frameWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.clients[client]
+	if c == nil {
+		return
+	}
+	delete(c.conns, w)
+	back := append([]clockproto.Frame(nil), w.unsent...)
+	for len(w.notes) > 0 {
+		back = append(back, <-w.notes)
+	}
+	if len(back) > 0 {
+		pending := append(back, c.Pending...)
+		if len(pending) > maxPending {
+			pending = pending[len(pending)-maxPending:]
+		}
+		c.Pending = pending
+		s.save()
+	}
+	s.forget(client)
+}
+
+// poke wakes the scheduler up to look at the changed schedules
+func (s *scheduler) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run fires the schedules as they come due, until done is closed
+func (s *scheduler) run(done <-chan struct{}) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+		next := s.fire(s.now())
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(next.Sub(s.now()))
+		}
+	}
+}
+
+// fire notifies the clients of their schedules due at now, and returns the
+// time of the next one, or zero if there is none. The alarms and timers
+// are removed once fired, and the cron schedules move to their next time.
+// A schedule missed while the server was down fires late, once.
+func (s *scheduler) fire(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	changed := false
+	for id, c := range s.clients {
+		kept := c.Schedules[:0]
+		for _, sch := range c.Schedules {
+			due, err := time.Parse(time.RFC3339, sch.Next)
+			if err == nil && due.After(now) {
+				kept = append(kept, sch)
+				if next.IsZero() || due.Before(next) {
+					next = due
+				}
+				continue
+			}
+
+			changed = true
+			if err == nil {
+				fired := sch
+				s.notify(c, clockproto.Frame{Type: clockproto.FrameNotify, Time: due.Format(time.RFC3339), Schedule: &fired})
+			}
+			if sch.Kind != clockproto.KindCron {
+				continue
+			}
+			t, err := s.first(sch, now)
+			if err != nil {
+				log.Printf("dropping the schedule %q of %s: %v", sch.Name, id, err)
+				continue
+			}
+			sch.Next = t.Format(time.RFC3339)
+			kept = append(kept, sch)
+			if next.IsZero() || t.Before(next) {
+				next = t
+			}
+		}
+		c.Schedules = kept
+		s.forget(id)
+	}
+	if changed {
+		s.save()
+	}
+	return next
+}
+
+// notify queues the notification to the connections of the client, which
+// write it with their ticks, or keeps it for the next one when none can
+// take it. It is called with the lock held, so it never blocks.
+func (s *scheduler) notify(c *clientState, f clockproto.Frame) {
+	queued := false
+	for w := range c.conns {
+		select {
+		case w.notes <- f:
+			queued = true
+		default:
+		}
+	}
+	if queued {
+		return
+	}
+	if len(c.Pending) >= maxPending {
+		c.Pending = c.Pending[1:]
+	}
+	c.Pending = append(c.Pending, f)
+}
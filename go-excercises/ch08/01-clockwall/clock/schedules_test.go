@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+func TestScheduleRequests(t *testing.T) {
+	srv := newTestServer(t)
+	conn, err := clockproto.Handshake(serve(t, srv), clockproto.Hello{Client: "lab", Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := conn.Add(clockproto.Schedule{Name: "standup", Kind: clockproto.KindCron, Cron: "30 9 * * *"}, nil)
+	if err != nil || added.Next == "" {
+		t.Fatalf("Add = %+v, %v, want the next time", added, err)
+	}
+	bad := []clockproto.Schedule{
+		{Name: "x", Kind: clockproto.KindAlarm, At: "25:00"},
+		{Name: "x", Kind: clockproto.KindAlarm, At: "2000-01-01T10:00"},
+		{Name: "x", Kind: clockproto.KindTimer, After: "-1m"},
+		{Name: "x", Kind: clockproto.KindCron, Cron: "* *"},
+		{Name: "x", Kind: clockproto.KindAlarm, At: "07:30", Zone: "Mars/Olympus"},
+		{Name: "x", Kind: "reminder"},
+		{Name: "a b", Kind: clockproto.KindTimer, After: "1m"},
+	}
+	for _, sch := range bad {
+		if _, err := conn.Add(sch, nil); err == nil {
+			t.Errorf("Add(%+v) succeeded", sch)
+		}
+	}
+
+	// The schedules are kept for the client ID, not the connection
+	conn.Close()
+	conn, err = clockproto.Handshake(serve(t, srv), clockproto.Hello{Client: "lab", Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := conn.List(nil)
+	if err != nil || len(list) != 1 || list[0].Name != "standup" {
+		t.Errorf("List = %+v, %v, want standup", list, err)
+	}
+	if err := conn.Cancel("standup", nil); err != nil {
+		t.Errorf("Cancel: %v", err)
+	}
+	if err := conn.Cancel("standup", nil); err == nil {
+		t.Error("Cancel of a missing schedule succeeded")
+	}
+
+	// The schedules need a client ID
+	anon, err := clockproto.Handshake(serve(t, srv), clockproto.Hello{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anon.List(nil); err == nil {
+		t.Error("List without a client ID succeeded")
+	}
+}
+
+func TestScheduleFire(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "schedules.json")
+	sched, err := newScheduler(file, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	sched.now = func() time.Time { return now }
+
+	for _, sch := range []clockproto.Schedule{
+		{Name: "tea", Kind: clockproto.KindTimer, After: "4m"},
+		{Name: "wake", Kind: clockproto.KindAlarm, At: "07:30"},
+		{Name: "hourly", Kind: clockproto.KindCron, Cron: "0 * * * *"},
+	} {
+		if _, err := sched.add("lab", sch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing is due yet, and the next one is the timer
+	if next := sched.fire(now); !next.Equal(now.Add(4 * time.Minute)) {
+		t.Errorf("next = %v, want the timer in 4m", next)
+	}
+
+	// With no connection, the notifications are pending. The timer is gone
+	// and the cron schedule moved to the next hour.
+	now = now.Add(time.Hour)
+	sched.fire(now)
+	if list := sched.list("lab"); len(list) != 2 || list[1].Next != "2023-05-01T12:00:00Z" {
+		t.Errorf("schedules after firing = %+v, want wake and hourly at 12:00", list)
+	}
+
+	// The schedules and the pending notifications survive a restart
+	sched, err = newScheduler(file, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := sched.attach("lab", &frameWriter{})
+	if len(pending) != 2 || pending[0].Schedule == nil || pending[0].Schedule.Name != "tea" {
+		t.Fatalf("pending = %+v, want tea and hourly", pending)
+	}
+	if pending[0].Time != "2023-05-01T10:04:00Z" || pending[1].Time != "2023-05-01T11:00:00Z" {
+		t.Errorf("pending times = %s, %s, want 10:04 and 11:00", pending[0].Time, pending[1].Time)
+	}
+	if again := sched.attach("lab", &frameWriter{}); len(again) != 0 {
+		t.Errorf("pending delivered twice: %+v", again)
+	}
+}
+
+func TestScheduleUnsent(t *testing.T) {
+	sched, err := newScheduler("", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	sched.now = func() time.Time { return now }
+	sched.add("lab", clockproto.Schedule{Name: "tea", Kind: clockproto.KindTimer, After: "4m"})
+	sched.add("lab", clockproto.Schedule{Name: "break", Kind: clockproto.KindTimer, After: "5m"})
+
+	// The notifications are queued to the connection, not written by the
+	// scheduler, so a stalled client blocks nothing
+	client, conn := net.Pipe()
+	defer client.Close()
+	w := &frameWriter{w: conn, notes: make(chan clockproto.Frame, maxPending)}
+	sched.attach("lab", w)
+	now = now.Add(time.Hour)
+	sched.fire(now)
+	if len(w.notes) != 2 {
+		t.Fatalf("%d notifications queued, want 2", len(w.notes))
+	}
+
+	// A write not taken by the deadline fails, and the notification is
+	// pending again with the one still queued
+	f := <-w.notes
+	if err := w.writeBy(f, time.Now().Add(50*time.Millisecond)); err == nil {
+		t.Fatal("write to a stalled client succeeded")
+	}
+	w.unsent = append(w.unsent, f)
+	sched.detach("lab", w)
+	pending := sched.attach("lab", &frameWriter{})
+	if len(pending) != 2 || pending[0].Schedule.Name != "tea" || pending[1].Schedule.Name != "break" {
+		t.Errorf("pending = %+v, want tea and break", pending)
+	}
+}
+
+func TestScheduleClientLimit(t *testing.T) {
+	sched, err := newScheduler("", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timer := clockproto.Schedule{Name: "tea", Kind: clockproto.KindTimer, After: "1m"}
+	for i := 0; i < maxClientIDs; i++ {
+		if _, err := sched.add(fmt.Sprint("lab", i), timer); err != nil {
+			t.Fatalf("add for client %d: %v", i, err)
+		}
+	}
+
+	// A new client is refused, while the known ones still add schedules
+	if _, err := sched.add("intruder", timer); err == nil {
+		t.Error("added the schedule of a client over the limit")
+	}
+	if _, ok := sched.clients["intruder"]; ok {
+		t.Error("client over the limit kept")
+	}
+	if _, err := sched.add("lab0", clockproto.Schedule{Name: "break", Kind: clockproto.KindTimer, After: "1m"}); err != nil {
+		t.Errorf("add for a known client: %v", err)
+	}
+
+	// The pending notifications keep the place of a client after its schedules fire
+	sched.fire(time.Now().Add(time.Hour))
+	if _, err := sched.add("intruder", timer); err == nil {
+		t.Error("added the schedule of a client over the limit after the schedules fired")
+	}
+}
@@ -0,0 +1,220 @@
+/*
+clockctl manages the alarms, timers and cron schedules kept by a clock server for a client ID
+
+	clockctl alarm wake-up 07:30 Asia/Kolkata
+	clockctl alarm launch 2023-06-01T09:00 America/New_York
+	clockctl timer tea 4m
+	clockctl cron standup "30 9 * * 1-5" Europe/London
+	clockctl list
+	clockctl cancel tea
+	clockctl watch
+
+The schedules fire as notifications on the connections of the client ID,
+which watch prints as they come. The server, the client ID and the TLS files
+can also be set in the environment with CLOCK_SERVER, CLOCK_CLIENT, CLOCK_CA,
+CLOCK_CERT and CLOCK_KEY.
+*/
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+const usage = `Usage: clockctl [flags] command [args]
+
+Commands:
+  alarm NAME TIME [ZONE]       fire once at the time of the day, or date and time
+  timer NAME DURATION          fire once after the duration, such as 25m
+  cron NAME SPEC [ZONE]        fire at the minutes matching the cron spec
+  list                         list the schedules
+  cancel NAME                  remove a schedule
+  watch                        print the notifications until interrupted
+
+Flags:
+`
+
+// env returns the environment variable, or def if it is not set
+func env(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	hostname, _ := os.Hostname()
+	server := flag.String("server", env("CLOCK_SERVER", "localhost:8001"), "address of the clock server")
+	client := flag.String("client", env("CLOCK_CLIENT", hostname), "client ID keeping the schedules")
+	useTLS := flag.Bool("tls", false, "connect with TLS, verified with the system CAs unless -ca is set")
+	caFile := flag.String("ca", env("CLOCK_CA", ""), "PEM CA to verify the server with (implies -tls)")
+	certFile := flag.String("cert", env("CLOCK_CERT", ""), "PEM client certificate to present (implies -tls)")
+	keyFile := flag.String("key", env("CLOCK_KEY", ""), "PEM key of the client certificate")
+	output := flag.String("o", "table", "output format: table or json")
+	timeout := flag.Duration("timeout", 10*time.Second, "maximum time for the command, but watch")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		fatal(fmt.Errorf("unknown output format: %s", *output))
+	}
+	if !clockproto.ValidName(*client) {
+		fatal(fmt.Errorf("invalid client ID %q, set one with -client", *client))
+	}
+
+	dialer := &clockproto.Dialer{Timeout: *timeout}
+	if *useTLS || *caFile != "" || *certFile != "" || *keyFile != "" {
+		cfg, err := clockproto.ClientTLS(*caFile, *certFile, *keyFile)
+		if err != nil {
+			fatal(err)
+		}
+		dialer.TLSConfig = cfg
+	}
+
+	// The ticks are not used, so they come as rarely as possible
+	hello := clockproto.Hello{Client: *client, Interval: clockproto.MaxInterval}
+	conn, err := dialer.Dial(*server, hello)
+	if err != nil {
+		fatal(err)
+	}
+	defer conn.Close()
+	if flag.Arg(0) != "watch" {
+		conn.SetDeadline(time.Now().Add(*timeout))
+	}
+
+	cmd := &command{conn: conn, out: os.Stdout, json: *output == "json"}
+	if err := cmd.run(flag.Arg(0), flag.Args()[1:]); err != nil {
+		fatal(err)
+	}
+}
+
+// fatal prints the error and exits with status 1
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "clockctl: %v\n", err)
+	os.Exit(1)
+}
+
+// command runs the subcommands on a connection
+type command struct {
+	conn *clockproto.Conn
+	out  io.Writer
+	json bool // print JSON instead of tables
+}
+
+// run parses the arguments of the subcommand and runs it. The
+// notifications received meanwhile are printed as well.
+func (c *command) run(name string, args []string) error {
+	switch name {
+	case "alarm", "cron":
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("usage: clockctl %s NAME %s [ZONE]", name, map[string]string{"alarm": "TIME", "cron": "SPEC"}[name])
+		}
+		sch := clockproto.Schedule{Name: args[0], Kind: name}
+		if name == "alarm" {
+			sch.At = args[1]
+		} else {
+			sch.Cron = args[1]
+		}
+		if len(args) == 3 {
+			sch.Zone = args[2]
+		}
+		return c.add(sch)
+
+	case "timer":
+		if len(args) != 2 {
+			return errors.New("usage: clockctl timer NAME DURATION")
+		}
+		return c.add(clockproto.Schedule{Name: args[0], Kind: clockproto.KindTimer, After: args[1]})
+
+	case "list":
+		if len(args) != 0 {
+			return errors.New("usage: clockctl list")
+		}
+		list, err := c.conn.List(c.notify)
+		if err != nil {
+			return err
+		}
+		return c.printSchedules(list)
+
+	case "cancel":
+		if len(args) != 1 {
+			return errors.New("usage: clockctl cancel NAME")
+		}
+		return c.conn.Cancel(args[0], c.notify)
+
+	case "watch":
+		if len(args) != 0 {
+			return errors.New("usage: clockctl watch")
+		}
+		for {
+			f, err := c.conn.Next()
+			if err != nil {
+				return err
+			}
+			if f.Type == clockproto.FrameNotify {
+				c.notify(f)
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown command: %s", name)
+	}
+}
+
+// add registers the schedule and prints it with its next time
+func (c *command) add(sch clockproto.Schedule) error {
+	added, err := c.conn.Add(sch, c.notify)
+	if err != nil {
+		return err
+	}
+	return c.printSchedules([]clockproto.Schedule{added})
+}
+
+// notify prints a notification, as a line of JSON with -o json
+func (c *command) notify(f clockproto.Frame) {
+	if c.json {
+		json.NewEncoder(c.out).Encode(f)
+		return
+	}
+	if f.Schedule != nil {
+		fmt.Fprintf(c.out, "%s  %s %s fired\n", f.Time, f.Schedule.Kind, f.Schedule.Name)
+	}
+}
+
+// printSchedules prints the schedules as a table, or as JSON
+func (c *command) printSchedules(list []clockproto.Schedule) error {
+	if c.json {
+		if list == nil {
+			list = []clockproto.Schedule{}
+		}
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tKIND\tWHEN\tZONE\tNEXT")
+	for _, s := range list {
+		when := s.At + s.After + s.Cron // only one is set
+		zone := s.Zone
+		if zone == "" && s.Kind != clockproto.KindTimer {
+			zone = "(server)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", s.Name, s.Kind, when, zone, s.Next)
+	}
+	return tw.Flush()
+}
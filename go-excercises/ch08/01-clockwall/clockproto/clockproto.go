@@ -0,0 +1,440 @@
+/*
+Package clockproto is the line protocol between the clock servers and their clients
+
+A client starts by sending a hello line naming the protocol version, the time
+zone, the format of the frames and the interval between the ticks. All the
+options are optional:
+
+	CLOCK/1 zone=Asia/Kolkata format=json interval=1s
+
+The server answers with a welcome frame, or an error frame before closing the
+connection, and then streams a tick frame per interval. The JSON frames are
+a line each:
+
+	{"type":"welcome","version":1,"zone":"Asia/Kolkata","interval":"1s"}
+	{"type":"tick","time":"2023-05-01T18:30:00+05:30","zone":"Asia/Kolkata","abbrev":"IST","offset":"+05:30"}
+
+With format=text, the ticks are the plain "15:04:05" lines of the legacy
+clients, which send no hello at all and get the default zone of the server.
+
+After the hello, a JSON client can measure the offset of the server clock as
+NTP does. It sends a sync request with an ID and its time t1 in Unix
+nanoseconds, and the server answers with a sync frame adding the times t2
+and t3 it received the request and sent the answer:
+
+	SYNC 7 1682965800000000000
+	{"type":"sync","id":7,"t1":1682965800000000000,"t2":1682965800000150000,"t3":1682965800000160000}
+
+With the time t4 the client received the answer, the round trip delay is
+(t4-t1)-(t3-t2) and the server clock is ahead by ((t2-t1)+(t3-t4))/2 (see
+Frame.Sample).
+
+A client naming itself in the hello with client=ID can register alarms,
+timers and cron schedules, kept by the server for the ID across the
+connections. The requests carry an ID repeated by their reply frame:
+
+	ADD 8 {"name":"standup","kind":"cron","cron":"30 9 * * 1-5","zone":"Europe/London"}
+	{"type":"reply","id":8,"schedules":[{"name":"standup","kind":"cron",...,"next":"2023-05-02T09:30:00+01:00"}]}
+	LIST 9
+	CANCEL 10 standup
+
+The server pushes a notify frame when a schedule fires, or on the next
+connection of the client if none is open:
+
+	{"type":"notify","time":"2023-05-02T09:30:00+01:00","schedule":{"name":"standup",...}}
+*/
+package clockproto
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the protocol version spoken by this package
+const Version = 1
+
+// magic starts every hello line, followed by "/" and the version
+const magic = "CLOCK"
+
+// Frame formats
+const (
+	FormatJSON = "json" // a JSON frame per line
+	FormatText = "text" // a "15:04:05" line per tick, as the legacy protocol
+)
+
+// TextLayout is the time layout of the text format
+const TextLayout = "15:04:05"
+
+// Bounds of the tick interval
+const (
+	DefaultInterval = time.Second
+	MinInterval     = 100 * time.Millisecond
+	MaxInterval     = time.Hour
+)
+
+// Hello is the first line sent by a client
+type Hello struct {
+	Version  int
+	Zone     string        // IANA time zone name, the server default if empty
+	Format   string        // FormatJSON or FormatText, FormatJSON if empty
+	Interval time.Duration // DefaultInterval if zero
+	Client   string        // the ID keeping the schedules, none if empty
+}
+
+// String formats the hello line without the newline
+func (h Hello) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s/%d", magic, h.Version)
+	if h.Zone != "" {
+		fmt.Fprintf(&b, " zone=%s", h.Zone)
+	}
+	if h.Format != "" {
+		fmt.Fprintf(&b, " format=%s", h.Format)
+	}
+	if h.Interval != 0 {
+		fmt.Fprintf(&b, " interval=%s", h.Interval)
+	}
+	if h.Client != "" {
+		fmt.Fprintf(&b, " client=%s", h.Client)
+	}
+	return b.String()
+}
+
+// ErrNotHello reports a first line that is not a hello
+var ErrNotHello = errors.New("not a clock hello")
+
+// ParseHello parses a hello line and fills in the defaults. It checks the
+// options, but not whether the zone exists.
+func ParseHello(line string) (Hello, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], magic+"/") {
+		return Hello{}, ErrNotHello
+	}
+	h := Hello{Format: FormatJSON, Interval: DefaultInterval}
+	if _, err := fmt.Sscanf(fields[0][len(magic)+1:], "%d", &h.Version); err != nil {
+		return Hello{}, fmt.Errorf("invalid version %q", fields[0])
+	}
+	if h.Version != Version {
+		return Hello{}, fmt.Errorf("unsupported version %d, the server speaks %d", h.Version, Version)
+	}
+
+	for _, f := range fields[1:] {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			return Hello{}, fmt.Errorf("invalid option %q", f)
+		}
+		switch key {
+		case "zone":
+			h.Zone = value
+		case "client":
+			if !ValidName(value) {
+				return Hello{}, fmt.Errorf("invalid client ID %q", value)
+			}
+			h.Client = value
+		case "format":
+			if value != FormatJSON && value != FormatText {
+				return Hello{}, fmt.Errorf("unknown format %q", value)
+			}
+			h.Format = value
+		case "interval":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return Hello{}, fmt.Errorf("invalid interval %q", value)
+			}
+			if d < MinInterval || d > MaxInterval {
+				return Hello{}, fmt.Errorf("interval must be between %s and %s", MinInterval, MaxInterval)
+			}
+			h.Interval = d
+		default:
+			return Hello{}, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return h, nil
+}
+
+// Request verbs of the clients, followed by the request ID
+const (
+	CmdSync   = "SYNC"   // SYNC <id> <t1>
+	CmdAdd    = "ADD"    // ADD <id> <schedule JSON>, replacing the one of the same name
+	CmdCancel = "CANCEL" // CANCEL <id> <name>
+	CmdList   = "LIST"   // LIST <id>
+)
+
+// ParseRequest splits a request line into its verb, its ID and the rest
+func ParseRequest(line string) (verb string, id uint64, arg string, err error) {
+	verb, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	idStr, arg, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	if id, err = strconv.ParseUint(idStr, 10, 64); err != nil {
+		return "", 0, "", fmt.Errorf("invalid request ID %q", idStr)
+	}
+	return verb, id, strings.TrimSpace(arg), nil
+}
+
+// SyncRequest formats the sync request line, without the newline
+func SyncRequest(id uint64, t1 time.Time) string {
+	return fmt.Sprintf("%s %d %d", CmdSync, id, t1.UnixNano())
+}
+
+// ParseSync parses a sync request line
+func ParseSync(line string) (id uint64, t1 int64, err error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != CmdSync {
+		return 0, 0, fmt.Errorf("invalid sync request %q", line)
+	}
+	if id, err = strconv.ParseUint(fields[1], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid sync id %q", fields[1])
+	}
+	if t1, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+		return 0, 0, fmt.Errorf("invalid sync time %q", fields[2])
+	}
+	return id, t1, nil
+}
+
+// Frame types
+const (
+	FrameWelcome = "welcome"
+	FrameTick    = "tick"
+	FrameSync    = "sync"
+	FrameReply   = "reply"
+	FrameNotify  = "notify"
+	FrameError   = "error"
+)
+
+// Frame is a message of the server. The fields are set by the type.
+type Frame struct {
+	Type     string `json:"type"`
+	Version  int    `json:"version,omitempty"`  // welcome
+	Interval string `json:"interval,omitempty"` // welcome
+	Time     string `json:"time,omitempty"`     // tick, RFC 3339
+	Zone     string `json:"zone,omitempty"`     // welcome and tick
+	Abbrev   string `json:"abbrev,omitempty"`   // tick, such as IST
+	Offset   string `json:"offset,omitempty"`   // tick, such as +05:30
+	ID       uint64 `json:"id,omitempty"`       // sync
+	T1       int64  `json:"t1,omitempty"`       // sync, Unix nanoseconds of the client
+	T2       int64  `json:"t2,omitempty"`       // sync, Unix nanoseconds of the server
+	T3       int64  `json:"t3,omitempty"`       // sync, Unix nanoseconds of the server
+	Error    string `json:"error,omitempty"`    // error, and reply when the request failed
+
+	Schedules []Schedule `json:"schedules,omitempty"` // reply
+	Schedule  *Schedule  `json:"schedule,omitempty"`  // notify, with the time it fired
+}
+
+// Sample is a measure of the server clock
+type Sample struct {
+	Delay  time.Duration // round trip of the request, less the server time
+	Offset time.Duration // how far the server clock is ahead
+}
+
+// Sample measures the server clock with a sync frame received at t4
+func (f Frame) Sample(t4 time.Time) Sample {
+	t1, t2, t3, t4n := f.T1, f.T2, f.T3, t4.UnixNano()
+	return Sample{
+		Delay:  time.Duration((t4n - t1) - (t3 - t2)),
+		Offset: time.Duration(((t2 - t1) + (t3 - t4n)) / 2),
+	}
+}
+
+// Tick creates the tick frame of the time in its location
+func Tick(t time.Time) Frame {
+	abbrev, _ := t.Zone()
+	return Frame{
+		Type:   FrameTick,
+		Time:   t.Format(time.RFC3339),
+		Zone:   t.Location().String(),
+		Abbrev: abbrev,
+		Offset: t.Format("-07:00"),
+	}
+}
+
+// ParseTime parses the time of a tick frame
+func (f Frame) ParseTime() (time.Time, error) {
+	return time.Parse(time.RFC3339, f.Time)
+}
+
+// Conn is the client side of a clock connection
+type Conn struct {
+	net.Conn
+	r      *bufio.Reader
+	Hello  Hello
+	Legacy bool // the server sends text lines and takes no requests
+
+	wmu    sync.Mutex // serializes the requests
+	lastID uint64
+}
+
+// Dialer connects to the clock servers
+type Dialer struct {
+	// Timeout fails the connection and the handshake taking longer, if not zero
+	Timeout time.Duration
+
+	// TLSConfig secures the connections, which are plain if nil. The server
+	// name is taken from the address when not set.
+	TLSConfig *tls.Config
+}
+
+// Dial connects to the clock server and sends the hello. The version is
+// always Version.
+func (d *Dialer) Dial(addr string, h Hello) (*Conn, error) {
+	nd := &net.Dialer{Timeout: d.Timeout}
+	var nc net.Conn
+	var err error
+	if d.TLSConfig != nil {
+		nc, err = tls.DialWithDialer(nd, "tcp", addr, d.TLSConfig)
+	} else {
+		nc, err = nd.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.Timeout > 0 {
+		nc.SetDeadline(time.Now().Add(d.Timeout))
+	}
+	c, err := Handshake(nc, h)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	return c, nil
+}
+
+// Dial connects to the clock server in plain TCP and sends the hello
+func Dial(addr string, h Hello) (*Conn, error) {
+	return (&Dialer{}).Dial(addr, h)
+}
+
+// Handshake sends the hello on an open connection and reads the welcome.
+// A legacy server ignores the hello and streams text lines, which Next
+// turns into tick frames without a zone.
+func Handshake(nc net.Conn, h Hello) (*Conn, error) {
+	h.Version = Version
+	if _, err := fmt.Fprintf(nc, "%s\n", h); err != nil {
+		return nil, err
+	}
+	c := &Conn{Conn: nc, r: bufio.NewReader(nc), Hello: h}
+	if h.Format == FormatText {
+		return c, nil
+	}
+
+	b, err := c.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != '{' {
+		c.Legacy = true
+		return c, nil
+	}
+	f, err := c.Next()
+	if err != nil {
+		return nil, err
+	}
+	switch f.Type {
+	case FrameWelcome:
+		return c, nil
+	case FrameError:
+		return nil, fmt.Errorf("clock server: %s", f.Error)
+	default:
+		return nil, fmt.Errorf("clock server: unexpected %q frame", f.Type)
+	}
+}
+
+// Next reads the next frame. The text lines are returned as tick frames
+// with only the time, in the TextLayout.
+func (c *Conn) Next() (Frame, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return Frame{}, err
+	}
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		return Frame{Type: FrameTick, Time: line}, nil
+	}
+	var f Frame
+	if err := json.Unmarshal([]byte(line), &f); err != nil {
+		return Frame{}, fmt.Errorf("invalid frame: %v", err)
+	}
+	return f, nil
+}
+
+// Sync sends a sync request with a new ID and returns it. Its answer is a
+// sync frame read by Next.
+func (c *Conn) Sync() (uint64, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.lastID++
+	_, err := fmt.Fprintf(c.Conn, "%s\n", SyncRequest(c.lastID, time.Now()))
+	return c.lastID, err
+}
+
+// Request sends a request with a new ID and returns it. Its answer is a
+// reply frame with the ID read by Next.
+func (c *Conn) Request(verb, arg string) (uint64, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.lastID++
+	_, err := fmt.Fprintf(c.Conn, "%s %d %s\n", verb, c.lastID, arg)
+	return c.lastID, err
+}
+
+// Call sends a request and reads the frames until its reply, for the
+// clients reading no frames otherwise. The notifications are passed to
+// notify if not nil, and the other frames are skipped.
+func (c *Conn) Call(verb, arg string, notify func(Frame)) (Frame, error) {
+	id, err := c.Request(verb, arg)
+	if err != nil {
+		return Frame{}, err
+	}
+	for {
+		f, err := c.Next()
+		if err != nil {
+			return Frame{}, err
+		}
+		switch {
+		case f.Type == FrameReply && f.ID == id:
+			if f.Error != "" {
+				return f, errors.New(f.Error)
+			}
+			return f, nil
+		case f.Type == FrameNotify && notify != nil:
+			notify(f)
+		case f.Type == FrameError:
+			return f, fmt.Errorf("clock server: %s", f.Error)
+		}
+	}
+}
+
+// Add registers a schedule, and returns it with its next time
+func (c *Conn) Add(s Schedule, notify func(Frame)) (Schedule, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return Schedule{}, err
+	}
+	f, err := c.Call(CmdAdd, string(b), notify)
+	if err != nil {
+		return Schedule{}, err
+	}
+	if len(f.Schedules) != 1 {
+		return Schedule{}, fmt.Errorf("clock server: %d schedules in the reply", len(f.Schedules))
+	}
+	return f.Schedules[0], nil
+}
+
+// Cancel removes the schedule of the name
+func (c *Conn) Cancel(name string, notify func(Frame)) error {
+	_, err := c.Call(CmdCancel, name, notify)
+	return err
+}
+
+// List returns the schedules of the client
+func (c *Conn) List(notify func(Frame)) ([]Schedule, error) {
+	f, err := c.Call(CmdList, "", notify)
+	return f.Schedules, err
+}
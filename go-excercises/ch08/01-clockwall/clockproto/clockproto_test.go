@@ -0,0 +1,103 @@
+package clockproto
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHello(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    Hello
+		wantErr bool
+	}{
+		{"CLOCK/1", Hello{Version: 1, Format: FormatJSON, Interval: time.Second}, false},
+		{"CLOCK/1 zone=Asia/Kolkata format=text interval=250ms\n",
+			Hello{Version: 1, Zone: "Asia/Kolkata", Format: FormatText, Interval: 250 * time.Millisecond}, false},
+		{"CLOCK/2", Hello{}, true},
+		{"CLOCK/x", Hello{}, true},
+		{"HELLO", Hello{}, true},
+		{"", Hello{}, true},
+		{"CLOCK/1 format=xml", Hello{}, true},
+		{"CLOCK/1 interval=1ms", Hello{}, true},
+		{"CLOCK/1 interval=soon", Hello{}, true},
+		{"CLOCK/1 color=red", Hello{}, true},
+		{"CLOCK/1 zone", Hello{}, true},
+		{"CLOCK/1 client=lab-wall.1", Hello{Version: 1, Format: FormatJSON, Interval: time.Second, Client: "lab-wall.1"}, false},
+		{"CLOCK/1 client=a/b", Hello{}, true},
+	}
+	for _, test := range tests {
+		got, err := ParseHello(test.line)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("ParseHello(%q) = %+v, %v, want %+v, error %t", test.line, got, err, test.want, test.wantErr)
+		}
+	}
+
+	// The hello lines round trip
+	h := Hello{Version: Version, Zone: "Europe/London", Format: FormatText, Interval: 2 * time.Second, Client: "wall"}
+	if got, err := ParseHello(h.String()); err != nil || got != h {
+		t.Errorf("ParseHello(%q) = %+v, %v, want %+v", h.String(), got, err, h)
+	}
+}
+
+func TestTick(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Skip(err)
+	}
+	now := time.Date(2023, 5, 1, 18, 30, 0, 0, loc)
+	want := Frame{Type: FrameTick, Time: "2023-05-01T18:30:00+05:30", Zone: "Asia/Kolkata", Abbrev: "IST", Offset: "+05:30"}
+	f := Tick(now)
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("Tick = %+v, want %+v", f, want)
+	}
+	if got, err := f.ParseTime(); err != nil || !got.Equal(now) {
+		t.Errorf("ParseTime = %v, %v, want %v", got, err, now)
+	}
+}
+
+func TestSync(t *testing.T) {
+	t1 := time.Unix(1000, 0)
+	id, got, err := ParseSync(SyncRequest(7, t1))
+	if err != nil || id != 7 || got != t1.UnixNano() {
+		t.Errorf("ParseSync(SyncRequest) = %d, %d, %v, want 7, %d", id, got, err, t1.UnixNano())
+	}
+	for _, line := range []string{"SYNC", "SYNC x 1", "SYNC 1 x", "TIME 1 1"} {
+		if _, _, err := ParseSync(line); err == nil {
+			t.Errorf("ParseSync(%q) succeeded", line)
+		}
+	}
+
+	// The server clock is 5s ahead, the request takes 10ms each way and
+	// the server 1ms to answer
+	ms := int64(time.Millisecond)
+	f := Frame{Type: FrameSync, T1: 0, T2: 5010 * ms, T3: 5011 * ms}
+	want := Sample{Delay: 20 * time.Millisecond, Offset: 5 * time.Second}
+	if got := f.Sample(time.Unix(0, 21*ms)); got != want {
+		t.Errorf("Sample = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		line    string
+		verb    string
+		id      uint64
+		arg     string
+		wantErr bool
+	}{
+		{"LIST 3\n", "LIST", 3, "", false},
+		{"CANCEL 4 standup", "CANCEL", 4, "standup", false},
+		{`ADD 5 {"name":"a b"}`, "ADD", 5, `{"name":"a b"}`, false},
+		{"LIST", "", 0, "", true},
+		{"LIST x", "", 0, "", true},
+	}
+	for _, test := range tests {
+		verb, id, arg, err := ParseRequest(test.line)
+		if (err != nil) != test.wantErr || verb != test.verb || id != test.id || arg != test.arg {
+			t.Errorf("ParseRequest(%q) = %q, %d, %q, %v, want %q, %d, %q, error %t",
+				test.line, verb, id, arg, err, test.verb, test.id, test.arg, test.wantErr)
+		}
+	}
+}
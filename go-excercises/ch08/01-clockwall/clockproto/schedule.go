@@ -0,0 +1,33 @@
+package clockproto
+
+import "regexp"
+
+// Schedule kinds
+const (
+	KindAlarm = "alarm" // once at a wall-clock time in a zone
+	KindTimer = "timer" // once after a duration
+	KindCron  = "cron"  // at the minutes matching a cron spec in a zone
+)
+
+// Alarm time layouts, the next time of the day or a given date
+var AlarmLayouts = []string{"15:04", "15:04:05", "2006-01-02T15:04", "2006-01-02T15:04:05"}
+
+// Schedule is an alarm, a timer or a cron schedule of a client
+type Schedule struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	At    string `json:"at,omitempty"`    // alarm, in one of the AlarmLayouts
+	After string `json:"after,omitempty"` // timer, such as "25m"
+	Cron  string `json:"cron,omitempty"`  // cron, minute hour day-of-month month day-of-week
+	Zone  string `json:"zone,omitempty"`  // alarm and cron, the server default if empty
+	Next  string `json:"next,omitempty"`  // set by the server, RFC 3339
+}
+
+// nameRE matches the client IDs and the schedule names
+var nameRE = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// ValidName reports whether a client ID or a schedule name is valid: up to
+// 64 letters, digits, dots, dashes and underscores
+func ValidName(s string) bool {
+	return nameRE.MatchString(s)
+}
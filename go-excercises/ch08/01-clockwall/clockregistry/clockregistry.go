@@ -0,0 +1,48 @@
+/*
+clockregistry keeps the clock servers announced, for the clock walls to discover them
+
+The clock servers announce themselves with a TTL and repeat it as a
+heartbeat; the clocks not heard of within their TTL are dropped (see
+discovery):
+
+	$ ./clockregistry -addr :8090
+	$ ./clock -name lab1 -zone Europe/London -registry http://localhost:8090
+	$ curl localhost:8090/clocks
+	$ ./clockwall -discover -registry http://localhost:8090
+
+With -multicast the registry also keeps the clocks announced to the LAN, so
+that the walls on other networks can find them.
+*/
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/discovery"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "address to serve the registry on")
+	multicast := flag.Bool("multicast", false, "also keep the clocks announced with UDP multicast")
+	group := flag.String("group", discovery.DefaultGroup, "multicast group of the announcements")
+	flag.Parse()
+
+	reg := discovery.NewRegistry()
+	if *multicast {
+		conn, err := discovery.ListenMulticast(*group)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			log.Fatal(discovery.Listen(conn, reg, log.Printf))
+		}()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/clocks", reg)
+	mux.Handle("/clocks/", reg)
+	log.Printf("Registry listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+// Settings of the clock connections, set by the flags
+var (
+	readTimeout = 10 * time.Second       // reconnect when no frame is read for this long
+	staleAfter  = 3 * time.Second        // show a clock stale when no frame is read for this long
+	minBackoff  = 500 * time.Millisecond // first delay before reconnecting
+	maxBackoff  = 30 * time.Second       // longest delay before reconnecting
+
+	tlsConfig *tls.Config // secures the connections, which are plain if nil
+
+	syncInterval = 10 * time.Second // how often to measure the server clocks, never if zero
+	maxOffset    time.Duration      // alert when a server clock is off by more, never if zero
+)
+
+// syncSamples is the number of recent measures of a server clock kept. The
+// one of the shortest round trip is the most accurate, as NTP filters them.
+const syncSamples = 8
+
+// status of a clock connection
+type status string
+
+const (
+	connecting   status = "connecting"
+	connected    status = "connected"
+	reconnecting status = "reconnecting"
+	stale        status = "stale"
+)
+
+// clock is a clock server shown on the wall. Its connection is kept by its
+// own goroutine, so a slow or dead server never holds the others.
+type clock struct {
+	name string
+	addr string
+	zone string // the server default if empty
+
+	mu     sync.Mutex
+	state  status
+	err    error            // why the clock is reconnecting
+	last   clockproto.Frame // the last tick
+	lastAt time.Time        // when the last tick was read
+
+	samples []clockproto.Sample // the recent measures of the connection
+	alerted bool                // the offset is over maxOffset
+}
+
+// newClock creates a clock not connected yet
+func newClock(name, addr, zone string) *clock {
+	return &clock{name: name, addr: addr, zone: zone, state: connecting}
+}
+
+// view is what the wall shows of a clock at a time
+type view struct {
+	name   string
+	status status
+	err    error            // why the clock is reconnecting
+	last   clockproto.Frame // the last tick
+	lastAt time.Time        // when the last tick was read
+
+	sync   clockproto.Sample // the best measure of the server clock
+	synced bool              // the server clock was measured
+	alert  bool              // the offset is over maxOffset
+}
+
+// snapshot returns the view of the clock. A connected clock is stale when
+// it did not tick recently.
+func (c *clock) snapshot(now time.Time) view {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v := view{name: c.name, status: c.state, err: c.err, last: c.last, lastAt: c.lastAt, alert: c.alerted}
+	v.sync, v.synced = bestSample(c.samples)
+	if c.state == connected && now.Sub(c.lastAt) > staleAfter {
+		v.status = stale
+	}
+	return v
+}
+
+// drift returns how far the clock is ahead of the local time, to the
+// second as the ticks carry no fraction. It is false for the legacy
+// servers, whose ticks have no date.
+func (v view) drift() (time.Duration, bool) {
+	t, err := v.last.ParseTime()
+	if err != nil {
+		return 0, false
+	}
+	return t.Sub(v.lastAt.Truncate(time.Second)), true
+}
+
+// setState changes the status, and logs why the clock is reconnecting
+func (c *clock) setState(s status, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s == reconnecting && (c.state != reconnecting || c.err == nil) {
+		log.Printf("%s (%s): %v", c.name, c.addr, err)
+	}
+	if s == connected {
+		// The measures of a connection may not hold for the next
+		c.samples, c.alerted = nil, false
+	}
+	c.state, c.err = s, err
+}
+
+// measure records a measure of the server clock, and logs when its offset
+// goes over maxOffset
+func (c *clock) measure(m clockproto.Sample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples = append(c.samples, m)
+	if len(c.samples) > syncSamples {
+		c.samples = c.samples[1:]
+	}
+	best, _ := bestSample(c.samples)
+	alert := maxOffset > 0 && (best.Offset > maxOffset || best.Offset < -maxOffset)
+	if alert && !c.alerted {
+		log.Printf("ALERT %s (%s): clock offset %s exceeds %s", c.name, c.addr, signed(round(best.Offset)), maxOffset)
+	}
+	c.alerted = alert
+}
+
+// bestSample returns the measure of the shortest round trip
+func bestSample(samples []clockproto.Sample) (clockproto.Sample, bool) {
+	if len(samples) == 0 {
+		return clockproto.Sample{}, false
+	}
+	best := samples[0]
+	for _, m := range samples[1:] {
+		if m.Delay < best.Delay {
+			best = m
+		}
+	}
+	return best, true
+}
+
+// tick records a tick of the server
+func (c *clock) tick(f clockproto.Frame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last, c.lastAt = f, time.Now()
+}
+
+// run keeps the clock connected until done is closed, waiting longer after
+// each failed attempt. The wait starts over once the server ticks again.
+func (c *clock) run(done <-chan struct{}) {
+	backoff := minBackoff
+	for {
+		dialer := &clockproto.Dialer{Timeout: readTimeout, TLSConfig: tlsConfig}
+		conn, err := dialer.Dial(c.addr, clockproto.Hello{Zone: c.zone})
+		if err == nil {
+			c.setState(connected, nil)
+			stop := make(chan struct{})
+			go func() {
+				// Unblock the read on done
+				select {
+				case <-done:
+					conn.Close()
+				case <-stop:
+				}
+			}()
+			if syncInterval > 0 && !conn.Legacy {
+				go syncClock(conn, stop)
+			}
+			var ticked bool
+			ticked, err = c.read(conn)
+			close(stop)
+			conn.Close()
+			if ticked {
+				backoff = minBackoff
+			}
+		}
+
+		select {
+		case <-done:
+			return
+		default:
+		}
+		c.setState(reconnecting, err)
+
+		select {
+		case <-done:
+			return
+		case <-time.After(jitter(backoff)):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// read records the ticks of the connection until it fails, or stalls for
+// the readTimeout. It reports whether there was any tick.
+func (c *clock) read(conn *clockproto.Conn) (ticked bool, err error) {
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		f, err := conn.Next()
+		received := time.Now()
+		if err != nil {
+			return ticked, err
+		}
+		switch f.Type {
+		case clockproto.FrameTick:
+			c.tick(f)
+			ticked = true
+		case clockproto.FrameSync:
+			c.measure(f.Sample(received))
+		case clockproto.FrameError:
+			return ticked, &serverError{f.Error}
+		}
+	}
+}
+
+// syncClock sends the sync requests of the connection until stop is
+// closed. Their answers are read with the ticks.
+func syncClock(conn *clockproto.Conn, stop <-chan struct{}) {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := conn.Sync(); err != nil {
+			return // the read fails as well
+		}
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// serverError is an error frame of the server
+type serverError struct{ msg string }
+
+func (e *serverError) Error() string { return "clock server: " + e.msg }
+
+// jitter spreads the delay by up to a fifth either way, so that the clocks
+// of a restarted server do not all reconnect at once
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()-0.5)*0.4*float64(d))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+// fakeServer is a clock server that can stall its ticks
+type fakeServer struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	stalled bool
+}
+
+func newFakeServer(t *testing.T, addr string) *fakeServer {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	defer c.Close()
+	bufio.NewReader(c).ReadString('\n')
+	enc := json.NewEncoder(c)
+	enc.Encode(clockproto.Frame{Type: clockproto.FrameWelcome, Version: clockproto.Version})
+	for {
+		s.mu.Lock()
+		stalled := s.stalled
+		s.mu.Unlock()
+		if !stalled {
+			if err := enc.Encode(clockproto.Tick(time.Now().UTC())); err != nil {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *fakeServer) stall(stalled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stalled = stalled
+}
+
+// waitFor waits for the clock to reach the status
+func waitFor(t *testing.T, c *clock, want status) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if c.snapshot(time.Now()).status == want {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("%s status = %s, want %s", c.name, c.snapshot(time.Now()).status, want)
+}
+
+func init() {
+	// Short timings for the tests, set once as the clock goroutines read them
+	readTimeout, staleAfter = 300*time.Millisecond, 100*time.Millisecond
+	minBackoff, maxBackoff = 10*time.Millisecond, 50*time.Millisecond
+}
+
+func TestClockReconnect(t *testing.T) {
+	// Nothing listens on the address at the start
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	c := newClock("test", addr, "")
+	done := make(chan struct{})
+	defer close(done)
+	go c.run(done)
+	waitFor(t, c, reconnecting)
+
+	s := newFakeServer(t, addr)
+	defer s.ln.Close()
+	waitFor(t, c, connected)
+	v := c.snapshot(time.Now())
+	if clockTime(v.last) == "NA" {
+		t.Errorf("no time shown after connecting")
+	}
+	if d, ok := v.drift(); !ok || d < -time.Second || d > time.Second {
+		t.Errorf("drift = %v, %t, want about 0", d, ok)
+	}
+
+	// A stalled server turns stale, and is dropped after the read timeout
+	s.stall(true)
+	waitFor(t, c, stale)
+	waitFor(t, c, reconnecting)
+	s.stall(false)
+	waitFor(t, c, connected)
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		arg              string
+		name, addr, zone string
+		wantErr          bool
+	}{
+		{"India=localhost:8001", "India", "localhost:8001", "", false},
+		{"India=localhost:8001/Asia/Kolkata", "India", "localhost:8001", "Asia/Kolkata", false},
+		{"India", "", "", "", true},
+		{"=localhost:8001", "", "", "", true},
+	}
+	for _, test := range tests {
+		c, err := parseClock(test.arg)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseClock(%q) error = %v, want error %t", test.arg, err, test.wantErr)
+			continue
+		}
+		if err == nil && (c.name != test.name || c.addr != test.addr || c.zone != test.zone) {
+			t.Errorf("parseClock(%q) = %s %s %s, want %s %s %s", test.arg, c.name, c.addr, c.zone, test.name, test.addr, test.zone)
+		}
+	}
+}
+
+func TestClockMeasure(t *testing.T) {
+	defer func(old time.Duration) { maxOffset = old }(maxOffset)
+	maxOffset = 100 * time.Millisecond
+
+	c := newClock("test", "localhost:0", "")
+	c.measure(clockproto.Sample{Delay: 50 * time.Millisecond, Offset: 200 * time.Millisecond})
+	if v := c.snapshot(time.Now()); !v.synced || !v.alert {
+		t.Errorf("after a measure over the max: synced %t, alert %t, want both", v.synced, v.alert)
+	}
+
+	// The measure of the shortest round trip is the one kept
+	c.measure(clockproto.Sample{Delay: 2 * time.Millisecond, Offset: 3 * time.Millisecond})
+	c.measure(clockproto.Sample{Delay: 80 * time.Millisecond, Offset: -300 * time.Millisecond})
+	v := c.snapshot(time.Now())
+	if v.sync.Offset != 3*time.Millisecond || v.alert {
+		t.Errorf("offset = %s, alert %t, want 3ms without alert", v.sync.Offset, v.alert)
+	}
+
+	// Only the recent measures are kept
+	for i := 0; i < syncSamples; i++ {
+		c.measure(clockproto.Sample{Delay: 10 * time.Millisecond, Offset: time.Second})
+	}
+	if v := c.snapshot(time.Now()); v.sync.Offset != time.Second || !v.alert {
+		t.Errorf("offset = %s, alert %t, want 1s with alert", v.sync.Offset, v.alert)
+	}
+}
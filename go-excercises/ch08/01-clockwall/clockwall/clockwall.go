@@ -5,80 +5,251 @@ It is a client that can connect to multiple clock servers serving different time
 Example:
 
 	clockwall India=localhost:8001 SanJose=localhost:8002 Bristol=localhost:8003
+
+A clock can name its time zone after the address, so a single clock server
+can serve all of them:
+
+	clockwall India=localhost:8001/Asia/Kolkata SanJose=localhost:8001/America/Los_Angeles
+
+Each clock keeps reconnecting to its server, waiting longer after each
+failure, and the status column shows whether it is connected, reconnecting
+or stale. A stale clock did not tick for -stale-after, and its connection is
+dropped after -read-timeout:
+
+	clockwall -stale-after 5s -read-timeout 20s -max-backoff 1m India=localhost:8001
+
+On a terminal, the clocks are redrawn in place as a grid showing their drift
+from the local time, with the stale clocks highlighted. The rows of the line
+mode are printed instead when the output is not a terminal, or with -mode:
+
+	clockwall -mode lines India=localhost:8001 | tee wall.log
+
+The offset of each server clock is measured every -sync-interval, with an
+exchange of precise times as NTP does, and shown with the round trip delay.
+With -max-offset, an alert is logged and the offset is shown in red when a
+server clock is off by more:
+
+	clockwall -max-offset 50ms Lab1=lab1:8001 Lab2=lab2:8001
+
+The clock servers serving TLS are reached with -tls, or -ca to trust the CA
+of their certificates, and -cert and -key present a client certificate to
+the servers requiring one:
+
+	clockwall -ca certs/ca.pem -cert certs/client.pem -key certs/client-key.pem Lab=lab1.local:8001
+
+With -discover, the clocks announced by their servers are added and removed
+as they come and go, next to those of the arguments. They are found in a
+clockregistry, or on the LAN with -multicast:
+
+	clockwall -discover -registry http://registry.local:8090
+	clockwall -discover -multicast India=localhost:8001/Asia/Kolkata
+
+The web mode serves the clocks as a page updated live, for a screen across
+the room, and as JSON on /clocks for the other tools. The page follows the
+same clock server connections through the Server-Sent Events of /events:
+
+	clockwall -mode web -http :8080 -discover
+	curl localhost:8080/clocks
 */
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/discovery"
 )
 
 func main() {
-	var clocks []string
-	var readers []*bufio.Reader
+	flag.DurationVar(&readTimeout, "read-timeout", readTimeout, "time without a tick before reconnecting")
+	flag.DurationVar(&staleAfter, "stale-after", staleAfter, "time without a tick before showing a clock stale")
+	flag.DurationVar(&maxBackoff, "max-backoff", maxBackoff, "longest delay between the reconnections")
+	flag.DurationVar(&syncInterval, "sync-interval", syncInterval, "how often to measure the offset of the server clocks (0 to never)")
+	flag.DurationVar(&maxOffset, "max-offset", maxOffset, "alert when a server clock is off by more (0 to never)")
+	useTLS := flag.Bool("tls", false, "connect to the servers with TLS, verified with the system CAs unless -ca is set")
+	caFile := flag.String("ca", "", "PEM CA to verify the servers with (implies -tls)")
+	certFile := flag.String("cert", "", "PEM client certificate to present to the servers (implies -tls)")
+	keyFile := flag.String("key", "", "PEM key of the client certificate")
+	mode := flag.String("mode", "auto", "display mode: grid, lines, web, or auto for grid on a terminal")
+	httpAddr := flag.String("http", ":8080", "address to serve the page of the web mode on")
+	discover := flag.Bool("discover", false, "add and remove the clocks announced by their servers")
+	registry := flag.String("registry", "http://localhost:8090", "URL of the clockregistry to discover the clocks in")
+	multicast := flag.Bool("multicast", false, "discover the clocks announced on the LAN instead of the registry")
+	group := flag.String("group", discovery.DefaultGroup, "multicast group of the announcements")
+	discoverEvery := flag.Duration("discover-interval", 5*time.Second, "how often to look for the clocks come and gone")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), `Usage  : clockwall [flags] Name1=Addr1[/Zone1] Name2=Addr2[/Zone2] ...`)
+		fmt.Fprintln(flag.CommandLine.Output(), `         clockwall -discover [flags] [Name=Addr[/Zone] ...]`)
+		fmt.Fprintln(flag.CommandLine.Output(), `Example: clockwall India=localhost:8001 SanJose=localhost:8002/America/Los_Angeles`)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-	if len(os.Args) <= 1 {
-		fmt.Println(`Usage  : clockwall Name1=Addr1 Name2=Addr2 ...`)
-		fmt.Println(`Example: clockwall India=localhost:8001 SanJose=localhost:8002`)
+	if flag.NArg() == 0 && !*discover {
+		flag.Usage()
 		os.Exit(1)
 	}
-
-	for _, v := range os.Args[1:] {
-		tokens := strings.Split(v, "=")
-		if len(tokens) != 2 {
-			log.Printf("invalid argument: %s", v)
-			continue
+	if *useTLS || *caFile != "" || *certFile != "" || *keyFile != "" {
+		var err error
+		if tlsConfig, err = clockproto.ClientTLS(*caFile, *certFile, *keyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+	switch *mode {
+	case "auto":
+		if isTerminal(os.Stdout) {
+			*mode = "grid"
+		} else {
+			*mode = "lines"
 		}
+	case "grid", "lines", "web":
+	default:
+		log.Fatalf("unknown mode %q", *mode)
+	}
 
-		address := tokens[1]
-		conn, err := net.Dial("tcp", address)
+	// The servers down at the start are retried like the others
+	w := newWall()
+	for _, v := range flag.Args() {
+		c, err := parseClock(v)
 		if err != nil {
-			log.Printf("failed to dial the clock server: %s", address)
+			log.Print(err)
 			continue
 		}
+		w.add(c)
+	}
 
-		clocks = append(clocks, tokens[0])
-		readers = append(readers, bufio.NewReader(conn))
+	if len(w.list()) == 0 && !*discover {
+		return
 	}
 
-	if len(clocks) > 0 {
-		wallClocks(clocks, readers)
+	// Stop on Ctrl-C, so that the grid can restore the terminal
+	done := make(chan struct{})
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		close(done)
+	}()
+
+	if *discover {
+		var src discovery.Source = &discovery.RemoteRegistry{URL: *registry}
+		if *multicast {
+			conn, err := discovery.ListenMulticast(*group)
+			if err != nil {
+				log.Fatal(err)
+			}
+			reg := discovery.NewRegistry()
+			go discovery.Listen(conn, reg, log.Printf)
+			src = reg
+		}
+		go w.discover(src, *discoverEvery, done)
+	}
+
+	switch *mode {
+	case "grid":
+		// The errors are shown in the grid, the logs would scroll it
+		log.SetOutput(io.Discard)
+		(&dashboard{tty: os.Stdout}).run(w, done)
+	case "web":
+		if err := serveWeb(w, *httpAddr, done); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		wallClocks(w, done)
+	}
+}
+
+// parseClock parses a Name=Addr[/Zone] argument. The zone follows the first
+// slash, as the zones have slashes too.
+func parseClock(arg string) (*clock, error) {
+	name, target, ok := strings.Cut(arg, "=")
+	if !ok || name == "" || target == "" {
+		return nil, fmt.Errorf("invalid argument: %s", arg)
 	}
+	address, zone, _ := strings.Cut(target, "/")
+	return newClock(name, address, zone), nil
 }
 
-// wallClocks displays a table of clocks with different timezones
-func wallClocks(clocks []string, readers []*bufio.Reader) {
-	counter := 0
-	for {
-		if (counter % 10) == 0 {
-			// Print the header after every 10 seconds
+// wallClocks displays a table of clocks with different timezones until
+// done is closed. The rows are printed every second from the last ticks,
+// without waiting for the servers.
+func wallClocks(w *wall, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var shown []*clock
+	for counter := 0; ; counter++ {
+		clocks := w.list()
+		if (counter%10) == 0 || !sameClocks(clocks, shown) {
+			// Print the header after every 10 seconds, and when the
+			// clocks change
 			printHeader(clocks)
+			shown, counter = clocks, 0
+		}
+		printRow(clocks, time.Now())
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
 		}
-		counter++
+	}
+}
 
-		for _, r := range readers {
-			time, err := r.ReadString('\n')
-			if err != nil {
-				// Simply display NA when a server is unreachable
-				fmt.Printf("%-15s", "NA")
-			} else {
-				fmt.Printf("%-15s", time[:len(time)-1])
-			}
+// sameClocks reports whether the two lists have the same clocks in the same order
+func sameClocks(a, b []*clock) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
 		}
-		fmt.Println()
-		time.Sleep(1 * time.Second)
 	}
+	return true
+}
+
+// printHeader displays the header with clock names and their status and offset columns
+func printHeader(clocks []*clock) {
+	for _, c := range clocks {
+		fmt.Printf("%-15s%-14s%-12s", c.name, "status", "offset")
+	}
+	fmt.Println()
 }
 
-// printHeader displays the header with clock names
-func printHeader(clocks []string) {
-	for _, name := range clocks {
-		fmt.Printf("%-15s", name)
+// printRow displays the last time, the status and the offset of each clock.
+// The offsets over -max-offset are marked with a "!".
+func printRow(clocks []*clock, now time.Time) {
+	for _, c := range clocks {
+		v := c.snapshot(now)
+		offset := "NA"
+		if v.synced {
+			offset = signed(round(v.sync.Offset))
+		}
+		if v.alert {
+			offset += "!"
+		}
+		fmt.Printf("%-15s%-14s%-12s", clockTime(v.last), v.status, offset)
 	}
 	fmt.Println()
-}
\ No newline at end of file
+}
+
+// clockTime formats the time of a tick with the zone abbreviation. The
+// ticks of the legacy servers are shown as they are, and NA before the
+// first tick.
+func clockTime(f clockproto.Frame) string {
+	if f.Time == "" {
+		return "NA"
+	}
+	t, err := f.ParseTime()
+	if err != nil {
+		return f.Time
+	}
+	return t.Format(clockproto.TextLayout) + " " + f.Abbrev
+}
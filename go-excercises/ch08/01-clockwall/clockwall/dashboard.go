@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+// ANSI escape sequences of the dashboard
+const (
+	altScreen  = "\x1b[?1049h"
+	mainScreen = "\x1b[?1049l"
+	hideCursor = "\x1b[?25l"
+	showCursor = "\x1b[?25h"
+	home       = "\x1b[H"
+	clearLine  = "\x1b[K"
+	clearBelow = "\x1b[J"
+
+	bold      = "\x1b[1m"
+	red       = "\x1b[31m"
+	green     = "\x1b[32m"
+	highlight = "\x1b[30;43m" // black on yellow
+	reset     = "\x1b[0m"
+)
+
+// Layout of the dashboard
+const (
+	cardWidth     = 28
+	cardGap       = 2
+	defaultWidth  = 80
+	defaultHeight = 24
+	redrawEvery   = 250 * time.Millisecond
+)
+
+// isTerminal reports whether the file is a terminal rather than a pipe or a file
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// dashboard redraws the clocks in place as a grid of cards, sized to the terminal
+type dashboard struct {
+	tty           *os.File
+	width, height int
+}
+
+// run draws the clocks of the wall until done is closed, and restores the terminal
+func (d *dashboard) run(w *wall, done <-chan struct{}) {
+	resize := make(chan os.Signal, 1)
+	if len(resizeSignals) > 0 {
+		signal.Notify(resize, resizeSignals...)
+		defer signal.Stop(resize)
+	}
+
+	fmt.Fprint(d.tty, altScreen+hideCursor)
+	defer fmt.Fprint(d.tty, showCursor+mainScreen)
+
+	ticker := time.NewTicker(redrawEvery)
+	defer ticker.Stop()
+	d.resize()
+	for {
+		now := time.Now()
+		clocks := w.list()
+		views := make([]view, len(clocks))
+		for i, c := range clocks {
+			views[i] = c.snapshot(now)
+		}
+		fmt.Fprint(d.tty, d.render(views, now))
+
+		select {
+		case <-done:
+			return
+		case <-resize:
+			d.resize()
+		case <-ticker.C:
+		}
+	}
+}
+
+// resize reads the terminal size, or uses the default when it is unknown
+func (d *dashboard) resize() {
+	w, h, err := termSize(d.tty)
+	if err != nil || w <= 0 || h <= 0 {
+		w, h = defaultWidth, defaultHeight
+	}
+	d.width, d.height = w, h
+}
+
+// render returns the escape sequences drawing the whole screen. The lines
+// past the terminal width and height are cut, as they would scroll.
+func (d *dashboard) render(views []view, now time.Time) string {
+	abbrev, _ := now.Zone()
+	lines := []string{
+		fit(fmt.Sprintf("clockwall  local %s %s  %d clocks  Ctrl-C to quit",
+			now.Format("15:04:05"), abbrev, len(views)), d.width, bold),
+		"",
+	}
+
+	width := cardWidth
+	if width > d.width {
+		width = d.width
+	}
+	cols := (d.width + cardGap) / (width + cardGap)
+	if cols < 1 {
+		cols = 1
+	}
+	gap := strings.Repeat(" ", cardGap)
+	for row := 0; row < len(views); row += cols {
+		end := row + cols
+		if end > len(views) {
+			end = len(views)
+		}
+		var cards [][]string
+		for _, v := range views[row:end] {
+			cards = append(cards, card(v, width))
+		}
+		for i := range cards[0] {
+			parts := make([]string, len(cards))
+			for j := range cards {
+				parts[j] = cards[j][i]
+			}
+			lines = append(lines, strings.Join(parts, gap))
+		}
+		lines = append(lines, "")
+	}
+
+	if len(lines) > d.height {
+		lines = lines[:d.height]
+	}
+	var b strings.Builder
+	b.WriteString(home)
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line + clearLine)
+	}
+	b.WriteString(clearBelow)
+	return b.String()
+}
+
+// card returns the lines of a clock of the width. The stale clocks are
+// highlighted, as their time is out of date.
+func card(v view, width int) []string {
+	nameStyle, timeStyle := bold, ""
+	if v.status == stale {
+		nameStyle, timeStyle = highlight, highlight
+	}
+	statusStyle := green
+	if v.status != connected {
+		statusStyle = red
+	}
+
+	when := clockTime(v.last)
+	if v.last.Offset != "" {
+		when += " " + v.last.Offset
+	}
+	drift := "drift n/a"
+	if d, ok := v.drift(); ok {
+		drift = "drift " + signed(d)
+	}
+	offset, offsetStyle := "offset n/a", ""
+	if v.synced {
+		offset = fmt.Sprintf("offset %s rtt %s", signed(round(v.sync.Offset)), round(v.sync.Delay))
+	}
+	if v.alert {
+		offsetStyle = bold + red
+	}
+	var reason string
+	if v.err != nil && v.status != connected {
+		reason = v.err.Error()
+	}
+
+	return []string{
+		fit(v.name, width, nameStyle),
+		fit(when, width, timeStyle),
+		fit(drift, width, ""),
+		fit(offset, width, offsetStyle),
+		fit(string(v.status), width, statusStyle),
+		fit(reason, width, ""),
+	}
+}
+
+// signed formats a duration with its sign, such as +2s or -1s
+func signed(d time.Duration) string {
+	if d < 0 {
+		return d.String()
+	}
+	return "+" + d.String()
+}
+
+// round cuts a measured duration to about three significant digits
+func round(d time.Duration) time.Duration {
+	abs := d
+	if abs < 0 {
+		abs = -abs
+	}
+	switch {
+	case abs < time.Millisecond:
+		return d.Round(time.Microsecond)
+	case abs < time.Second:
+		return d.Round(10 * time.Microsecond)
+	default:
+		return d.Round(time.Millisecond)
+	}
+}
+
+// fit cuts or pads the text to the width, and styles it
+func fit(s string, width int, style string) string {
+	r := []rune(s)
+	if len(r) > width {
+		r = r[:width]
+	}
+	s = string(r) + strings.Repeat(" ", width-len(r))
+	if style == "" {
+		return s
+	}
+	return style + s + reset
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+func TestDashboardRender(t *testing.T) {
+	now := time.Date(2023, 5, 1, 13, 0, 0, 300e6, time.UTC)
+	views := []view{
+		{name: "London", status: connected, lastAt: now, last: clockproto.Tick(now.Add(2 * time.Second)),
+			sync: clockproto.Sample{Delay: 1234567, Offset: 2000123456}, synced: true, alert: true},
+		{name: "Lab", status: stale, lastAt: now.Add(-time.Minute), last: clockproto.Tick(now.Add(-time.Minute))},
+		{name: "Tokyo", status: reconnecting, err: errors.New("connection refused")},
+	}
+	d := &dashboard{width: 60, height: 24}
+	screen := d.render(views, now)
+
+	if !strings.HasPrefix(screen, home) || !strings.HasSuffix(screen, clearBelow) {
+		t.Errorf("screen not redrawn in place: %q", screen)
+	}
+	lines := strings.Split(screen, "\r\n")
+	// The header, a blank line, and two rows of 6 lines and a blank line
+	if len(lines) != 2+2*7 {
+		t.Errorf("%d lines, want 16 for two clocks per row:\n%s", len(lines), screen)
+	}
+	for _, want := range []string{
+		"drift +2s",
+		fit("Lab", cardWidth, highlight),
+		fit("reconnecting", cardWidth, red),
+		"connection refused",
+		"13:00:02 UTC +00:00",
+		"drift n/a",
+		fit("offset +2s rtt 1.23ms", cardWidth, bold+red),
+		"offset n/a",
+	} {
+		if !strings.Contains(screen, want) {
+			t.Errorf("screen does not contain %q:\n%s", want, screen)
+		}
+	}
+
+	// The rows past the height are cut
+	d.height = 5
+	if n := len(strings.Split(d.render(views, now), "\r\n")); n != 5 {
+		t.Errorf("%d lines on a terminal of 5 rows", n)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// termSize returns the columns and rows of the terminal. It is unknown on
+// this system, so the dashboard uses the default size.
+func termSize(f *os.File) (width, height int, err error) {
+	return 0, 0, errors.New("terminal size not supported")
+}
+
+// resizeSignals are sent on a terminal resize, none on this system
+var resizeSignals []os.Signal
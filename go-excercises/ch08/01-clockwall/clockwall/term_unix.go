@@ -0,0 +1,28 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// winsize is the terminal size of the TIOCGWINSZ ioctl
+type winsize struct {
+	rows, cols     uint16
+	xpixel, ypixel uint16
+}
+
+// termSize returns the columns and rows of the terminal
+func termSize(f *os.File) (width, height int, err error) {
+	var ws winsize
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+	return int(ws.cols), int(ws.rows), nil
+}
+
+// resizeSignals are sent on a terminal resize
+var resizeSignals = []os.Signal{syscall.SIGWINCH}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/discovery"
+)
+
+// wall is the set of clocks shown. The clocks of the arguments stay, while
+// the discovered ones come and go with the announcements of their servers.
+type wall struct {
+	mu         sync.Mutex
+	clocks     []*clock // in the order shown
+	discovered map[string]discovery.Clock
+	stops      map[*clock]chan struct{} // closing one stops its clock
+}
+
+// newWall creates an empty wall
+func newWall() *wall {
+	return &wall{discovered: make(map[string]discovery.Clock), stops: make(map[*clock]chan struct{})}
+}
+
+// add shows the clock and starts connecting it
+func (w *wall) add(c *clock) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.start(c)
+}
+
+// start adds the clock and runs it. It is called with the lock held.
+func (w *wall) start(c *clock) {
+	stop := make(chan struct{})
+	w.clocks = append(w.clocks, c)
+	w.stops[c] = stop
+	go c.run(stop)
+}
+
+// list returns the clocks shown
+func (w *wall) list() []*clock {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]*clock(nil), w.clocks...)
+}
+
+// update shows the clocks found and removes the discovered clocks gone. A
+// clock moved to another address or zone is reconnected, and the names of
+// the clocks of the arguments are never taken over.
+func (w *wall) update(found []discovery.Clock) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	alive := make(map[string]discovery.Clock, len(found))
+	for _, d := range found {
+		alive[d.Name] = d
+	}
+	kept := w.clocks[:0]
+	for _, c := range w.clocks {
+		old, ok := w.discovered[c.name]
+		if d, found := alive[c.name]; ok && (!found || d.Addr != old.Addr || d.Zone != old.Zone) {
+			log.Printf("%s (%s): gone", c.name, c.addr)
+			close(w.stops[c])
+			delete(w.stops, c)
+			delete(w.discovered, c.name)
+			continue
+		}
+		kept = append(kept, c)
+	}
+	w.clocks = kept
+
+	for _, d := range found {
+		if w.shows(d.Name) {
+			continue
+		}
+		log.Printf("%s (%s): discovered", d.Name, d.Addr)
+		w.discovered[d.Name] = d
+		w.start(newClock(d.Name, d.Addr, d.Zone))
+	}
+}
+
+// shows reports whether a clock of the name is shown. It is called with the
+// lock held.
+func (w *wall) shows(name string) bool {
+	for _, c := range w.clocks {
+		if c.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// discover updates the wall with the clocks of the source every interval,
+// until done is closed. The wall is left as it is while the source fails.
+func (w *wall) discover(src discovery.Source, every time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	failing := false
+	for {
+		found, err := src.Clocks()
+		if err != nil {
+			if !failing {
+				log.Printf("discovery: %v", err)
+			}
+		} else {
+			if failing {
+				log.Printf("discovery: recovered")
+			}
+			w.update(found)
+		}
+		failing = err != nil
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
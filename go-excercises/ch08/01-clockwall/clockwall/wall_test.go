@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/discovery"
+)
+
+func TestWallUpdate(t *testing.T) {
+	w := newWall()
+	static := newClock("lab", "localhost:1", "")
+	w.add(static)
+	shown := func() []string {
+		var s []string
+		for _, c := range w.list() {
+			s = append(s, c.name+"="+c.addr+"/"+c.zone)
+		}
+		return s
+	}
+
+	for _, test := range []struct {
+		found []discovery.Clock
+		want  []string
+	}{
+		{
+			[]discovery.Clock{{Name: "tokyo", Zone: "Asia/Tokyo", Addr: "localhost:2"}, {Name: "lab", Addr: "localhost:9"}},
+			[]string{"lab=localhost:1/", "tokyo=localhost:2/Asia/Tokyo"},
+		},
+		{
+			[]discovery.Clock{{Name: "tokyo", Zone: "Asia/Tokyo", Addr: "localhost:2"}, {Name: "paris", Addr: "localhost:3"}},
+			[]string{"lab=localhost:1/", "tokyo=localhost:2/Asia/Tokyo", "paris=localhost:3/"},
+		},
+		{
+			// Moved to another server
+			[]discovery.Clock{{Name: "tokyo", Zone: "Asia/Tokyo", Addr: "localhost:4"}, {Name: "paris", Addr: "localhost:3"}},
+			[]string{"lab=localhost:1/", "paris=localhost:3/", "tokyo=localhost:4/Asia/Tokyo"},
+		},
+		{
+			nil,
+			[]string{"lab=localhost:1/"},
+		},
+	} {
+		w.update(test.found)
+		if got := shown(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("update(%+v) shows %v, want %v", test.found, got, test.want)
+		}
+	}
+	if len(w.stops) != 1 || w.stops[static] == nil {
+		t.Errorf("%d clocks running, want only the static one", len(w.stops))
+	}
+	close(w.stops[static])
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webRefresh is how often the clocks are looked at for changes to push
+const webRefresh = 250 * time.Millisecond
+
+// heartbeatInterval keeps the idle event streams alive through the proxies
+const heartbeatInterval = 15 * time.Second
+
+// clockJSON is a clock as served to the page and to the other tools. The
+// measures are missing until they are known.
+type clockJSON struct {
+	Name      string   `json:"name"`
+	Addr      string   `json:"addr"`
+	Status    string   `json:"status"`
+	Error     string   `json:"error,omitempty"`
+	Time      string   `json:"time,omitempty"` // the last tick, RFC 3339 in the zone, or 15:04:05 of a legacy server
+	Zone      string   `json:"zone,omitempty"`
+	Abbrev    string   `json:"abbrev,omitempty"`
+	UTCOffset string   `json:"utc_offset,omitempty"`
+	DriftMs   *int64   `json:"drift_ms,omitempty"`  // ahead of the local time, to the second
+	OffsetMs  *float64 `json:"offset_ms,omitempty"` // of the server clock, as measured
+	RTTMs     *float64 `json:"rtt_ms,omitempty"`
+	Alert     bool     `json:"alert,omitempty"` // the offset is over -max-offset
+}
+
+// clocksJSON returns the clocks of the wall at now
+func clocksJSON(clocks []*clock, now time.Time) []clockJSON {
+	list := make([]clockJSON, 0, len(clocks))
+	for _, c := range clocks {
+		v := c.snapshot(now)
+		j := clockJSON{
+			Name:      v.name,
+			Addr:      c.addr,
+			Status:    string(v.status),
+			Time:      v.last.Time,
+			Zone:      v.last.Zone,
+			Abbrev:    v.last.Abbrev,
+			UTCOffset: v.last.Offset,
+			Alert:     v.alert,
+		}
+		if v.err != nil && v.status != connected {
+			j.Error = v.err.Error()
+		}
+		if d, ok := v.drift(); ok {
+			ms := d.Milliseconds()
+			j.DriftMs = &ms
+		}
+		if v.synced {
+			offset, rtt := millis(v.sync.Offset), millis(v.sync.Delay)
+			j.OffsetMs, j.RTTMs = &offset, &rtt
+		}
+		list = append(list, j)
+	}
+	return list
+}
+
+// millis returns the duration in milliseconds to the microsecond
+func millis(d time.Duration) float64 {
+	return float64(d.Round(time.Microsecond)) / float64(time.Millisecond)
+}
+
+// webServer serves the clocks of the wall as a page updated live with
+// Server-Sent Events, and as JSON. The clocks are encoded once for all the
+// pages, whenever they change.
+type webServer struct {
+	wall *wall
+
+	mu      sync.Mutex
+	data    []byte        // the JSON of the clocks last published
+	changed chan struct{} // closed when the data changes
+
+	closing <-chan struct{} // ends the event streams
+}
+
+// newWebServer creates the server of the wall, which stops streaming once
+// done is closed
+func newWebServer(w *wall, done <-chan struct{}) *webServer {
+	return &webServer{wall: w, data: []byte("[]"), changed: make(chan struct{}), closing: done}
+}
+
+// run publishes the clocks every webRefresh until done is closed
+func (s *webServer) run(done <-chan struct{}) {
+	ticker := time.NewTicker(webRefresh)
+	defer ticker.Stop()
+	for {
+		s.publish(time.Now())
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publish encodes the clocks at now, and wakes up the streams if they changed
+func (s *webServer) publish(now time.Time) {
+	data, err := json.Marshal(clocksJSON(s.wall.list(), now))
+	if err != nil {
+		log.Printf("web: %v", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bytes.Equal(data, s.data) {
+		return
+	}
+	s.data = data
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// current returns the clocks last published, and the channel closed when
+// they change
+func (s *webServer) current() ([]byte, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data, s.changed
+}
+
+// handler routes the page, the JSON and the events
+func (s *webServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.page)
+	mux.HandleFunc("/clocks", s.clocks)
+	mux.HandleFunc("/events", s.events)
+	return mux
+}
+
+// page serves the wall page
+func (s *webServer) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, wallPage)
+}
+
+// clocks serves the clocks as JSON
+//
+//	curl "http://localhost:8080/clocks"
+func (s *webServer) clocks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(clocksJSON(s.wall.list(), time.Now()))
+}
+
+// events streams the clocks as Server-Sent Events, one "clocks" event with
+// all of them at each change
+//
+//	curl -N "http://localhost:8080/events"
+func (s *webServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	data, changed := s.current()
+	if _, err := fmt.Fprintf(w, "event: clocks\ndata: %s\n\n", data); err != nil {
+		return
+	}
+	flusher.Flush()
+	for {
+		var err error
+		select {
+		case <-changed:
+			data, changed = s.current()
+			_, err = fmt.Fprintf(w, "event: clocks\ndata: %s\n\n", data)
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": ping\n\n")
+		case <-r.Context().Done():
+			return
+		case <-s.closing:
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// serveWeb serves the wall on the address until done is closed
+func serveWeb(w *wall, addr string, done <-chan struct{}) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := newWebServer(w, done)
+	go s.run(done)
+	srv := &http.Server{Handler: s.handler()}
+	go func() {
+		<-done
+		srv.Close()
+	}()
+	log.Printf("Serving the clocks on http://%s", ln.Addr())
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// wallPage shows the clocks in large cards for a screen across the room.
+// The names and errors are set as text, so the servers cannot inject HTML.
+const wallPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>clockwall</title>
+<style>
+  body { background: #111; color: #eee; font-family: sans-serif; margin: 2vw; }
+  header { display: flex; justify-content: space-between; color: #888; font-size: 2vw; margin-bottom: 2vw; }
+  #clocks { display: grid; grid-template-columns: repeat(auto-fill, minmax(22vw, 1fr)); gap: 2vw; }
+  .card { background: #222; border-radius: 1vw; padding: 1.5vw; }
+  .name { font-size: 2.2vw; font-weight: bold; }
+  .time { font-size: 5vw; font-variant-numeric: tabular-nums; margin: 0.5vw 0; }
+  .meta { font-size: 1.3vw; color: #aaa; min-height: 1.6vw; }
+  .connected .status { color: #4c4; }
+  .status { color: #e44; }
+  .stale .time { color: #fa0; }
+  .alert .sync { color: #e44; font-weight: bold; }
+  #offline { color: #e44; display: none; }
+</style>
+</head>
+<body>
+<header><span>clockwall <span id="local"></span></span><span id="offline">reconnecting...</span></header>
+<div id="clocks"></div>
+<script>
+function text(cls, s) {
+  const el = document.createElement("div");
+  el.className = cls;
+  el.textContent = s;
+  return el;
+}
+
+function signed(ms) {
+  return (ms < 0 ? "" : "+") + (Math.abs(ms) >= 1000 ? (ms / 1000).toFixed(3) + "s" : ms.toFixed(3) + "ms");
+}
+
+function render(clocks) {
+  const root = document.getElementById("clocks");
+  root.replaceChildren();
+  for (const c of clocks) {
+    const card = document.createElement("div");
+    card.className = "card " + c.status + (c.alert ? " alert" : "");
+    let time = "--:--:--", date = "";
+    if (c.time) {
+      time = c.time.length > 8 ? c.time.substring(11, 19) : c.time;
+      date = c.time.length > 8 ? c.time.substring(0, 10) + " " : "";
+    }
+    card.append(
+      text("name", c.name),
+      text("time", time),
+      text("meta", date + (c.abbrev || "") + " " + (c.utc_offset || "")),
+      text("meta", "drift " + (c.drift_ms === undefined ? "n/a" : signed(c.drift_ms))),
+      text("meta sync", c.offset_ms === undefined ? "offset n/a" : "offset " + signed(c.offset_ms) + " rtt " + c.rtt_ms.toFixed(3) + "ms"),
+      text("meta status", c.status),
+      text("meta", c.error || ""));
+    root.append(card);
+  }
+}
+
+const events = new EventSource("events");
+const offline = document.getElementById("offline");
+events.addEventListener("clocks", e => {
+  offline.style.display = "none";
+  render(JSON.parse(e.data));
+});
+events.onerror = () => { offline.style.display = "inline"; };
+
+setInterval(() => {
+  document.getElementById("local").textContent = "local " + new Date().toLocaleTimeString();
+}, 250);
+</script>
+</body>
+</html>
+`
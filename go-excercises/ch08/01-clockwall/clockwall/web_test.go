@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rajkumar-km/go-play/go-excercises/ch08/01-clockwall/clockproto"
+)
+
+func TestWebServer(t *testing.T) {
+	tokyo := newClock("tokyo", "tokyo:8001", "Asia/Tokyo")
+	tokyo.setState(connected, nil)
+	tokyo.tick(clockproto.Frame{Type: clockproto.FrameTick, Time: time.Now().Format(time.RFC3339), Zone: "Asia/Tokyo", Abbrev: "JST", Offset: "+09:00"})
+	tokyo.measure(clockproto.Sample{Delay: 2 * time.Millisecond, Offset: -1500 * time.Microsecond})
+	down := newClock("down", "down:8001", "")
+	down.setState(reconnecting, errors.New("connection refused"))
+
+	w := newWall()
+	w.clocks = []*clock{tokyo, down} // not run
+	done := make(chan struct{})
+	defer close(done)
+	s := newWebServer(w, done)
+	s.publish(time.Now())
+	srv := httptest.NewServer(s.handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/clocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []clockJSON
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("clocks = %+v, want 2", list)
+	}
+	if c := list[0]; c.Name != "tokyo" || c.Status != "connected" || c.Abbrev != "JST" || c.OffsetMs == nil || *c.OffsetMs != -1.5 || c.DriftMs == nil {
+		t.Errorf("tokyo = %+v, want connected in JST with an offset of -1.5ms", c)
+	}
+	if c := list[1]; c.Status != "reconnecting" || c.Error != "connection refused" || c.Time != "" || c.OffsetMs != nil {
+		t.Errorf("down = %+v, want reconnecting with no tick", c)
+	}
+
+	resp, err = http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("page content type = %q, want HTML", ct)
+	}
+
+	// The stream starts with the clocks, and follows their changes
+	resp, err = http.Get(srv.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	next := func() []clockJSON {
+		t.Helper()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data := strings.TrimPrefix(line, "data: "); data != line {
+				var list []clockJSON
+				if err := json.Unmarshal([]byte(data), &list); err != nil {
+					t.Fatal(err)
+				}
+				return list
+			}
+		}
+	}
+	if list := next(); len(list) != 2 {
+		t.Errorf("first event = %+v, want 2 clocks", list)
+	}
+	w.mu.Lock()
+	w.clocks = w.clocks[:1]
+	w.mu.Unlock()
+	s.publish(time.Now())
+	if list := next(); len(list) != 1 || list[0].Name != "tokyo" {
+		t.Errorf("event after the change = %+v, want tokyo only", list)
+	}
+}
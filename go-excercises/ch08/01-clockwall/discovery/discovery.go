@@ -0,0 +1,238 @@
+/*
+Package discovery finds the clock servers without typing their addresses
+
+The clock servers announce themselves with a TTL, and repeat the
+announcement as a heartbeat before it expires. They announce to a registry
+over HTTP, or to the LAN over UDP multicast:
+
+	PUT /clocks/lab1 {"zone":"Europe/London","addr":"lab1.local:8001","ttl":30}
+	GET /clocks
+	DELETE /clocks/lab1
+
+A client polls the registry, or listens to the multicast group with a local
+Registry, and gets the clocks alive.
+*/
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxTTL is the longest time an announcement is kept
+const MaxTTL = 10 * time.Minute
+
+// Clock is an announced clock server
+type Clock struct {
+	Name string `json:"name"`
+	Zone string `json:"zone,omitempty"` // IANA time zone, the server default if empty
+	Addr string `json:"addr"`           // host:port of the server
+	TTL  int    `json:"ttl"`            // seconds the announcement lasts, 0 to withdraw it
+}
+
+// nameRE matches the names of the clocks
+var nameRE = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// validate checks the announcement
+func (c Clock) validate() error {
+	if !nameRE.MatchString(c.Name) {
+		return fmt.Errorf("invalid clock name %q", c.Name)
+	}
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		return fmt.Errorf("invalid clock address %q", c.Addr)
+	}
+	if c.TTL < 0 || time.Duration(c.TTL)*time.Second > MaxTTL {
+		return fmt.Errorf("ttl must be between 0 and %d seconds", int(MaxTTL.Seconds()))
+	}
+	return nil
+}
+
+// Source lists the clocks alive
+type Source interface {
+	Clocks() ([]Clock, error)
+}
+
+// Registry keeps the clocks announced until their TTL expires. It serves
+// them over HTTP.
+type Registry struct {
+	mu      sync.Mutex
+	clocks  map[string]Clock
+	expires map[string]time.Time
+
+	now func() time.Time // the clock, replaced by the tests
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{clocks: make(map[string]Clock), expires: make(map[string]time.Time), now: time.Now}
+}
+
+// Announce adds or refreshes the clock, or removes it with a zero TTL
+func (r *Registry) Announce(c Clock) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c.TTL == 0 {
+		delete(r.clocks, c.Name)
+		delete(r.expires, c.Name)
+		return nil
+	}
+	r.clocks[c.Name] = c
+	r.expires[c.Name] = r.now().Add(time.Duration(c.TTL) * time.Second)
+	return nil
+}
+
+// Clocks returns the clocks alive sorted by name, and forgets the expired ones
+func (r *Registry) Clocks() ([]Clock, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	list := make([]Clock, 0, len(r.clocks))
+	for name, c := range r.clocks {
+		if !now.Before(r.expires[name]) {
+			delete(r.clocks, name)
+			delete(r.expires, name)
+			continue
+		}
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// ServeHTTP serves /clocks and /clocks/{name}
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, "/clocks")
+	switch {
+	case name == "" && req.Method == http.MethodGet:
+		list, _ := r.Clocks()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case strings.HasPrefix(name, "/") && (req.Method == http.MethodPut || req.Method == http.MethodDelete):
+		var c Clock
+		if req.Method == http.MethodPut {
+			if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, 4096)).Decode(&c); err != nil {
+				http.Error(w, "invalid clock: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if c.TTL == 0 {
+				http.Error(w, "ttl is required, DELETE withdraws a clock", http.StatusBadRequest)
+				return
+			}
+		} else {
+			c.Addr = ":0" // only the name matters to withdraw
+		}
+		c.Name = name[1:]
+		if err := r.Announce(c); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
+// RemoteRegistry is the registry of a clockregistry server
+type RemoteRegistry struct {
+	URL    string // such as http://localhost:8090
+	Client *http.Client
+}
+
+// client returns the HTTP client, with a timeout by default
+func (r *RemoteRegistry) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+// Clocks returns the clocks alive in the registry
+func (r *RemoteRegistry) Clocks() ([]Clock, error) {
+	resp, err := r.client().Get(strings.TrimSuffix(r.URL, "/") + "/clocks")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry: %s", resp.Status)
+	}
+	var list []Clock
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("registry: %v", err)
+	}
+	return list, nil
+}
+
+// Announce sends the announcement to the registry, or withdraws it with a
+// zero TTL
+func (r *RemoteRegistry) Announce(c Clock) error {
+	method := http.MethodPut
+	if c.TTL == 0 {
+		method = http.MethodDelete
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(r.URL, "/")+"/clocks/"+c.Name, strings.NewReader(string(b)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("registry: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Announcer sends announcements, to a registry or a multicast group
+type Announcer interface {
+	Announce(c Clock) error
+}
+
+// Heartbeat announces the clock every third of its TTL until done is
+// closed, and then withdraws it. The failures are reported to logf, if not
+// nil, and retried on the next beat. Only an invalid clock is an error.
+func Heartbeat(a Announcer, c Clock, done <-chan struct{}, logf func(format string, args ...interface{})) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	if c.TTL == 0 {
+		return errors.New("heartbeat needs a ttl")
+	}
+	ticker := time.NewTicker(time.Duration(c.TTL) * time.Second / 3)
+	defer ticker.Stop()
+	for {
+		if err := a.Announce(c); err != nil && logf != nil {
+			logf("announcing clock %s: %v", c.Name, err)
+		}
+		select {
+		case <-done:
+			bye := c
+			bye.TTL = 0
+			a.Announce(bye)
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
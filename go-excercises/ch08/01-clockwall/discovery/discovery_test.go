@@ -0,0 +1,135 @@
+package discovery
+
+import (
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRegistryExpiry(t *testing.T) {
+	now := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	r := NewRegistry()
+	r.now = func() time.Time { return now }
+
+	for _, c := range []Clock{
+		{Name: "tokyo", Zone: "Asia/Tokyo", Addr: "tokyo:8001", TTL: 30},
+		{Name: "london", Addr: "london:8001", TTL: 10},
+	} {
+		if err := r.Announce(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func() []string {
+		list, _ := r.Clocks()
+		var names []string
+		for _, c := range list {
+			names = append(names, c.Name)
+		}
+		return names
+	}
+	if got, want := names(), []string{"london", "tokyo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("clocks = %v, want %v", got, want)
+	}
+
+	now = now.Add(10 * time.Second)
+	if got, want := names(), []string{"tokyo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("clocks after 10s = %v, want %v", got, want)
+	}
+
+	// The heartbeat extends the TTL
+	now = now.Add(15 * time.Second)
+	r.Announce(Clock{Name: "tokyo", Zone: "Asia/Tokyo", Addr: "tokyo:8001", TTL: 30})
+	now = now.Add(15 * time.Second)
+	if got, want := names(), []string{"tokyo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("clocks after the heartbeat = %v, want %v", got, want)
+	}
+
+	r.Announce(Clock{Name: "tokyo", Addr: "tokyo:8001"})
+	if got := names(); got != nil {
+		t.Errorf("clocks after the withdrawal = %v, want none", got)
+	}
+
+	for _, c := range []Clock{
+		{Name: "bad name", Addr: "x:1", TTL: 1},
+		{Name: "x", Addr: "x", TTL: 1},
+		{Name: "x", Addr: "x:1", TTL: -1},
+		{Name: "x", Addr: "x:1", TTL: 3600},
+	} {
+		if err := r.Announce(c); err == nil {
+			t.Errorf("Announce(%+v) succeeded, want an error", c)
+		}
+	}
+}
+
+func TestRemoteRegistry(t *testing.T) {
+	srv := httptest.NewServer(NewRegistry())
+	defer srv.Close()
+	remote := &RemoteRegistry{URL: srv.URL}
+
+	done := make(chan struct{})
+	stopped := make(chan error)
+	lab := Clock{Name: "lab1", Zone: "Europe/London", Addr: "lab1.local:8001", TTL: 30}
+	go func() { stopped <- Heartbeat(remote, lab, done, t.Logf) }()
+
+	var list []Clock
+	for i := 0; i < 100 && len(list) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		if list, err = remote.Clocks(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []Clock{lab}; !reflect.DeepEqual(list, want) {
+		t.Errorf("clocks = %+v, want %+v", list, want)
+	}
+
+	close(done)
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := remote.Clocks(); len(list) != 0 {
+		t.Errorf("clocks after the heartbeat stopped = %+v, want none", list)
+	}
+
+	if err := remote.Announce(Clock{Name: "lab1", Addr: "nohost", TTL: 30}); err == nil {
+		t.Error("invalid announcement succeeded")
+	}
+}
+
+func TestListen(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewRegistry()
+	stopped := make(chan error)
+	go func() { stopped <- Listen(pc, r, t.Logf) }()
+
+	out, err := net.Dial("udp4", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	for _, msg := range []string{
+		`not json`,
+		`{"name":"lab2","addr":":8001","ttl":30}`,
+	} {
+		out.Write([]byte(msg))
+	}
+
+	var list []Clock
+	for i := 0; i < 100 && len(list) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		list, _ = r.Clocks()
+	}
+	if want := []Clock{{Name: "lab2", Addr: "127.0.0.1:8001", TTL: 30}}; !reflect.DeepEqual(list, want) {
+		t.Errorf("clocks = %+v, want %+v", list, want)
+	}
+
+	pc.Close()
+	if err := <-stopped; err == nil {
+		t.Error("Listen returned no error once closed")
+	}
+}
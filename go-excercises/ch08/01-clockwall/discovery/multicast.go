@@ -0,0 +1,88 @@
+package discovery
+
+import (
+	"encoding/json"
+	"net"
+)
+
+// DefaultGroup is the UDP multicast group of the announcements on the LAN
+const DefaultGroup = "239.255.70.77:7707"
+
+// maxDatagram is the largest announcement read
+const maxDatagram = 2048
+
+// Multicast sends the announcements as datagrams to a multicast group
+type Multicast struct {
+	conn *net.UDPConn
+}
+
+// DialMulticast opens the group to send announcements to
+func DialMulticast(group string) (*Multicast, error) {
+	addr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Multicast{conn: conn}, nil
+}
+
+// Announce sends the announcement, or withdraws it with a zero TTL
+func (m *Multicast) Announce(c Clock) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = m.conn.Write(b)
+	return err
+}
+
+// Close closes the connection to the group
+func (m *Multicast) Close() error {
+	return m.conn.Close()
+}
+
+// ListenMulticast joins the group to receive the announcements
+func ListenMulticast(group string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadBuffer(64 << 10)
+	return conn, nil
+}
+
+// Listen adds the announcements received on the connection to the registry,
+// until the connection is closed. An address announced without a host, such
+// as ":8001", gets the host the datagram came from. The invalid datagrams
+// are reported to logf, if not nil, and dropped.
+func Listen(pc net.PacketConn, r *Registry, logf func(format string, args ...interface{})) error {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		var c Clock
+		if err := json.Unmarshal(buf[:n], &c); err != nil {
+			if logf != nil {
+				logf("announcement from %s: %v", from, err)
+			}
+			continue
+		}
+		if host, port, err := net.SplitHostPort(c.Addr); err == nil && host == "" {
+			if udp, ok := from.(*net.UDPAddr); ok {
+				c.Addr = net.JoinHostPort(udp.IP.String(), port)
+			}
+		}
+		if err := r.Announce(c); err != nil && logf != nil {
+			logf("announcement from %s: %v", from, err)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package httplimit protects HTTP servers from abusive clients. It limits
+// the request rate of each client IP and API key with token buckets, the
+// number of requests served at once, and the size of the query and body.
+//
+//	limiter := httplimit.New(httplimit.Config{IPRate: 10, IPBurst: 20, MaxConcurrent: 100})
+//	http.ListenAndServe("localhost:8000", limiter.Handler(mux))
+//
+// The requests over a rate or the concurrency cap get 429 Too Many Requests
+// with a Retry-After header telling the client when to try again.
+package httplimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config sets the limits. The zero value of a field disables its limit.
+type Config struct {
+	// IPRate is the sustained requests per second of a client IP, and
+	// IPBurst the requests it can make at once after being idle
+	IPRate  float64
+	IPBurst int
+
+	// KeyRate and KeyBurst are the same per client, as returned by KeyFunc,
+	// or none for "". KeyFunc should name only the authenticated clients:
+	// ahead of the authentication, the raw APIKey gives a fresh bucket to
+	// every made-up key, so such a limiter belongs after the authentication.
+	KeyRate  float64
+	KeyBurst int
+	KeyFunc  func(r *http.Request) string // APIKey if nil
+
+	MaxConcurrent int   // requests served at once
+	MaxBodyBytes  int64 // request body size, 413 when larger
+	MaxQueryBytes int   // raw query string length, 414 when longer
+
+	// Reject writes the error response, http.Error if nil. It is given the
+	// status code and a message for the client.
+	Reject func(w http.ResponseWriter, r *http.Request, status int, msg string)
+}
+
+// APIKey returns the key of the X-API-Key header or of the Bearer
+// authorization, or "" when there is none
+func APIKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return ""
+}
+
+// bucket is a token bucket refilled at the rate up to the burst
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// buckets are the token buckets of the clients by their IP or key
+type buckets struct {
+	rate  float64
+	burst float64
+	m     map[string]*bucket
+}
+
+// take removes a token from the bucket of the client. It returns false
+// with the time until the next token when the bucket is empty.
+func (b *buckets) take(client string, now time.Time) (bool, time.Duration) {
+	bk, ok := b.m[client]
+	if !ok {
+		bk = &bucket{tokens: b.burst, last: now}
+		b.m[client] = bk
+	}
+	bk.tokens = math.Min(b.burst, bk.tokens+now.Sub(bk.last).Seconds()*b.rate)
+	bk.last = now
+	if bk.tokens >= 1 {
+		bk.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bk.tokens) / b.rate * float64(time.Second))
+}
+
+// sweep forgets the buckets that are full again, as they are the same as
+// new ones. It keeps the memory bounded with many short-lived clients.
+func (b *buckets) sweep(now time.Time) {
+	for client, bk := range b.m {
+		if bk.tokens+now.Sub(bk.last).Seconds()*b.rate >= b.burst {
+			delete(b.m, client)
+		}
+	}
+}
+
+// sweepInterval is how often the idle buckets are forgotten
+const sweepInterval = time.Minute
+
+// Limiter enforces a Config on the requests of its handlers
+type Limiter struct {
+	cfg Config
+	sem chan struct{} // a slot per request served at once
+
+	mu        sync.Mutex
+	ips, keys *buckets
+	lastSweep time.Time
+	now       func() time.Time // the clock, replaced by the tests
+}
+
+// New creates a limiter of the config
+func New(cfg Config) *Limiter {
+	if cfg.KeyFunc == nil {
+		cfg.KeyFunc = APIKey
+	}
+	if cfg.Reject == nil {
+		cfg.Reject = func(w http.ResponseWriter, r *http.Request, status int, msg string) {
+			http.Error(w, msg, status)
+		}
+	}
+	l := &Limiter{cfg: cfg, now: time.Now}
+	if cfg.IPRate > 0 {
+		l.ips = &buckets{rate: cfg.IPRate, burst: math.Max(1, float64(cfg.IPBurst)), m: make(map[string]*bucket)}
+	}
+	if cfg.KeyRate > 0 {
+		l.keys = &buckets{rate: cfg.KeyRate, burst: math.Max(1, float64(cfg.KeyBurst)), m: make(map[string]*bucket)}
+	}
+	if cfg.MaxConcurrent > 0 {
+		l.sem = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return l
+}
+
+// clientIP returns the IP of the connection. The X-Forwarded-For header is
+// not trusted, as any client can set it.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allow takes a token of the client IP and of the API key of the request.
+// It returns false with the time to wait when either is exhausted.
+func (l *Limiter) allow(r *http.Request) (bool, time.Duration) {
+	if l.ips == nil && l.keys == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		for _, b := range []*buckets{l.ips, l.keys} {
+			if b != nil {
+				b.sweep(now)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	if l.ips != nil {
+		if ok, wait := l.ips.take(clientIP(r), now); !ok {
+			return false, wait
+		}
+	}
+	if key := l.cfg.KeyFunc(r); l.keys != nil && key != "" {
+		if ok, wait := l.keys.take(key, now); !ok {
+			return false, wait
+		}
+	}
+	return true, 0
+}
+
+// tooMany rejects the request with 429 and the seconds to wait
+func (l *Limiter) tooMany(w http.ResponseWriter, r *http.Request, wait time.Duration, msg string) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	l.cfg.Reject(w, r, http.StatusTooManyRequests, msg)
+}
+
+// Handler applies the limits before the requests reach h
+func (l *Limiter) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The sizes are checked first, as they are cheap and never change on retry
+		if max := l.cfg.MaxQueryBytes; max > 0 && len(r.URL.RawQuery) > max {
+			l.cfg.Reject(w, r, http.StatusRequestURITooLong, "query string too long")
+			return
+		}
+		if max := l.cfg.MaxBodyBytes; max > 0 {
+			if r.ContentLength > max {
+				l.cfg.Reject(w, r, http.StatusRequestEntityTooLarge, "request body too large")
+				return
+			}
+			// Bodies of unknown length fail on reading past the limit
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+		}
+
+		if ok, wait := l.allow(r); !ok {
+			l.tooMany(w, r, wait, "rate limit exceeded")
+			return
+		}
+
+		if l.sem != nil {
+			select {
+			case l.sem <- struct{}{}:
+				defer func() { <-l.sem }()
+			default:
+				l.tooMany(w, r, time.Second, "too many requests in progress")
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
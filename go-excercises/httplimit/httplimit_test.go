@@ -0,0 +1,150 @@
+package httplimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock advanced by the tests
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestLimiter(cfg Config) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := New(cfg)
+	l.now = clock.now
+	return l, clock
+}
+
+var ok = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if _, err := io.ReadAll(r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	}
+})
+
+// do serves a request from the client address with the API key
+func do(h http.Handler, addr, key, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", target, nil)
+	r.RemoteAddr = addr
+	if key != "" {
+		r.Header.Set("X-API-Key", key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimit(t *testing.T) {
+	l, clock := newTestLimiter(Config{IPRate: 1, IPBurst: 2, KeyRate: 0.5, KeyBurst: 3})
+	h := l.Handler(ok)
+
+	steps := []struct {
+		advance    time.Duration
+		addr, key  string
+		want       int
+		retryAfter string
+	}{
+		{0, "10.0.0.1:1000", "", 200, ""},
+		{0, "10.0.0.1:1001", "", 200, ""}, // same IP, other port
+		{0, "10.0.0.1:1002", "", 429, "1"},
+		{0, "10.0.0.2:1000", "", 200, ""}, // other IPs have their own bucket
+		{time.Second, "10.0.0.1:1000", "", 200, ""},
+		{0, "10.0.0.1:1000", "", 429, "1"},
+
+		// The key is limited across the IPs
+		{0, "10.0.1.1:1000", "k", 200, ""},
+		{0, "10.0.1.2:1000", "k", 200, ""},
+		{0, "10.0.1.3:1000", "k", 200, ""},
+		{0, "10.0.1.4:1000", "k", 429, "2"},
+		{0, "10.0.1.4:1000", "other", 200, ""},
+	}
+	for i, s := range steps {
+		clock.t = clock.t.Add(s.advance)
+		w := do(h, s.addr, s.key, "/")
+		if w.Code != s.want {
+			t.Errorf("step %d: status = %d, want %d", i, w.Code, s.want)
+		}
+		if got := w.Header().Get("Retry-After"); got != s.retryAfter {
+			t.Errorf("step %d: Retry-After = %q, want %q", i, got, s.retryAfter)
+		}
+	}
+
+	// The idle buckets are forgotten, the ones still refilling are kept
+	clock.t = clock.t.Add(sweepInterval)
+	do(h, "10.0.0.3:1000", "", "/")
+	if n := len(l.ips.m); n != 1 {
+		t.Errorf("%d IP buckets after the sweep, want 1", n)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	l := New(Config{MaxConcurrent: 1})
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}))
+
+	done := make(chan int)
+	go func() { done <- do(h, "10.0.0.1:1000", "", "/").Code }()
+	<-started
+
+	w := do(h, "10.0.0.2:1000", "", "/")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("over the cap: status = %d, Retry-After = %q, want 429 with Retry-After",
+			w.Code, w.Header().Get("Retry-After"))
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("first request: status = %d, want 200", code)
+	}
+
+	// The slot is released for the next request
+	h = l.Handler(ok)
+	if w := do(h, "10.0.0.2:1000", "", "/"); w.Code != http.StatusOK {
+		t.Errorf("after release: status = %d, want 200", w.Code)
+	}
+}
+
+func TestSizeLimits(t *testing.T) {
+	var rejected []int
+	l := New(Config{
+		MaxQueryBytes: 10,
+		MaxBodyBytes:  5,
+		Reject: func(w http.ResponseWriter, r *http.Request, status int, msg string) {
+			rejected = append(rejected, status)
+			http.Error(w, msg, status)
+		},
+	})
+	h := l.Handler(ok)
+
+	tests := []struct {
+		target string
+		body   io.Reader
+		length int64 // -1 for unknown
+		want   int
+	}{
+		{"/?a=1", strings.NewReader("12345"), 5, 200},
+		{"/?a=123456789", nil, 0, 414},
+		{"/", strings.NewReader("123456"), 6, 413},
+		{"/", io.MultiReader(strings.NewReader("123456")), -1, 413}, // caught while reading
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", test.target, test.body)
+		r.ContentLength = test.length
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s with %d bytes: status = %d, want %d", test.target, test.length, w.Code, test.want)
+		}
+	}
+	if len(rejected) != 2 {
+		t.Errorf("Reject called for %v, want the query and the known length", rejected)
+	}
+}